@@ -0,0 +1,39 @@
+package main
+
+// Control API request structs
+type ApiQueryRequest struct {
+	Query    string         `json:"query"`
+	Region   string         `json:"region"`
+	Metadata *WatchMetadata `json:"metadata"`
+}
+
+// Control API response structs
+
+type ApiResponse struct {
+	Success bool         `json:"success"`
+	Message string       `json:"message,omitempty"`
+	List    []string     `json:"list,omitempty"`
+	Entries []WatchEntry `json:"entries,omitempty"`
+}
+
+type ApiTaskGroupStatus struct {
+	Region    string           `json:"region"`
+	GroupType string           `json:"groupType"`
+	Tasks     []TaskStatusInfo `json:"tasks"`
+}
+
+type TaskStatusInfo struct {
+	Name   string `json:"name"`
+	Status Status `json:"status"`
+}
+
+type ApiProxyPool struct {
+	Proxyfile string      `json:"proxyfile"`
+	Proxies   []ProxyInfo `json:"proxies"`
+}
+
+type ProxyInfo struct {
+	Host  string `json:"host"`
+	Port  string `json:"port"`
+	InUse int    `json:"inUse"`
+}
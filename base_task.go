@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"sync"
+	"time"
 
 	tls_client "github.com/bogdanfinn/tls-client"
 	"github.com/bogdanfinn/tls-client/profiles"
@@ -27,6 +28,7 @@ type BaseTask struct {
 	mu             sync.Mutex
 	ctx            context.Context
 	cancelCtx      context.CancelFunc
+	loopDoneCh     chan struct{}
 	runCallback    func()
 	stopCallback   func()
 	taskName       string
@@ -63,6 +65,7 @@ func NewBaseTask(taskName string, runCallback func(), stopCallback func(), proxy
 		mu:             sync.Mutex{},
 		ctx:            ctx,
 		cancelCtx:      cancelCtx,
+		loopDoneCh:     make(chan struct{}),
 		runCallback:    runCallback,
 		stopCallback:   stopCallback,
 		taskName:       taskName,
@@ -84,10 +87,15 @@ func (b *BaseTask) start() error {
 
 	b.updateStatus(StatusRunning)
 
+	ctx := b.ctx
+
 	go func() {
+		defer close(b.loopDoneCh)
+		defer b.releaseProxy()
+
 		for {
 			select {
-			case <-b.ctx.Done():
+			case <-ctx.Done():
 				return
 			default:
 			}
@@ -123,14 +131,39 @@ func (b *BaseTask) GetStatus() Status {
 	return b.ctx.Value(statusKey).(Status)
 }
 
+// Blocks until the started task was stopped and finished its current iteration
 func (b *BaseTask) WaitForTermination() {
-	<-b.ctx.Done()
+	<-b.loopDoneCh
+}
+
+// Returns false if the task was stopped before the duration passed
+func (b *BaseTask) sleep(duration time.Duration) bool {
+	b.mu.Lock()
+	done := b.ctx.Done()
+	b.mu.Unlock()
+
+	select {
+	case <-time.After(duration):
+		return true
+	case <-done:
+		return false
+	}
 }
 
 func (b *BaseTask) updateStatus(status Status) {
 	b.ctx = context.WithValue(b.ctx, statusKey, status)
 }
 
+// Frees the proxy for other tasks once the task stopped
+func (b *BaseTask) releaseProxy() {
+	b.mu.Lock()
+	p := b.proxy
+	b.proxy = nil
+	b.mu.Unlock()
+
+	b.proxyHandler.ReleaseProxy(p)
+}
+
 func (b *BaseTask) rotateProxy() {
 	b.proxyHandler.ReleaseProxy(b.proxy)
 
@@ -2,20 +2,39 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"math/rand"
+	"slices"
 	"sync"
 	"time"
 )
 
+const (
+	TASK_TYPE_NORMAL = "NORMAL"
+	TASK_TYPE_LOAD   = "LOAD"
+)
+
 type BaseTaskGroup struct {
 	mu             sync.Mutex
+	region         string
+	taskType       string
+	states         *RegionProductStates
+	backend        StoreBackend
 	proxyHandler   *ProxyHandler
 	webhookHandler *WebhookHandler
 	logger         *Logger
 	baseTasks      []*BaseTask
+	stopped        bool
+	stopCh         chan struct{} // Closed by StopAllTasks, interrupts work outside of the tasks
 }
 
-func NewBaseTaskGroup(taskName string, proxyHandler *ProxyHandler, webhookHandler *WebhookHandler) (*BaseTaskGroup, error) {
+func NewBaseTaskGroup(taskType string, region string, states *RegionProductStates, backend StoreBackend, proxyHandler *ProxyHandler, webhookHandler *WebhookHandler) (*BaseTaskGroup, error) {
+	if states == nil {
+		return nil, errors.New("region product states reference nil")
+	}
+	if backend == nil {
+		return nil, errors.New("store backend reference nil")
+	}
 	if proxyHandler == nil {
 		return nil, errors.New("proxy handler reference nil")
 	}
@@ -24,9 +43,14 @@ func NewBaseTaskGroup(taskName string, proxyHandler *ProxyHandler, webhookHandle
 	}
 
 	return &BaseTaskGroup{
+		region:         region,
+		taskType:       taskType,
+		states:         states,
+		backend:        backend,
 		proxyHandler:   proxyHandler,
 		webhookHandler: webhookHandler,
-		logger:         NewLogger(taskName),
+		logger:         NewLogger(fmt.Sprintf("%s %s", region, taskType)),
+		stopCh:         make(chan struct{}),
 	}, nil
 }
 
@@ -66,6 +90,9 @@ func (g *BaseTaskGroup) RemoveTask(task *BaseTask) error {
 }
 
 func (g *BaseTaskGroup) StartAllTasks() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
 	for _, task := range g.baseTasks {
 		if task.GetStatus() != StatusReady {
 			return &TaskNotReadyError{}
@@ -73,26 +100,163 @@ func (g *BaseTaskGroup) StartAllTasks() error {
 	}
 
 	for _, task := range g.baseTasks {
-		tasksWg.Add(1)
+		g.startTask(task)
+	}
+
+	return nil
+}
+
+func (g *BaseTaskGroup) StopAllTasks() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if !g.stopped {
+		close(g.stopCh)
+	}
+	g.stopped = true
+
+	for _, task := range g.baseTasks {
+		task.stop()
+	}
+}
+
+// Runs the task in the background, tasks of a burst start are spread over one timeout
+func (g *BaseTaskGroup) startTask(task *BaseTask) {
+	tasksWg.Add(1)
+
+	go func() {
+		defer tasksWg.Done()
+
+		configMu.RLock()
+		offset := g.burstOffset()
+		configMu.RUnlock()
+
+		if offset > 0 && !task.sleep(offset) {
+			return
+		}
 
-		go func() {
-			if config.NormalTask.BurstStart {
-				offsetMilliseconds := rand.Intn(config.NormalTask.Timeout)
-				time.Sleep(time.Millisecond * time.Duration(offsetMilliseconds))
-			}
-			task.start()
+		// Stopped by a scale down before it started
+		if task.GetStatus() == StatusStopped {
+			return
+		}
+
+		err := task.start()
+		if err != nil {
+			g.logger.Red(fmt.Sprintf("Error starting %s: %v", task.taskName, err))
+			return
+		}
+
+		task.WaitForTermination()
+	}()
+}
 
-			task.WaitForTermination()
+// Runs the handling of a response in the background. Counted in tasksWg, so a shutdown waits for it
+func runInFlight(fn func()) {
+	tasksWg.Add(1)
 
-			tasksWg.Done()
-		}()
+	go func() {
+		defer tasksWg.Done()
+
+		fn()
+	}()
+}
+
+// Take configMu RLock before calling burstOffset!
+func (g *BaseTaskGroup) burstOffset() time.Duration {
+	burstStart, timeout := config.NormalTask.BurstStart, config.NormalTask.Timeout
+	if g.taskType == TASK_TYPE_LOAD {
+		burstStart, timeout = config.LoadTask.BurstStart, config.LoadTask.Timeout
+	}
+
+	if !burstStart || timeout <= 0 {
+		return 0
+	}
+
+	return time.Millisecond * time.Duration(rand.Intn(timeout))
+}
+
+// Starts or stops tasks until numTasks are running. The newest tasks are stopped first
+func (g *BaseTaskGroup) reconcileTasks(numTasks int, newTask func(taskName string) (*BaseTask, error)) error {
+	g.mu.Lock()
+	if g.stopped {
+		g.mu.Unlock()
+		return nil
+	}
+	tasks := slices.Clone(g.baseTasks)
+	g.mu.Unlock()
+
+	for len(tasks) < numTasks {
+		g.mu.Lock()
+		taskName := g.nextTaskName()
+		g.mu.Unlock()
+
+		task, err := newTask(taskName)
+		if err != nil {
+			return fmt.Errorf("error creating task %s: %v", taskName, err)
+		}
+
+		if task.GetStatus() != StatusReady {
+			return fmt.Errorf("error adding task %s to task group: %v", taskName, &TaskNotReadyError{})
+		}
+
+		// Checked again for every task, so no task is counted in tasksWg after a shutdown started waiting
+		g.mu.Lock()
+		if g.stopped {
+			g.mu.Unlock()
+			return nil
+		}
+		g.baseTasks = append(g.baseTasks, task)
+		g.startTask(task)
+		g.mu.Unlock()
+
+		tasks = append(tasks, task)
+
+		g.logger.Green(fmt.Sprintf("Started %s", taskName))
+	}
+
+	for len(tasks) > numTasks {
+		task := tasks[len(tasks)-1]
+		tasks = tasks[:len(tasks)-1]
+
+		// The task finishes its current iteration in the background
+		task.stop()
+
+		err := g.RemoveTask(task)
+		if err != nil {
+			return fmt.Errorf("error removing task %s from task group: %v", task.taskName, err)
+		}
+
+		g.logger.Yellow(fmt.Sprintf("Stopped %s", task.taskName))
 	}
 
 	return nil
 }
 
-func (g *BaseTaskGroup) StopAllTasks() {
+// Take lock before calling nextTaskName! [g.mu] Reuses the numbers of removed tasks
+func (g *BaseTaskGroup) nextTaskName() string {
+	for i := 0; ; i++ {
+		taskName := fmt.Sprintf("%s %s: %02d", g.region, g.taskType, i)
+
+		inUse := slices.ContainsFunc(g.baseTasks, func(task *BaseTask) bool {
+			return task.taskName == taskName
+		})
+		if !inUse {
+			return taskName
+		}
+	}
+}
+
+func (g *BaseTaskGroup) GetTaskStatuses() []TaskStatusInfo {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	statuses := []TaskStatusInfo{}
 	for _, task := range g.baseTasks {
-		task.stop()
+		statuses = append(statuses, TaskStatusInfo{
+			Name:   task.taskName,
+			Status: task.GetStatus(),
+		})
 	}
+
+	return statuses
 }
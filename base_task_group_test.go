@@ -0,0 +1,152 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func newTestTaskGroup(t *testing.T) *BaseTaskGroup {
+	t.Helper()
+
+	oldConfig := config
+	t.Cleanup(func() {
+		config = oldConfig
+	})
+
+	testConfig := defaultConfig
+	testConfig.NormalTask.BurstStart = false
+	config = &testConfig
+
+	backend, err := NewStoreBackend(config.Backend, defaultRegionConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	group, err := NewBaseTaskGroup(TASK_TYPE_NORMAL, "EU", &RegionProductStates{}, backend, NewProxyHandler(nil), NewWebhookHandler())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		group.StopAllTasks()
+		tasksWg.Wait()
+	})
+
+	return group
+}
+
+func taskNames(group *BaseTaskGroup) []string {
+	names := []string{}
+	for _, status := range group.GetTaskStatuses() {
+		names = append(names, status.Name)
+	}
+	return names
+}
+
+func TestReconcileTasks(t *testing.T) {
+	group := newTestTaskGroup(t)
+	newTask := func(taskName string) (*BaseTask, error) {
+		return NewBaseTask(taskName, func() { time.Sleep(time.Millisecond) }, func() {}, group.proxyHandler, group.webhookHandler)
+	}
+
+	err := group.reconcileTasks(3, newTask)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := taskNames(group); !slices.Equal(names, []string{"EU NORMAL: 00", "EU NORMAL: 01", "EU NORMAL: 02"}) {
+		t.Fatalf("got tasks %v after scaling up", names)
+	}
+
+	group.mu.Lock()
+	tasks := slices.Clone(group.baseTasks)
+	group.mu.Unlock()
+
+	// The newest tasks are stopped and removed first
+	err = group.reconcileTasks(1, newTask)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := taskNames(group); !slices.Equal(names, []string{"EU NORMAL: 00"}) {
+		t.Fatalf("got tasks %v after scaling down", names)
+	}
+	for _, task := range tasks[1:] {
+		if status := task.GetStatus(); status != StatusStopped {
+			t.Errorf("removed task %s is %s, want stopped", task.taskName, status)
+		}
+	}
+	if status := tasks[0].GetStatus(); status == StatusStopped {
+		t.Error("kept task was stopped")
+	}
+
+	// Numbers of removed tasks are reused
+	err = group.reconcileTasks(2, newTask)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := taskNames(group); !slices.Equal(names, []string{"EU NORMAL: 00", "EU NORMAL: 01"}) {
+		t.Fatalf("got tasks %v after scaling up again", names)
+	}
+}
+
+func TestReconcileTasksAfterStop(t *testing.T) {
+	group := newTestTaskGroup(t)
+	newTask := func(taskName string) (*BaseTask, error) {
+		return NewBaseTask(taskName, func() { time.Sleep(time.Millisecond) }, func() {}, group.proxyHandler, group.webhookHandler)
+	}
+
+	group.StopAllTasks()
+
+	err := group.reconcileTasks(2, newTask)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := taskNames(group); len(names) != 0 {
+		t.Errorf("got tasks %v started after the group was stopped", names)
+	}
+}
+
+func TestReconcileTasksStoppedWhileScaling(t *testing.T) {
+	group := newTestTaskGroup(t)
+	created := 0
+	newTask := func(taskName string) (*BaseTask, error) {
+		created++
+		// The shutdown starts while the first task is created
+		if created == 1 {
+			group.StopAllTasks()
+		}
+		return NewBaseTask(taskName, func() { time.Sleep(time.Millisecond) }, func() {}, group.proxyHandler, group.webhookHandler)
+	}
+
+	err := group.reconcileTasks(3, newTask)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := taskNames(group); len(names) != 0 {
+		t.Errorf("got tasks %v started after the group was stopped", names)
+	}
+}
+
+func TestNormalTaskGroupAppliesNumTasks(t *testing.T) {
+	region := setupTestRegion(t)
+	t.Cleanup(func() {
+		region.normalTaskGroup.StopAllTasks()
+		tasksWg.Wait()
+	})
+
+	oldConfig := *config
+	newConfig := *config
+	newConfig.NormalTask.NumTasks = 2
+
+	region.normalTaskGroup.onConfigChange(diffConfigs(&oldConfig, &newConfig))
+	if got := len(region.normalTaskGroup.GetTaskStatuses()); got != 2 {
+		t.Fatalf("got %d normal tasks, want 2", got)
+	}
+
+	// Load task counts are left to the load task group
+	loadChangedConfig := newConfig
+	loadChangedConfig.LoadTask.NumTasks = 4
+	region.normalTaskGroup.onConfigChange(diffConfigs(&newConfig, &loadChangedConfig))
+	if got := len(region.normalTaskGroup.GetTaskStatuses()); got != 2 {
+		t.Errorf("got %d normal tasks after a load numTasks change, want 2", got)
+	}
+}
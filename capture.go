@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	CAPTURE_KIND_NEW_ARRIVALS = "newArrivals"
+	CAPTURE_KIND_NORMAL       = "normal"
+	CAPTURE_KIND_LOAD_CHECK   = "loadCheck"
+
+	captureStatesFilename = "product_states.json"
+)
+
+var captureRecorder *CaptureRecorder = nil
+
+// One file per fetched response, named <seq>_<region>_<kind>.json. Response is the raw body as returned by the shop
+type CaptureEntry struct {
+	Seq      int             `json:"seq"`
+	Time     time.Time       `json:"time"`
+	Region   string          `json:"region"`
+	Kind     string          `json:"kind"`
+	Page     int             `json:"page,omitempty"` // Further new arrivals pages belong to the entry with page 0 before them
+	Skus     []string        `json:"skus,omitempty"`
+	Response json.RawMessage `json:"response"`
+}
+
+type CaptureRecorder struct {
+	mu     sync.Mutex
+	folder string
+	seq    int
+	logger *Logger
+}
+
+// Creates a timestamped capture folder and snapshots the current product states into it,
+// so a replay starts from the same states the monitor had
+func NewCaptureRecorder() (*CaptureRecorder, error) {
+	folder := filepath.Join(pathCaptureFolder, strconv.FormatInt(time.Now().UnixMilli(), 10))
+
+	err := os.MkdirAll(folder, os.ModePerm)
+	if err != nil {
+		return nil, fmt.Errorf("error creating capture folder: %v", err)
+	}
+
+	statesNormalMu.Lock()
+	statesLoadMu.Lock()
+	statesBytes, err := json.MarshalIndent(productStates, "", "\t")
+	statesLoadMu.Unlock()
+	statesNormalMu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling product states snapshot: %v", err)
+	}
+
+	err = os.WriteFile(filepath.Join(folder, captureStatesFilename), statesBytes, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error writing product states snapshot: %v", err)
+	}
+
+	recorder := &CaptureRecorder{
+		folder: folder,
+		logger: NewLogger("CAPTURE"),
+	}
+
+	recorder.logger.Yellow(fmt.Sprintf("Capturing responses to %s", folder))
+
+	return recorder, nil
+}
+
+func (r *CaptureRecorder) Record(region string, kind string, page int, skus []string, body []byte) {
+	if !json.Valid(body) {
+		r.logger.Red(fmt.Sprintf("%s response is not json, not captured", kind))
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.seq += 1
+
+	entry := CaptureEntry{
+		Seq:      r.seq,
+		Time:     time.Now(),
+		Region:   region,
+		Kind:     kind,
+		Page:     page,
+		Skus:     skus,
+		Response: body,
+	}
+
+	bytes, err := json.Marshal(entry)
+	if err != nil {
+		r.logger.Red(fmt.Sprintf("Error marshalling capture entry: %v", err))
+		return
+	}
+
+	filename := fmt.Sprintf("%06d_%s_%s.json", entry.Seq, region, kind)
+
+	err = os.WriteFile(filepath.Join(r.folder, filename), bytes, 0644)
+	if err != nil {
+		r.logger.Red(fmt.Sprintf("Error writing %s: %v", filename, err))
+	}
+}
+
+func captureResponse(region string, kind string, page int, skus []string, body []byte) {
+	if captureRecorder == nil {
+		return
+	}
+
+	captureRecorder.Record(region, kind, page, skus, body)
+}
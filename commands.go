@@ -0,0 +1,19 @@
+package main
+
+import "fmt"
+
+// Subcommands run instead of the monitor, e.g. "sns-app-monitor.exe mock 8090"
+func runCommand(name string, args []string) error {
+	fileLoggingEnabled = false
+
+	switch name {
+	case "mock":
+		return runMockServer(args)
+	case "replay":
+		return runReplay(args)
+	case "history":
+		return runHistory(args)
+	default:
+		return fmt.Errorf("unknown command")
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Bump together with a new entry in configMigrations
+const CONFIG_VERSION = 2
+
+// Upgrades the raw config of version from to from+1. Configs without configVersion are version 1
+type configMigration struct {
+	from        int
+	description string
+	migrate     func(raw map[string]any) error
+}
+
+var configMigrations []configMigration = []configMigration{
+	{
+		from:        1,
+		description: "add cooldown section with defaults",
+		migrate: func(raw map[string]any) error {
+			// Configs written before the cooldown existed would otherwise run with it disabled
+			if _, ok := raw["cooldown"]; !ok {
+				raw["cooldown"] = defaultConfig.Cooldown
+			}
+			return nil
+		},
+	},
+}
+
+// Applies the migrations the raw config needs. Returns the migrated config json and the version it had before
+func migrateConfig(bytes []byte) ([]byte, int, error) {
+	var raw map[string]any
+
+	err := json.Unmarshal(bytes, &raw)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error unmarshalling config: %v", err)
+	}
+	if raw == nil {
+		return nil, 0, fmt.Errorf("config is empty")
+	}
+
+	version := 1
+	if rawVersion, ok := raw["configVersion"]; ok {
+		floatVersion, ok := rawVersion.(float64)
+		if !ok || floatVersion != float64(int(floatVersion)) || floatVersion < 1 {
+			return nil, 0, fmt.Errorf("invalid configVersion: %v", rawVersion)
+		}
+		version = int(floatVersion)
+	}
+
+	if version > CONFIG_VERSION {
+		return nil, 0, fmt.Errorf("configVersion %d is newer than this monitor supports (%d)", version, CONFIG_VERSION)
+	}
+
+	fromVersion := version
+	for _, migration := range configMigrations {
+		if migration.from != version {
+			continue
+		}
+
+		err := migration.migrate(raw)
+		if err != nil {
+			return nil, 0, fmt.Errorf("error migrating config from version %d (%s): %v", migration.from, migration.description, err)
+		}
+
+		version += 1
+		raw["configVersion"] = version
+	}
+
+	if version == fromVersion {
+		return bytes, fromVersion, nil
+	}
+
+	migratedBytes, err := json.Marshal(raw)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error marshalling migrated config: %v", err)
+	}
+
+	return migratedBytes, fromVersion, nil
+}
+
+// Collects every problem of the config instead of stopping at the first one
+func (c *Config) Validate() error {
+	v := &configValidator{}
+
+	v.check(c.ConfigVersion == CONFIG_VERSION, "configVersion must be %d", CONFIG_VERSION)
+
+	v.validateTask("normal", c.NormalTask.Timeout, c.NormalTask.NumTasks, c.NormalTask.WebhookUrls)
+	v.validateTask("load", c.LoadTask.Timeout, c.LoadTask.NumTasks, c.LoadTask.WebhookUrls)
+
+	v.check(c.NormalTask.PriceDrop.MinAmount >= 0, "normal.priceDrop.minAmount must not be negative")
+	v.check(c.NormalTask.PriceDrop.MinPercent >= 0 && c.NormalTask.PriceDrop.MinPercent <= 100, "normal.priceDrop.minPercent must be between 0 and 100")
+
+	v.check(c.LoadTask.NewArrivals.Rows >= 0, "load.newArrivals.rows must not be negative")
+	v.check(c.LoadTask.NewArrivals.MaxPages >= 0, "load.newArrivals.maxPages must not be negative")
+
+	v.check(c.Backend == "" || c.Backend == "sns", "backend \"%s\" is unknown", c.Backend)
+	v.check(c.MaxTasksPerProxy >= 1, "maxTasksPerProxy must be at least 1")
+	v.check(c.WebhookErrorTimeout >= 0, "webhookErrorTimeoutInMilliseconds must not be negative")
+
+	if c.ProxyfileName != "" {
+		proxyfilePath := filepath.Join(pathProxyFolder, c.ProxyfileName)
+		_, err := os.Stat(proxyfilePath)
+		v.check(err == nil, "proxyfile \"%s\" does not exist", proxyfilePath)
+	}
+
+	v.check(c.WebsocketPort >= 0 && c.WebsocketPort <= 65535, "websocketPort must be between 0 and 65535")
+	if c.ControlApi.Enabled {
+		v.check(c.ControlApi.Port >= 1 && c.ControlApi.Port <= 65535, "controlApi.port must be between 1 and 65535")
+	}
+
+	regionNames := make(map[string]bool)
+	for i, regionConfig := range c.Regions {
+		name := strings.ToUpper(strings.TrimSpace(regionConfig.Name))
+
+		v.check(name != "", "regions[%d].name must not be empty", i)
+		v.check(!regionNames[name], "region %s is configured more than once", name)
+		v.check(regionConfig.ProductUrlPrefix == "" || isHttpUrl(regionConfig.ProductUrlPrefix), "regions[%d].productUrlPrefix is not a valid url", i)
+		v.validateUrls(fmt.Sprintf("regions[%d].normalWebhookUrls", i), regionConfig.NormalWebhookUrls)
+		v.validateUrls(fmt.Sprintf("regions[%d].loadWebhookUrls", i), regionConfig.LoadWebhookUrls)
+
+		regionNames[name] = true
+	}
+
+	for eventType, sinkNames := range c.Notifiers.Events {
+		_, known := eventLabels[eventType]
+		v.check(known, "notifiers.events: event type \"%s\" is unknown", eventType)
+
+		for _, sinkName := range sinkNames {
+			v.check(isSinkName(sinkName), "notifiers.events.%s: sink \"%s\" is unknown", eventType, sinkName)
+		}
+	}
+	v.validateUrls("notifiers.slack.webhookUrls", c.Notifiers.Slack.WebhookUrls)
+	v.validateUrls("notifiers.json.urls", c.Notifiers.Json.Urls)
+
+	for key, filter := range c.Notifiers.Filters {
+		v.check(isSinkName(key) || isHttpUrl(key), "notifiers.filters: \"%s\" is neither a sink name nor a webhook url", key)
+		if filter != nil {
+			v.check(filter.MinStock >= 0 && filter.MaxPrice >= 0, "notifiers.filters.%s: minStock and maxPrice must not be negative", key)
+		}
+	}
+
+	v.check(c.Cooldown.WindowInSeconds >= 0, "cooldown.windowInSeconds must not be negative")
+	v.check(c.Cooldown.DigestDelayInMilliseconds >= 0, "cooldown.digestDelayInMilliseconds must not be negative")
+
+	v.check(c.Endpoints.NewArrivalsBaseUrl == "" || isHttpUrl(c.Endpoints.NewArrivalsBaseUrl), "endpoints.newArrivalsBaseUrl is not a valid url")
+	v.check(c.Endpoints.ProductsBaseUrl == "" || isHttpUrl(c.Endpoints.ProductsBaseUrl), "endpoints.productsBaseUrl is not a valid url")
+
+	if len(v.problems) > 0 {
+		return &ConfigValidationError{
+			problems: v.problems,
+		}
+	}
+	return nil
+}
+
+type configValidator struct {
+	problems []string
+}
+
+func (v *configValidator) check(ok bool, format string, args ...any) {
+	if !ok {
+		v.problems = append(v.problems, fmt.Sprintf(format, args...))
+	}
+}
+
+func (v *configValidator) validateTask(name string, timeout int, numTasks int, webhookUrls []string) {
+	// The burst start offset is drawn from [0, timeout)
+	v.check(timeout > 0, "%s.timeoutInMilliseconds must be greater than 0", name)
+	v.check(numTasks >= 0, "%s.numTasks must not be negative", name)
+	v.validateUrls(name+".webhookUrls", webhookUrls)
+}
+
+func (v *configValidator) validateUrls(field string, urls []string) {
+	for _, rawUrl := range urls {
+		v.check(isHttpUrl(rawUrl), "%s: \"%s\" is not a valid url", field, rawUrl)
+	}
+}
+
+func isHttpUrl(rawUrl string) bool {
+	parsedUrl, err := url.Parse(rawUrl)
+	if err != nil {
+		return false
+	}
+	return (parsedUrl.Scheme == "http" || parsedUrl.Scheme == "https") && parsedUrl.Host != ""
+}
+
+func isSinkName(name string) bool {
+	switch name {
+	case SINK_DISCORD, SINK_TELEGRAM, SINK_SLACK, SINK_JSON, SINK_FILE:
+		return true
+	default:
+		return false
+	}
+}
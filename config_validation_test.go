@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMigrateConfigFromVersion1(t *testing.T) {
+	migratedBytes, fromVersion, err := migrateConfig([]byte(`{"instanceName": "old", "maxTasksPerProxy": 3}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fromVersion != 1 {
+		t.Errorf("got from version %d, want 1 for a config without configVersion", fromVersion)
+	}
+
+	var migrated Config
+
+	err = json.Unmarshal(migratedBytes, &migrated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrated.ConfigVersion != CONFIG_VERSION {
+		t.Errorf("got configVersion %d, want %d", migrated.ConfigVersion, CONFIG_VERSION)
+	}
+	if migrated.Cooldown != defaultConfig.Cooldown {
+		t.Errorf("got cooldown %+v, want the defaults %+v", migrated.Cooldown, defaultConfig.Cooldown)
+	}
+	if migrated.InstanceName != "old" || migrated.MaxTasksPerProxy != 3 {
+		t.Errorf("migration changed other fields: %+v", migrated)
+	}
+}
+
+func TestMigrateConfigKeepsCooldown(t *testing.T) {
+	migratedBytes, _, err := migrateConfig([]byte(`{"configVersion": 1, "cooldown": {"windowInSeconds": 5}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var migrated Config
+
+	err = json.Unmarshal(migratedBytes, &migrated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrated.Cooldown.WindowInSeconds != 5 {
+		t.Errorf("got cooldown window %d, want the configured 5", migrated.Cooldown.WindowInSeconds)
+	}
+}
+
+func TestMigrateConfigCurrentVersion(t *testing.T) {
+	bytes := []byte(`{"configVersion": 2, "instanceName": "current"}`)
+
+	migratedBytes, fromVersion, err := migrateConfig(bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fromVersion != CONFIG_VERSION || string(migratedBytes) != string(bytes) {
+		t.Errorf("current config was changed: version %d, %s", fromVersion, migratedBytes)
+	}
+}
+
+func TestMigrateConfigRejects(t *testing.T) {
+	tests := map[string]string{
+		"newer version":     `{"configVersion": 3}`,
+		"fractional":        `{"configVersion": 1.5}`,
+		"zero":              `{"configVersion": 0}`,
+		"string version":    `{"configVersion": "2"}`,
+		"empty":             `null`,
+		"malformed":         `{"configVersion": `,
+		"not an object":     `[]`,
+		"unquoted property": `{configVersion: 2}`,
+	}
+
+	for name, bytes := range tests {
+		_, _, err := migrateConfig([]byte(bytes))
+		if err == nil {
+			t.Errorf("%s config accepted", name)
+		}
+	}
+}
+
+func TestValidateDefaultConfig(t *testing.T) {
+	err := defaultConfig.Validate()
+	if err != nil {
+		t.Errorf("default config is invalid: %v", err)
+	}
+}
+
+func TestValidateCollectsProblems(t *testing.T) {
+	invalid := defaultConfig
+	invalid.NormalTask.Timeout = 0
+	invalid.MaxTasksPerProxy = 0
+	invalid.ProxyfileName = "missing-proxyfile.txt"
+	invalid.LoadTask.WebhookUrls = []string{"discord.com/api/webhooks/1"}
+	invalid.Regions = []RegionConfig{{Name: "EU"}, {Name: " eu "}}
+	invalid.Notifiers.Events = map[string][]string{EVENT_RESTOCK: {"pager"}}
+
+	err := invalid.Validate()
+
+	var validationErr *ConfigValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("got %v, want a ConfigValidationError", err)
+	}
+
+	wantProblems := []string{
+		"normal.timeoutInMilliseconds",
+		"maxTasksPerProxy",
+		"missing-proxyfile.txt",
+		"load.webhookUrls",
+		"region EU is configured more than once",
+		"sink \"pager\" is unknown",
+	}
+	if len(validationErr.problems) != len(wantProblems) {
+		t.Errorf("got %d problems, want %d: %v", len(validationErr.problems), len(wantProblems), validationErr.problems)
+	}
+	for _, want := range wantProblems {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+}
+
+func TestReloadConfigRejectsInvalidChange(t *testing.T) {
+	workingDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldConfig := config
+	t.Cleanup(func() {
+		config = oldConfig
+		os.Chdir(workingDir)
+	})
+
+	// Missing config.json is created with the defaults
+	err = readConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	validConfig := config
+
+	err = os.WriteFile(pathConfig, []byte(`{"configVersion": 2, "normal": {"timeoutInMilliseconds": 0}}`), os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = reloadConfig()
+
+	var validationErr *ConfigValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("got %v, want a ConfigValidationError", err)
+	}
+	if config != validConfig {
+		t.Error("invalid config was swapped in")
+	}
+}
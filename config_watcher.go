@@ -0,0 +1,282 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Editors write files in several steps, reloads wait until they are done
+const CONFIG_RELOAD_DEBOUNCE = 250 * time.Millisecond
+
+// Config fields that are only read on start. Regions are created on start with their backend settings
+var configRestartFields []string = []string{"backend", "regions", "websocketPort", "controlApi", "captureResponses", "enableFileLogging"}
+
+// Describes what changed between two valid configs. Subscribers only look at the fields they care about
+type ConfigDiff struct {
+	Old *Config
+	New *Config
+
+	Proxyfile      bool
+	ProxyLimits    bool // maxTasksPerProxy or autoRemoveBadProxy
+	NormalNumTasks bool
+	LoadNumTasks   bool
+
+	// Json names of every changed field, nested one level deep (e.g. "normal.numTasks")
+	Fields []string
+}
+
+type ConfigSubscriber func(diff *ConfigDiff)
+
+var configSubscribersMu sync.Mutex = sync.Mutex{}
+var configSubscribers []ConfigSubscriber = []ConfigSubscriber{}
+
+// Subscribers are called without configMu held, after the new config was swapped in
+func subscribeConfig(subscriber ConfigSubscriber) {
+	configSubscribersMu.Lock()
+	defer configSubscribersMu.Unlock()
+
+	configSubscribers = append(configSubscribers, subscriber)
+}
+
+func publishConfigDiff(diff *ConfigDiff) {
+	configSubscribersMu.Lock()
+	subscribers := append([]ConfigSubscriber{}, configSubscribers...)
+	configSubscribersMu.Unlock()
+
+	for _, subscriber := range subscribers {
+		subscriber(diff)
+	}
+}
+
+func diffConfigs(oldConfig *Config, newConfig *Config) *ConfigDiff {
+	return &ConfigDiff{
+		Old:            oldConfig,
+		New:            newConfig,
+		Proxyfile:      oldConfig.ProxyfileName != newConfig.ProxyfileName,
+		ProxyLimits:    oldConfig.MaxTasksPerProxy != newConfig.MaxTasksPerProxy || oldConfig.RemoveBadProxy != newConfig.RemoveBadProxy,
+		NormalNumTasks: oldConfig.NormalTask.NumTasks != newConfig.NormalTask.NumTasks,
+		LoadNumTasks:   oldConfig.LoadTask.NumTasks != newConfig.LoadTask.NumTasks,
+		Fields:         changedFields("", reflect.ValueOf(*oldConfig), reflect.ValueOf(*newConfig), 1),
+	}
+}
+
+func changedFields(prefix string, oldValue reflect.Value, newValue reflect.Value, depth int) []string {
+	fields := []string{}
+
+	for i := 0; i < oldValue.NumField(); i++ {
+		oldField := oldValue.Field(i)
+		newField := newValue.Field(i)
+		if reflect.DeepEqual(oldField.Interface(), newField.Interface()) {
+			continue
+		}
+
+		name := prefix + strings.Split(oldValue.Type().Field(i).Tag.Get("json"), ",")[0]
+
+		if depth > 0 && oldField.Kind() == reflect.Struct {
+			fields = append(fields, changedFields(name+".", oldField, newField, depth-1)...)
+		} else {
+			fields = append(fields, name)
+		}
+	}
+
+	return fields
+}
+
+func (d *ConfigDiff) IsEmpty() bool {
+	return len(d.Fields) == 0
+}
+
+// Returns the changed fields that only take effect after a restart
+func (d *ConfigDiff) RestartFields() []string {
+	restartFields := []string{}
+
+	for _, field := range d.Fields {
+		for _, restartField := range configRestartFields {
+			if field == restartField || strings.HasPrefix(field, restartField+".") {
+				restartFields = append(restartFields, field)
+				break
+			}
+		}
+	}
+
+	return restartFields
+}
+
+// Swaps in config.json if it is valid and differs from the current config, then notifies the subscribers
+func reloadConfig() (*ConfigDiff, error) {
+	newConfig, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	configMu.Lock()
+	diff := diffConfigs(config, newConfig)
+	if diff.IsEmpty() {
+		configMu.Unlock()
+		return diff, nil
+	}
+	config = newConfig
+	configMu.Unlock()
+
+	fileSystemLogger.Yellow(fmt.Sprintf("Config reloaded (%s)", strings.Join(diff.Fields, ", ")))
+
+	if restartFields := diff.RestartFields(); len(restartFields) > 0 {
+		fileSystemLogger.Yellow(fmt.Sprintf("Restart the monitor to apply %s", strings.Join(restartFields, ", ")))
+	}
+
+	publishConfigDiff(diff)
+
+	return diff, nil
+}
+
+// Reloads the config whenever config.json changes and the notification templates whenever a file in the
+// templates folder changes. Invalid config changes are rejected and logged once, the monitor keeps running
+// with the last valid config. The returned stop func waits for a running reload to finish
+func watchConfig() (func(), error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("error creating config watcher: %v", err)
+	}
+
+	// Editors often replace the file instead of writing it, which ends a watch on the file itself
+	err = watcher.Add(filepath.Dir(pathConfig))
+	if err != nil {
+		watcher.Close()
+		return nil, fmt.Errorf("error watching config: %v", err)
+	}
+
+	err = watchTemplatesFolder(watcher)
+	if err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	stopCh := make(chan struct{})
+	doneCh := make(chan struct{})
+
+	go func() {
+		defer close(doneCh)
+		defer watcher.Close()
+
+		lastErr := ""
+
+		// Picks up changes made before the watch was set up
+		reload := time.After(0)
+		reloadTemplates := time.After(0)
+
+		for {
+			select {
+			case <-stopCh:
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op == fsnotify.Chmod {
+					continue
+				}
+
+				if isTemplatesEvent(event) {
+					// New sink folders are not covered by the watch of the templates folder
+					if event.Op.Has(fsnotify.Create) && filepath.Dir(filepath.Clean(event.Name)) == filepath.Clean(pathTemplatesFolder) {
+						if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+							err = watcher.Add(event.Name)
+							if err != nil {
+								fileSystemLogger.Red(fmt.Sprintf("Error watching \"%s\": %v", event.Name, err))
+							}
+						}
+					}
+
+					reloadTemplates = time.After(CONFIG_RELOAD_DEBOUNCE)
+					continue
+				}
+
+				if filepath.Clean(event.Name) != filepath.Clean(pathConfig) {
+					continue
+				}
+
+				reload = time.After(CONFIG_RELOAD_DEBOUNCE)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+
+				fileSystemLogger.Red(fmt.Sprintf("Config watcher: %v", err))
+			case <-reloadTemplates:
+				reloadTemplates = nil
+
+				notificationTemplates.Reload()
+			case <-reload:
+				reload = nil
+
+				// A missing file would be replaced by the default config
+				if _, err := os.Stat(pathConfig); os.IsNotExist(err) {
+					fileSystemLogger.Red("Config was removed, keeping the current config")
+					continue
+				}
+
+				_, err := reloadConfig()
+				if err != nil {
+					if err.Error() != lastErr {
+						fileSystemLogger.Red(fmt.Sprintf("Config change rejected, keeping the current config: %v", err))
+						lastErr = err.Error()
+					}
+					continue
+				}
+
+				if lastErr != "" {
+					fileSystemLogger.Green("Config is valid again")
+					lastErr = ""
+				}
+			}
+		}
+	}()
+
+	stop := func() {
+		close(stopCh)
+		<-doneCh
+	}
+
+	return stop, nil
+}
+
+// Watches the templates folder and every sink folder in it. fsnotify does not watch recursively
+func watchTemplatesFolder(watcher *fsnotify.Watcher) error {
+	err := watcher.Add(pathTemplatesFolder)
+	if err != nil {
+		return fmt.Errorf("error watching templates folder: %v", err)
+	}
+
+	sinkEntries, err := os.ReadDir(pathTemplatesFolder)
+	if err != nil {
+		return fmt.Errorf("error reading templates folder: %v", err)
+	}
+
+	for _, sinkEntry := range sinkEntries {
+		if !sinkEntry.IsDir() {
+			continue
+		}
+
+		err = watcher.Add(filepath.Join(pathTemplatesFolder, sinkEntry.Name()))
+		if err != nil {
+			return fmt.Errorf("error watching templates of %s: %v", sinkEntry.Name(), err)
+		}
+	}
+
+	return nil
+}
+
+// True for changes of sink folders and template files
+func isTemplatesEvent(event fsnotify.Event) bool {
+	templatesFolder := filepath.Clean(pathTemplatesFolder)
+	dir := filepath.Dir(filepath.Clean(event.Name))
+
+	return dir == templatesFolder || filepath.Dir(dir) == templatesFolder
+}
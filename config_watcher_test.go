@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestConfigDiffRestartFields(t *testing.T) {
+	oldConfig := defaultConfig
+
+	newConfig := defaultConfig
+	newConfig.NormalTask.NumTasks = 3
+	newConfig.ControlApi.Port = 9000
+	newConfig.Regions = []RegionConfig{defaultRegionConfig, {Name: "UK"}}
+
+	diff := diffConfigs(&oldConfig, &newConfig)
+
+	if !diff.NormalNumTasks || diff.LoadNumTasks {
+		t.Errorf("got normal numTasks changed %t and load numTasks changed %t, want only normal", diff.NormalNumTasks, diff.LoadNumTasks)
+	}
+
+	for _, field := range []string{"normal.numTasks", "controlApi.port", "regions"} {
+		if !slices.Contains(diff.Fields, field) {
+			t.Errorf("changed fields %v miss %s", diff.Fields, field)
+		}
+	}
+
+	restartFields := diff.RestartFields()
+	if !slices.Equal(restartFields, []string{"regions", "controlApi.port"}) {
+		t.Errorf("got restart fields %v, want [regions controlApi.port]", restartFields)
+	}
+}
+
+func TestStopConfigWatcher(t *testing.T) {
+	workingDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldConfig := config
+	t.Cleanup(func() {
+		config = oldConfig
+		os.Chdir(workingDir)
+	})
+
+	err = checkTemplatesfolder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = readConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stop, err := watchConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !runWithTimeout(stop, time.Second) {
+		t.Fatal("config watcher did not stop")
+	}
+	watchedConfig := config
+
+	// Changes after the stop are not applied anymore
+	err = os.WriteFile(pathConfig, []byte(`{"configVersion": 2, "instanceName": "changed"}`), os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * CONFIG_RELOAD_DEBOUNCE)
+
+	configMu.RLock()
+	defer configMu.RUnlock()
+
+	if config != watchedConfig {
+		t.Error("config was reloaded after the watcher stopped")
+	}
+}
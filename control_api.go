@@ -0,0 +1,378 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+)
+
+var controlApiLogger *Logger = NewLogger("API")
+
+// Serves the same operations as the websocket control channel over plain HTTP on localhost,
+// plus read-only views of product states, tasks and the proxy pool. Take configMu RLock before calling startControlApi!
+func startControlApi(port int) *http.Server {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /skus", apiListHandler("SKU"))
+	mux.HandleFunc("POST /skus", apiAddHandler("SKU"))
+	mux.HandleFunc("DELETE /skus/{sku}", apiRemoveHandler("SKU"))
+	mux.HandleFunc("GET /keywords", apiListHandler("KWD_QUERY"))
+	mux.HandleFunc("POST /keywords", apiAddHandler("KWD_QUERY"))
+	mux.HandleFunc("DELETE /keywords", apiRemoveHandler("KWD_QUERY"))
+	mux.HandleFunc("POST /bulk/add", handleApiBulkAdd)
+	mux.HandleFunc("POST /bulk/remove", handleApiBulkRemove)
+	mux.HandleFunc("GET /export", handleApiExport)
+	mux.HandleFunc("POST /import", handleApiImport)
+	mux.HandleFunc("GET /states", handleApiStates)
+	mux.HandleFunc("GET /history", handleApiHistory)
+	mux.HandleFunc("GET /tasks", handleApiTasks)
+	mux.HandleFunc("GET /proxies", handleApiProxies)
+
+	if getWebsocketSecret() == "" {
+		controlApiLogger.Yellow("No websocketSecret set, control API changes are accepted without authentication")
+	}
+
+	server := &http.Server{
+		Addr:    fmt.Sprintf("localhost:%d", port),
+		Handler: guardControlApi(mux),
+	}
+
+	go func() {
+		controlApiLogger.White(fmt.Sprintf("Control API listening on %s", server.Addr))
+
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			controlApiLogger.Red(fmt.Sprintf("Control API stopped: %v", err))
+		}
+	}()
+
+	return server
+}
+
+// Changes need a localhost Host header, so a website can not reach the API by rebinding its domain, and a JSON
+// body, which a browser does not send cross-origin without a preflight. With a websocketSecret set, changes
+// also need it as bearer token
+func guardControlApi(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !isLocalhost(r.Host) {
+			writeApiError(w, http.StatusForbidden, fmt.Sprintf("host \"%s\" not allowed", r.Host))
+			return
+		}
+
+		if r.Method == http.MethodPost || r.ContentLength > 0 {
+			mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if err != nil || mediaType != "application/json" {
+				writeApiError(w, http.StatusUnsupportedMediaType, "content type must be application/json")
+				return
+			}
+		}
+
+		configMu.RLock()
+		secret := getWebsocketSecret()
+		configMu.RUnlock()
+
+		if secret != "" {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+				writeApiError(w, http.StatusUnauthorized, "unauthorized")
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isLocalhost(host string) bool {
+	hostname, _, err := net.SplitHostPort(host)
+	if err != nil {
+		hostname = strings.Trim(host, "[]")
+	}
+
+	switch strings.ToLower(hostname) {
+	case "localhost", "127.0.0.1", "::1":
+		return true
+	default:
+		return false
+	}
+}
+
+func apiAddHandler(inputType string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var queryReq ApiQueryRequest
+
+		err := json.NewDecoder(r.Body).Decode(&queryReq)
+		if err != nil {
+			writeApiError(w, http.StatusBadRequest, fmt.Sprintf("error decoding body: %v", err))
+			return
+		}
+
+		addMessage := AddMessage{
+			TypeName:  "ADD",
+			InputType: inputType,
+			AddQuery:  normalizeQueryInput(queryReq.Query),
+			Region:    queryReq.Region,
+			Metadata:  queryReq.Metadata,
+		}
+
+		err = handleAdd(&addMessage)
+		if err != nil {
+			controlApiLogger.Red(fmt.Sprintf("error adding query: %v", err))
+
+			writeApiError(w, apiErrorStatus(err), err.Error())
+			return
+		}
+
+		controlApiLogger.Cyan(fmt.Sprintf("Added %s %s", addMessage.InputType, addMessage.AddQuery))
+
+		writeApiJson(w, http.StatusCreated, ApiResponse{
+			Success: true,
+			Message: fmt.Sprintf("%s \"%s\" added", addMessage.InputType, addMessage.AddQuery),
+		})
+	}
+}
+
+func apiRemoveHandler(inputType string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query().Get("query")
+		if inputType == "SKU" {
+			query = r.PathValue("sku")
+		}
+
+		removeMessage := RemoveMessage{
+			TypeName:    "REMOVE",
+			InputType:   inputType,
+			RemoveQuery: normalizeQueryInput(query),
+			Region:      r.URL.Query().Get("region"),
+		}
+
+		err := handleRemove(&removeMessage)
+		if err != nil {
+			controlApiLogger.Red(fmt.Sprintf("error removing query: %v", err))
+
+			writeApiError(w, apiErrorStatus(err), err.Error())
+			return
+		}
+
+		controlApiLogger.Cyan(fmt.Sprintf("Removed %s %s", removeMessage.InputType, removeMessage.RemoveQuery))
+
+		writeApiJson(w, http.StatusOK, ApiResponse{
+			Success: true,
+			Message: fmt.Sprintf("%s \"%s\" removed", removeMessage.InputType, removeMessage.RemoveQuery),
+		})
+	}
+}
+
+func apiListHandler(inputType string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		listMessage := ListMessage{
+			TypeName:  "LIST",
+			InputType: inputType,
+			Region:    r.URL.Query().Get("region"),
+		}
+
+		entries, err := handleList(&listMessage)
+		if err != nil {
+			writeApiError(w, apiErrorStatus(err), err.Error())
+			return
+		}
+
+		writeApiJson(w, http.StatusOK, ApiResponse{
+			Success: true,
+			List:    watchEntryQueries(entries),
+			Entries: entries,
+		})
+	}
+}
+
+func handleApiBulkAdd(w http.ResponseWriter, r *http.Request) {
+	var bulkAddMessage BulkAddMessage
+
+	err := json.NewDecoder(r.Body).Decode(&bulkAddMessage)
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, fmt.Sprintf("error decoding body: %v", err))
+		return
+	}
+
+	results, err := handleBulkAdd(&bulkAddMessage)
+	if err != nil {
+		writeApiError(w, apiErrorStatus(err), err.Error())
+		return
+	}
+
+	controlApiLogger.Cyan(fmt.Sprintf("Bulk added %d of %d queries", countBulkResults(results, BULK_STATUS_ADDED), len(results)))
+
+	writeApiJson(w, http.StatusOK, results)
+}
+
+func handleApiBulkRemove(w http.ResponseWriter, r *http.Request) {
+	var bulkRemoveMessage BulkRemoveMessage
+
+	err := json.NewDecoder(r.Body).Decode(&bulkRemoveMessage)
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, fmt.Sprintf("error decoding body: %v", err))
+		return
+	}
+
+	results, err := handleBulkRemove(&bulkRemoveMessage)
+	if err != nil {
+		writeApiError(w, apiErrorStatus(err), err.Error())
+		return
+	}
+
+	controlApiLogger.Cyan(fmt.Sprintf("Bulk removed %d of %d queries", countBulkResults(results, BULK_STATUS_REMOVED), len(results)))
+
+	writeApiJson(w, http.StatusOK, results)
+}
+
+func handleApiExport(w http.ResponseWriter, r *http.Request) {
+	exportMessage := ExportMessage{
+		TypeName: "EXPORT",
+		Region:   r.URL.Query().Get("region"),
+	}
+
+	watchlist, err := handleExport(&exportMessage)
+	if err != nil {
+		writeApiError(w, apiErrorStatus(err), err.Error())
+		return
+	}
+
+	writeApiJson(w, http.StatusOK, watchlist)
+}
+
+// Body is a watchlist as returned by GET /export. ?replace=true removes queries missing in it
+func handleApiImport(w http.ResponseWriter, r *http.Request) {
+	importMessage := ImportMessage{
+		TypeName: "IMPORT",
+		Replace:  r.URL.Query().Get("replace") == "true",
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&importMessage.Watchlist)
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, fmt.Sprintf("error decoding body: %v", err))
+		return
+	}
+
+	results, err := handleImport(&importMessage)
+	if err != nil {
+		writeApiError(w, apiErrorStatus(err), err.Error())
+		return
+	}
+
+	controlApiLogger.Cyan(fmt.Sprintf("Imported watchlist: %d added, %d removed", countBulkResults(results, BULK_STATUS_ADDED), countBulkResults(results, BULK_STATUS_REMOVED)))
+
+	writeApiJson(w, http.StatusOK, results)
+}
+
+func handleApiStates(w http.ResponseWriter, r *http.Request) {
+	regionName := strings.ToUpper(strings.TrimSpace(r.URL.Query().Get("region")))
+	if regionName != "" {
+		if _, err := getRegion(regionName); err != nil {
+			writeApiError(w, apiErrorStatus(err), err.Error())
+			return
+		}
+	}
+
+	statesNormalMu.Lock()
+	statesLoadMu.Lock()
+
+	var bytes []byte
+	var err error
+	if regionName != "" {
+		bytes, err = json.Marshal(productStates.Regions[regionName])
+	} else {
+		bytes, err = json.Marshal(productStates)
+	}
+
+	statesLoadMu.Unlock()
+	statesNormalMu.Unlock()
+
+	if err != nil {
+		writeApiError(w, http.StatusInternalServerError, fmt.Sprintf("error marshalling product states: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bytes)
+}
+
+func handleApiHistory(w http.ResponseWriter, r *http.Request) {
+	if historyStore == nil {
+		writeApiError(w, http.StatusServiceUnavailable, "history store not available")
+		return
+	}
+
+	query, err := parseHistoryQuery(r.URL.Query())
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	records, err := historyStore.Query(query)
+	if err != nil {
+		writeApiError(w, http.StatusInternalServerError, fmt.Sprintf("error reading history: %v", err))
+		return
+	}
+
+	writeApiJson(w, http.StatusOK, records)
+}
+
+func handleApiTasks(w http.ResponseWriter, r *http.Request) {
+	taskGroups := []ApiTaskGroupStatus{}
+
+	for _, region := range regions {
+		taskGroups = append(taskGroups, ApiTaskGroupStatus{
+			Region:    region.name,
+			GroupType: "NORMAL",
+			Tasks:     region.normalTaskGroup.GetTaskStatuses(),
+		})
+		taskGroups = append(taskGroups, ApiTaskGroupStatus{
+			Region:    region.name,
+			GroupType: "LOAD",
+			Tasks:     region.loadTaskGroup.GetTaskStatuses(),
+		})
+	}
+
+	writeApiJson(w, http.StatusOK, taskGroups)
+}
+
+func handleApiProxies(w http.ResponseWriter, r *http.Request) {
+	writeApiJson(w, http.StatusOK, proxyHandler.GetProxyPool())
+}
+
+func apiErrorStatus(err error) int {
+	switch err.(type) {
+	case *AlreadyMonitoredError:
+		return http.StatusConflict
+	case *QueryNotFoundError, *RegionNotFoundError:
+		return http.StatusNotFound
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+func writeApiError(w http.ResponseWriter, status int, message string) {
+	writeApiJson(w, status, ApiResponse{
+		Success: false,
+		Message: message,
+	})
+}
+
+func writeApiJson(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(value)
+	if err != nil {
+		controlApiLogger.Red(fmt.Sprintf("Error writing response: %v", err))
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGuardControlApi(t *testing.T) {
+	useTestWebsocketSecret(t, testWebsocketSecret)
+
+	handler := guardControlApi(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name        string
+		method      string
+		host        string
+		contentType string
+		token       string
+		want        int
+	}{
+		{"read", http.MethodGet, "evil.example:8085", "", "", http.StatusNoContent},
+		{"change", http.MethodPost, "localhost:8085", "application/json", testWebsocketSecret, http.StatusNoContent},
+		{"ipv6 localhost", http.MethodPost, "[::1]:8085", "application/json; charset=utf-8", testWebsocketSecret, http.StatusNoContent},
+		{"delete without body", http.MethodDelete, "127.0.0.1:8085", "", testWebsocketSecret, http.StatusNoContent},
+		{"rebound host", http.MethodPost, "evil.example:8085", "application/json", testWebsocketSecret, http.StatusForbidden},
+		{"form body", http.MethodPost, "localhost:8085", "text/plain", testWebsocketSecret, http.StatusUnsupportedMediaType},
+		{"missing token", http.MethodPost, "localhost:8085", "application/json", "", http.StatusUnauthorized},
+		{"wrong token", http.MethodDelete, "localhost:8085", "", "wrong-secret", http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, "/skus", nil)
+		if test.method == http.MethodPost {
+			req = httptest.NewRequest(test.method, "/skus", strings.NewReader(`{"query": "DV0833-104"}`))
+		}
+		req.Host = test.host
+		if test.contentType != "" {
+			req.Header.Set("Content-Type", test.contentType)
+		}
+		if test.token != "" {
+			req.Header.Set("Authorization", "Bearer "+test.token)
+		}
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+
+		if recorder.Code != test.want {
+			t.Errorf("%s: got status %d, want %d", test.name, recorder.Code, test.want)
+		}
+	}
+}
+
+func TestGuardControlApiWithoutSecret(t *testing.T) {
+	useTestWebsocketSecret(t, "")
+
+	handler := guardControlApi(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/skus", strings.NewReader(`{"query": "DV0833-104"}`))
+	req.Host = "localhost:8085"
+	req.Header.Set("Content-Type", "application/json")
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusNoContent {
+		t.Errorf("got status %d, want changes accepted without a secret", recorder.Code)
+	}
+}
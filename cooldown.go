@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Held digests are kept in the outbox under this sink name, so a crash before the digest delay passed does not lose them
+const SINK_DIGEST = "digest"
+
+// Suppresses repeated notifications and merges rapid size changes of a product into one digest event
+type NotificationCooldown struct {
+	mu       sync.Mutex
+	sent     map[string]time.Time
+	digests  map[string]*pendingDigest
+	dispatch func(event *Event)
+	logger   *Logger
+}
+
+// The event stays nil until a follow-up restock arrives within the digest delay
+type pendingDigest struct {
+	event *Event
+	timer *time.Timer
+}
+
+func NewNotificationCooldown(dispatch func(event *Event)) *NotificationCooldown {
+	return &NotificationCooldown{
+		mu:       sync.Mutex{},
+		sent:     make(map[string]time.Time),
+		digests:  make(map[string]*pendingDigest),
+		dispatch: dispatch,
+		logger:   NewLogger("COOLDOWN"),
+	}
+}
+
+func (c CooldownConfig) getWindow() time.Duration {
+	if c.WindowInSeconds <= 0 {
+		return 0
+	}
+	return time.Second * time.Duration(c.WindowInSeconds)
+}
+
+func (c CooldownConfig) getDigestDelay() time.Duration {
+	if c.DigestDelayInMilliseconds <= 0 {
+		return 0
+	}
+	return time.Millisecond * time.Duration(c.DigestDelayInMilliseconds)
+}
+
+// Drops the event if the same change of the product was notified within the window.
+// The first restock is sent immediately, further restocks within the digest delay are merged into one digest
+func (c *NotificationCooldown) Submit(event *Event, window time.Duration, digestDelay time.Duration) {
+	c.mu.Lock()
+
+	now := time.Now()
+
+	for key, sentTime := range c.sent {
+		if now.Sub(sentTime) >= window {
+			delete(c.sent, key)
+		}
+	}
+
+	if window > 0 {
+		key := cooldownKey(event)
+		if _, ok := c.sent[key]; ok {
+			c.mu.Unlock()
+
+			c.logger.Grey(fmt.Sprintf("%s: Suppressing repeated %s notification (%s)", event.Product.Sku, event.Kind, event.Region))
+			return
+		}
+		c.sent[key] = now
+	}
+
+	if event.Kind != EVENT_RESTOCK || digestDelay <= 0 {
+		c.mu.Unlock()
+
+		c.dispatch(event)
+		return
+	}
+
+	digestKey := strings.Join([]string{event.Region, event.Product.Sku, event.Kind}, "|")
+
+	if digest, ok := c.digests[digestKey]; ok {
+		if digest.event == nil {
+			event.Digest = 1
+			digest.event = event
+		} else {
+			mergeDigestEvent(digest.event, event)
+		}
+		putDigestRecord(digestKey, digest.event)
+		c.mu.Unlock()
+		return
+	}
+
+	c.digests[digestKey] = &pendingDigest{
+		event: nil,
+		timer: time.AfterFunc(digestDelay, func() {
+			c.flushDigest(digestKey)
+		}),
+	}
+
+	c.mu.Unlock()
+
+	c.dispatch(event)
+}
+
+// Take c.mu Lock before calling putDigestRecord! Replaces the held event in the outbox with its latest merge
+func putDigestRecord(digestKey string, event *Event) {
+	notificationOutbox.Put(&OutboxRecord{
+		Key:     digestOutboxKey(digestKey),
+		Sink:    SINK_DIGEST,
+		Event:   event,
+		Targets: event.WebhookUrls,
+	})
+}
+
+func digestOutboxKey(digestKey string) string {
+	return fmt.Sprintf("%s|%s", SINK_DIGEST, digestKey)
+}
+
+func (c *NotificationCooldown) flushDigest(digestKey string) {
+	c.mu.Lock()
+	digest, ok := c.digests[digestKey]
+	if ok {
+		delete(c.digests, digestKey)
+	}
+	c.mu.Unlock()
+
+	// No restock followed within the digest delay
+	if !ok || digest.event == nil {
+		return
+	}
+
+	if digest.event.Digest > 1 {
+		c.logger.Grey(fmt.Sprintf("%s: Merged %d %s notifications (%s)", digest.event.Product.Sku, digest.event.Digest, digest.event.Kind, digest.event.Region))
+	}
+
+	// The sink deliveries are in the outbox once dispatch returns
+	c.dispatch(digest.event)
+
+	notificationOutbox.Done(digestOutboxKey(digestKey))
+}
+
+// Sends all held back digests immediately
+func (c *NotificationCooldown) Flush() {
+	c.mu.Lock()
+	digestKeys := []string{}
+	for digestKey, digest := range c.digests {
+		if digest.timer.Stop() {
+			digestKeys = append(digestKeys, digestKey)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, digestKey := range digestKeys {
+		c.flushDigest(digestKey)
+	}
+}
+
+// Keeps the first previous state, takes the latest product data and collects all sizes that came back
+func mergeDigestEvent(digest *Event, event *Event) {
+	digest.Digest += 1
+	digest.Time = event.Time
+	digest.Product = event.Product
+
+	if event.WebhookUrls != nil {
+		digest.WebhookUrls = event.WebhookUrls
+	}
+
+	for _, newSize := range event.Diff.NewSizes {
+		merged := false
+		for i, digestSize := range digest.Diff.NewSizes {
+			if digestSize.Name == newSize.Name {
+				digest.Diff.NewSizes[i] = newSize
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			digest.Diff.NewSizes = append(digest.Diff.NewSizes, newSize)
+		}
+	}
+}
+
+// Identifies a change by SKU, event type and size set. Price changes also include the new price
+func cooldownKey(event *Event) string {
+	sizes := event.Diff.NewSizes
+	if event.Kind != EVENT_RESTOCK {
+		sizes = event.Product.AvailableSizes
+	}
+
+	sizeNames := []string{}
+	for _, availableSize := range sizes {
+		sizeNames = append(sizeNames, availableSize.Name)
+	}
+	sort.Strings(sizeNames)
+
+	parts := []string{event.Region, event.Product.Sku, event.Kind, strings.Join(sizeNames, ",")}
+	if event.Kind == EVENT_PRICE {
+		parts = append(parts, event.Product.Price.String())
+	}
+
+	return strings.Join(parts, "|")
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type dispatchRecorder struct {
+	mu     sync.Mutex
+	events []*Event
+}
+
+func (r *dispatchRecorder) dispatch(event *Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events = append(r.events, event)
+}
+
+func (r *dispatchRecorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.events)
+}
+
+func restockOfSize(sku string, size string) *Event {
+	event := testRestockEvent(sku)
+	event.Product.AvailableSizes = []AvailableSize{{Name: size, AmountInStock: 1}}
+	event.Diff.NewSizes = []AvailableSize{{Name: size, AmountInStock: 1}}
+	return event
+}
+
+func TestCooldownSuppressesRepeatedEvents(t *testing.T) {
+	recorder := &dispatchRecorder{}
+	cooldown := NewNotificationCooldown(recorder.dispatch)
+
+	cooldown.Submit(restockOfSize("DV0833-104", "EU 42"), time.Minute, 0)
+	cooldown.Submit(restockOfSize("DV0833-104", "EU 42"), time.Minute, 0)
+	cooldown.Submit(restockOfSize("DV0833-104", "EU 43"), time.Minute, 0)
+
+	if count := recorder.count(); count != 2 {
+		t.Errorf("got %d dispatched events, want 2", count)
+	}
+}
+
+func TestCooldownMergesDigest(t *testing.T) {
+	useTestOutbox(t)
+
+	recorder := &dispatchRecorder{}
+	cooldown := NewNotificationCooldown(recorder.dispatch)
+
+	cooldown.Submit(restockOfSize("DV0833-104", "EU 42"), 0, time.Hour)
+	if count := recorder.count(); count != 1 {
+		t.Fatalf("got %d dispatched events, want the first restock sent immediately", count)
+	}
+
+	cooldown.Submit(restockOfSize("DV0833-104", "EU 43"), 0, time.Hour)
+	cooldown.Submit(restockOfSize("DV0833-104", "EU 44"), 0, time.Hour)
+
+	if count := recorder.count(); count != 1 {
+		t.Fatalf("got %d dispatched events before the digest delay, want 1", count)
+	}
+
+	cooldown.Flush()
+
+	if count := recorder.count(); count != 2 {
+		t.Fatalf("got %d dispatched events, want 2", count)
+	}
+	digest := recorder.events[1]
+	if digest.Digest != 2 || len(digest.Diff.NewSizes) != 2 {
+		t.Errorf("got digest %d with new sizes %v, want the 2 follow-up restocks merged", digest.Digest, digest.Diff.NewSizes)
+	}
+
+	if pending := notificationOutbox.Pending(); len(pending) != 0 {
+		t.Errorf("got %d pending outbox records after the flush, want 0", len(pending))
+	}
+
+	// A restock after the digest delay is sent immediately again
+	cooldown.Submit(restockOfSize("DV0833-104", "EU 45"), 0, time.Hour)
+	if count := recorder.count(); count != 3 {
+		t.Errorf("got %d dispatched events, want the restock after the delay sent immediately", count)
+	}
+}
+
+func TestCooldownFlushWithoutFollowUps(t *testing.T) {
+	useTestOutbox(t)
+
+	recorder := &dispatchRecorder{}
+	cooldown := NewNotificationCooldown(recorder.dispatch)
+
+	cooldown.Submit(restockOfSize("DV0833-104", "EU 42"), 0, time.Hour)
+	cooldown.Flush()
+
+	if count := recorder.count(); count != 1 {
+		t.Errorf("got %d dispatched events, want only the first restock", count)
+	}
+}
+
+func TestCooldownKeepsHeldDigestInOutbox(t *testing.T) {
+	oldOutbox := notificationOutbox
+	t.Cleanup(func() { notificationOutbox = oldOutbox })
+
+	path := filepath.Join(t.TempDir(), "outbox.jsonl")
+	notificationOutbox = openTestOutbox(t, path)
+
+	cooldown := NewNotificationCooldown((&dispatchRecorder{}).dispatch)
+	cooldown.Submit(restockOfSize("DV0833-104", "EU 42"), 0, time.Hour)
+	cooldown.Submit(restockOfSize("DV0833-104", "EU 43"), 0, time.Hour)
+	cooldown.Submit(restockOfSize("DV0833-104", "EU 44"), 0, time.Hour)
+
+	// The monitor stops before the digest delay passed
+	notificationOutbox.Close()
+	notificationOutbox = openTestOutbox(t, path)
+
+	pending := notificationOutbox.Pending()
+	if len(pending) != 1 || pending[0].Sink != SINK_DIGEST {
+		t.Fatalf("got %d pending records, want the held digest", len(pending))
+	}
+	if sizes := pending[0].Event.Diff.NewSizes; len(sizes) != 2 {
+		t.Errorf("got held sizes %v, want both follow-up sizes", sizes)
+	}
+}
+
+func TestReplayOutboxDispatchesHeldDigests(t *testing.T) {
+	region := setupTestRegion(t)
+	outbox := useTestOutbox(t)
+
+	event := restockOfSize("DV0833-104", "EU 42")
+	event.Digest = 1
+	outbox.Put(&OutboxRecord{
+		Key:   digestOutboxKey("EU|DV0833-104|restock"),
+		Sink:  SINK_DIGEST,
+		Event: event,
+	})
+
+	webhookHandler.ReplayOutbox()
+	webhookHandler.Stop()
+
+	if events := region.sink.eventsFor(EVENT_RESTOCK, "DV0833-104"); len(events) != 1 {
+		t.Errorf("got %d restock events, want the replayed digest", len(events))
+	}
+	if pending := outbox.Pending(); len(pending) != 0 {
+		t.Errorf("got %d pending records after the replay, want 0", len(pending))
+	}
+}
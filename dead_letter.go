@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+var deadLetterMu sync.Mutex = sync.Mutex{}
+
+// Notifications that could not be delivered, one json object per line in ./logs/dead_letters.jsonl
+type DeadLetter struct {
+	Time    time.Time       `json:"time"`
+	Sink    string          `json:"sink"`
+	Target  string          `json:"target"`
+	Kind    string          `json:"kind"`
+	Sku     string          `json:"sku"`
+	Reason  string          `json:"reason"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+func writeDeadLetter(sink string, target string, kind string, sku string, reason string, payload []byte) {
+	deadLetter := DeadLetter{
+		Time:    time.Now(),
+		Sink:    sink,
+		Target:  target,
+		Kind:    kind,
+		Sku:     sku,
+		Reason:  reason,
+		Payload: payload,
+	}
+	if !json.Valid(payload) {
+		deadLetter.Payload = nil
+	}
+
+	bytes, err := json.Marshal(deadLetter)
+	if err != nil {
+		mainLogger.Red(fmt.Sprintf("Error marshalling dead letter: %v", err))
+		return
+	}
+
+	deadLetterMu.Lock()
+	defer deadLetterMu.Unlock()
+
+	file, err := os.OpenFile(pathDeadLetterLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		mainLogger.Red(fmt.Sprintf("Error opening dead letter log: %v", err))
+		return
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(bytes, '\n'))
+	if err != nil {
+		mainLogger.Red(fmt.Sprintf("Error writing dead letter log: %v", err))
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 var defaultConfig Config = Config{
+	ConfigVersion: CONFIG_VERSION,
 	NormalTask: NormalTaskConfig{
 		Timeout:     5000,
 		BurstStart:  true,
@@ -10,21 +11,80 @@ var defaultConfig Config = Config{
 		Timeout:     5000,
 		BurstStart:  true,
 		WebhookUrls: []string{},
+		NewArrivals: NewArrivalsConfig{
+			Categories: nil,
+			Sort:       DEFAULT_NEW_ARRIVALS_SORT,
+			Rows:       DEFAULT_NEW_ARRIVALS_ROWS,
+			MaxPages:   DEFAULT_NEW_ARRIVALS_MAX_PAGES,
+		},
 	},
+	Backend:             "sns",
 	MaxTasksPerProxy:    2,
 	ProxyfileName:       "",
 	WebhookErrorTimeout: 3500,
 	RemoveBadProxy:      false,
 	EnableFileLogging:   false,
+	Regions:             []RegionConfig{defaultRegionConfig},
+	ControlApi: ControlApiConfig{
+		Enabled: false,
+		Port:    8085,
+	},
+	Notifiers: NotifiersConfig{
+		Events: map[string][]string{
+			EVENT_RESTOCK:   {SINK_DISCORD},
+			EVENT_PRICE:     {SINK_DISCORD},
+			EVENT_AVAILABLE: {SINK_DISCORD},
+			EVENT_LOAD:      {SINK_DISCORD},
+		},
+	},
+	Cooldown: CooldownConfig{
+		WindowInSeconds:           300,
+		DigestDelayInMilliseconds: 5000,
+	},
 }
 
-var defaultProductStates ProductStates = ProductStates{
-	Normal: ProductStatesNormal{
-		ProductStates: []*ProductStateNormal{},
-	},
-	Load: ProductStatesLoad{
-		NotifiedProducts: []*ProductStateLoad{},
-		LastKnownPid:     "",
-		KeywordQueries:   []string{},
+const (
+	DEFAULT_NEW_ARRIVALS_SORT      = "publishing_date desc"
+	DEFAULT_NEW_ARRIVALS_ROWS      = 40
+	DEFAULT_NEW_ARRIVALS_MAX_PAGES = 3
+)
+
+// Keyed by region instance, the storefronts name their categories in their own language
+var defaultNewArrivalsCategories map[string][]string = map[string][]string{
+	"EU": {
+		"Skate-Sneakers",
+		"Basketball-Schuhe",
+		"Court-Sneakers",
+		"Retro Basketball-Schuhe",
+		"Laufschuhe",
+		"Schuhe",
+		"Slides & Sandalen",
+		"Retro Runners",
+		"Trail-Sneakers",
 	},
+	"UK": defaultNewArrivalsCategoriesEnglish,
+	"US": defaultNewArrivalsCategoriesEnglish,
+}
+
+var defaultNewArrivalsCategoriesEnglish []string = []string{
+	"Skate Sneakers",
+	"Basketball Shoes",
+	"Court Sneakers",
+	"Retro Basketball Shoes",
+	"Running Shoes",
+	"Shoes",
+	"Slides & Sandals",
+	"Retro Runners",
+	"Trail Sneakers",
+}
+
+var defaultRegionConfig RegionConfig = RegionConfig{
+	Name:                 "EU",
+	Instance:             "EU",
+	CurrencyCode:         "EUR",
+	ProductUrlPrefix:     "https://www.sneakersnstuff.com/de",
+	NewArrivalsViewId:    "app_emea",
+	NewArrivalsDomainKey: "sneakersnstuff_de",
+	NormalWebhookUrls:    []string{},
+	LoadWebhookUrls:      []string{},
 }
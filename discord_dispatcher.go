@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DISCORD_MAX_ATTEMPTS = 5
+	DISCORD_QUEUE_SIZE   = 1000
+)
+
+var discordDispatcher *DiscordDispatcher = NewDiscordDispatcher()
+
+// One queue and worker per webhook url, so a throttled channel only delays its own messages
+type DiscordDispatcher struct {
+	mu            sync.Mutex
+	queues        map[string]*discordQueue
+	globalResetAt time.Time // Set by 429 responses with "global": true
+	pending       sync.WaitGroup
+	client        *http.Client
+	logger        *Logger
+}
+
+type discordQueue struct {
+	url       string
+	ch        chan *discordDelivery
+	remaining int // From X-RateLimit-Remaining, -1 if unknown
+	resetAt   time.Time
+}
+
+type discordDelivery struct {
+	outboxKey     string
+	url           string
+	payload       []byte
+	kind          string
+	sku           string
+	fallbackDelay time.Duration // Used when a 429 carries no retry_after
+}
+
+type discordRateLimitResponse struct {
+	RetryAfter float64 `json:"retry_after"`
+	Global     bool    `json:"global"`
+}
+
+func NewDiscordDispatcher() *DiscordDispatcher {
+	return &DiscordDispatcher{
+		mu:     sync.Mutex{},
+		queues: make(map[string]*discordQueue),
+		client: &http.Client{
+			Timeout: 15 * time.Second,
+		},
+		logger: NewLogger("DISCORD"),
+	}
+}
+
+func (d *DiscordDispatcher) Enqueue(delivery *discordDelivery) {
+	d.mu.Lock()
+	queue, ok := d.queues[delivery.url]
+	if !ok {
+		queue = &discordQueue{
+			url:       delivery.url,
+			ch:        make(chan *discordDelivery, DISCORD_QUEUE_SIZE),
+			remaining: -1,
+		}
+		d.queues[delivery.url] = queue
+
+		go d.run(queue)
+	}
+	d.mu.Unlock()
+
+	d.pending.Add(1)
+
+	select {
+	case queue.ch <- delivery:
+	default:
+		d.pending.Done()
+		notificationOutbox.Done(delivery.outboxKey)
+
+		d.logger.Red(fmt.Sprintf("%s: Queue full, dropping %s notification for %s", redactWebhookUrl(delivery.url), delivery.kind, delivery.sku))
+		writeDeadLetter(SINK_DISCORD, delivery.url, delivery.kind, delivery.sku, "queue full", delivery.payload)
+	}
+}
+
+// Blocks until every queued delivery was sent or dead-lettered
+func (d *DiscordDispatcher) Wait() {
+	d.pending.Wait()
+}
+
+func (d *DiscordDispatcher) run(queue *discordQueue) {
+	for delivery := range queue.ch {
+		d.deliver(queue, delivery)
+
+		notificationOutbox.Done(delivery.outboxKey)
+		d.pending.Done()
+	}
+}
+
+func (d *DiscordDispatcher) deliver(queue *discordQueue, delivery *discordDelivery) {
+	lastErr := ""
+
+	for attempt := 1; attempt <= DISCORD_MAX_ATTEMPTS; attempt++ {
+		d.waitForBucket(queue)
+
+		retryAfter, err := d.send(queue, delivery)
+		if err == nil {
+			return
+		}
+
+		lastErr = err.Error()
+
+		if retryAfter < 0 || attempt == DISCORD_MAX_ATTEMPTS {
+			break // Not retryable or out of attempts
+		}
+
+		d.logger.Yellow(fmt.Sprintf("%s: %v. Attempt %d/%d in %s", redactWebhookUrl(queue.url), err, attempt+1, DISCORD_MAX_ATTEMPTS, retryAfter.Round(time.Millisecond)))
+
+		time.Sleep(retryAfter)
+	}
+
+	d.logger.Red(fmt.Sprintf("%s: Giving up on %s notification for %s: %s", redactWebhookUrl(queue.url), delivery.kind, delivery.sku, lastErr))
+	writeDeadLetter(SINK_DISCORD, delivery.url, delivery.kind, delivery.sku, lastErr, delivery.payload)
+}
+
+// Sleeps until the bucket of the url and the global limit allow another request
+func (d *DiscordDispatcher) waitForBucket(queue *discordQueue) {
+	d.mu.Lock()
+	globalResetAt := d.globalResetAt
+	d.mu.Unlock()
+
+	if wait := time.Until(globalResetAt); wait > 0 {
+		time.Sleep(wait)
+	}
+
+	if queue.remaining == 0 {
+		if wait := time.Until(queue.resetAt); wait > 0 {
+			time.Sleep(wait)
+		}
+		queue.remaining = -1
+	}
+}
+
+// Returns the delay before retrying, or a negative delay if the request must not be retried
+func (d *DiscordDispatcher) send(queue *discordQueue, delivery *discordDelivery) (time.Duration, error) {
+	req, err := http.NewRequest("POST", delivery.url, bytes.NewBuffer(delivery.payload))
+	if err != nil {
+		return -1, fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return delivery.fallbackDelay, fmt.Errorf("error sending request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return delivery.fallbackDelay, fmt.Errorf("error reading response body: %v", err)
+	}
+
+	if remaining, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining")); err == nil {
+		queue.remaining = remaining
+	}
+	if resetAfter, err := strconv.ParseFloat(resp.Header.Get("X-RateLimit-Reset-After"), 64); err == nil {
+		queue.resetAt = time.Now().Add(secondsToDuration(resetAfter))
+	}
+
+	switch {
+	case resp.StatusCode == 200 || resp.StatusCode == 204:
+		return 0, nil
+	case resp.StatusCode == 429:
+		retryAfter := delivery.fallbackDelay
+
+		var rateLimit discordRateLimitResponse
+		if json.Unmarshal(body, &rateLimit) == nil && rateLimit.RetryAfter > 0 {
+			retryAfter = secondsToDuration(rateLimit.RetryAfter)
+		} else if headerRetryAfter, err := strconv.ParseFloat(resp.Header.Get("Retry-After"), 64); err == nil {
+			retryAfter = secondsToDuration(headerRetryAfter)
+		}
+
+		if rateLimit.Global || resp.Header.Get("X-RateLimit-Global") == "true" {
+			d.mu.Lock()
+			d.globalResetAt = time.Now().Add(retryAfter)
+			d.mu.Unlock()
+		}
+
+		return retryAfter, fmt.Errorf("rate limited")
+	case resp.StatusCode >= 500:
+		return delivery.fallbackDelay, fmt.Errorf("unexpected response (%d): %s", resp.StatusCode, body)
+	default:
+		return -1, fmt.Errorf("unexpected response (%d): %s", resp.StatusCode, body)
+	}
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// Cuts the token off discord webhook urls, so it does not end up in logs
+func redactWebhookUrl(webhookUrl string) string {
+	if i := strings.Index(webhookUrl, "/webhooks/"); i >= 0 {
+		rest := webhookUrl[i+len("/webhooks/"):]
+		if j := strings.Index(rest, "/"); j >= 0 {
+			return webhookUrl[:i+len("/webhooks/")+j] + "/***"
+		}
+	}
+	return webhookUrl
+}
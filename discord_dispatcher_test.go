@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDiscordDispatcherRetries(t *testing.T) {
+	responses := map[string][]int{
+		"gives up after the last attempt": {500, 500, 500, 500, 500, 500},
+		"retries rate limits":             {429, 204},
+		"does not retry client errors":    {400, 204},
+	}
+	wantRequests := map[string]int32{
+		"gives up after the last attempt": DISCORD_MAX_ATTEMPTS,
+		"retries rate limits":             2,
+		"does not retry client errors":    1,
+	}
+
+	for name, statuses := range responses {
+		var requests atomic.Int32
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			i := requests.Add(1) - 1
+			w.WriteHeader(statuses[min(int(i), len(statuses)-1)])
+		}))
+
+		dispatcher := NewDiscordDispatcher()
+		dispatcher.Enqueue(&discordDelivery{
+			url:           server.URL + "/webhooks/1/token",
+			payload:       []byte(`{}`),
+			kind:          EVENT_RESTOCK,
+			sku:           "DV0833-104",
+			fallbackDelay: time.Millisecond,
+		})
+		dispatcher.Wait()
+		server.Close()
+
+		if got := requests.Load(); got != wantRequests[name] {
+			t.Errorf("%s: got %d requests, want %d", name, got, wantRequests[name])
+		}
+	}
+}
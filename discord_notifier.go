@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	discordwebhook "github.com/bensch777/discord-webhook-golang"
+)
+
+const (
+	DEFAULT_ICON_URL    = "https://cdn.discordapp.com/attachments/1008077694409379962/1169271584805097543/0a4de578debc18ad1448c3bb14197df1.png?ex=66db0805&is=66d9b685&hm=10bcd145748d5ead3fe4ff8876b5ec7578830915862176d50374c43ea65e695f&"
+	DEFAULT_COLOR       = 10104109
+	DEFAULT_FOOTER_TEXT = "Monitor by linus"
+
+	DISCORD_MAX_FIELD_LINES = 25
+)
+
+type DiscordNotifier struct {
+	avatarUrl    string
+	color        int
+	footerText   string
+	errorTimeout time.Duration
+	logger       *Logger
+}
+
+// Take configMu RLock before calling NewDiscordNotifier!
+func NewDiscordNotifier() *DiscordNotifier {
+	avatarUrl := DEFAULT_ICON_URL
+	if config.DiscordPresence.AvatarUrl != "" {
+		avatarUrl = config.DiscordPresence.AvatarUrl
+	}
+
+	color := DEFAULT_COLOR
+	if config.DiscordPresence.EmbedColor != 0 {
+		color = config.DiscordPresence.EmbedColor
+	}
+
+	footerText := DEFAULT_FOOTER_TEXT
+	if config.DiscordPresence.FooterText != "" {
+		footerText = config.DiscordPresence.FooterText
+	}
+
+	return &DiscordNotifier{
+		avatarUrl:    avatarUrl,
+		color:        color,
+		footerText:   footerText,
+		errorTimeout: time.Millisecond * time.Duration(config.WebhookErrorTimeout),
+		logger:       NewLogger("DISCORD"),
+	}
+}
+
+func (n *DiscordNotifier) Name() string {
+	return SINK_DISCORD
+}
+
+// Hands the embed to the per url queues of the discord dispatcher
+func (n *DiscordNotifier) Notify(event *Event) error {
+	hook := discordwebhook.Hook{
+		Username:   "Sneakersnstuff",
+		Avatar_url: n.avatarUrl,
+		Embeds:     []discordwebhook.Embed{*n.createEmbed(event)},
+	}
+
+	payload, err := json.Marshal(hook)
+	if err != nil {
+		return fmt.Errorf("error marshalling payload: %v", err)
+	}
+
+	for _, webhookUrl := range event.WebhookUrls {
+		key := outboxKey(SINK_DISCORD, webhookUrl, event)
+
+		added := notificationOutbox.Add(&OutboxRecord{
+			Key:     key,
+			Sink:    SINK_DISCORD,
+			Target:  webhookUrl,
+			Event:   event,
+			Payload: payload,
+		})
+		if !added {
+			n.logger.Grey(fmt.Sprintf("%s: Skipping duplicate %s notification for %s", redactWebhookUrl(webhookUrl), event.Kind, event.Product.Sku))
+			continue
+		}
+
+		discordDispatcher.Enqueue(&discordDelivery{
+			outboxKey:     key,
+			url:           webhookUrl,
+			payload:       payload,
+			kind:          event.Kind,
+			sku:           event.Product.Sku,
+			fallbackDelay: n.errorTimeout,
+		})
+	}
+
+	return nil
+}
+
+func (n *DiscordNotifier) createEmbed(event *Event) *discordwebhook.Embed {
+	if rendered, ok := notificationTemplates.Render(SINK_DISCORD, event); ok {
+		var embed discordwebhook.Embed
+
+		err := json.Unmarshal([]byte(rendered), &embed)
+		if err == nil {
+			if embed.Color == 0 {
+				embed.Color = n.color
+			}
+			if embed.Timestamp.IsZero() {
+				embed.Timestamp = event.Time
+			}
+
+			return &embed
+		}
+
+		n.logger.Red(fmt.Sprintf("Discord template for %s did not render a valid embed: %v", event.Kind, err))
+	}
+
+	embed := discordwebhook.Embed{
+		Title:     event.Product.Title,
+		Color:     n.color,
+		Url:       event.Product.ProductUrl,
+		Timestamp: event.Time,
+		Thumbnail: discordwebhook.Thumbnail{
+			Url: event.Product.ImageUrl,
+		},
+		Fields: n.createFields(event),
+		Footer: discordwebhook.Footer{
+			Text:     fmt.Sprintf("%s • Sneakersnstuff", n.footerText),
+			Icon_url: n.avatarUrl,
+		},
+	}
+
+	return &embed
+}
+
+func (n *DiscordNotifier) createFields(event *Event) []discordwebhook.Field {
+	fields := []discordwebhook.Field{
+		{
+			Name:   "SKU/PID",
+			Value:  event.Product.Sku,
+			Inline: true,
+		},
+		{
+			Name:   "PRICE",
+			Value:  event.PriceText(),
+			Inline: true,
+		},
+		{
+			Name:   "TYPE",
+			Value:  event.Label(),
+			Inline: true,
+		},
+	}
+
+	for _, field := range event.PriceFields() {
+		fields = append(fields, discordwebhook.Field{
+			Name:   field.Name,
+			Value:  field.Value,
+			Inline: true,
+		})
+	}
+
+	if event.ShowAvailable() {
+		fields = append(fields, discordwebhook.Field{
+			Name:   "AVAILABLE",
+			Value:  strconv.FormatBool(event.Product.AvailableForSale),
+			Inline: true,
+		})
+	}
+
+	// 25 lines is max allowed lines per field
+	sizesChunks := chunkLines(event.SizeLines(), DISCORD_MAX_FIELD_LINES)
+	if len(sizesChunks) == 0 {
+		fields = append(fields, discordwebhook.Field{
+			Name:   "Sizes",
+			Value:  "*none*",
+			Inline: false,
+		})
+	} else if len(sizesChunks) == 1 {
+		fields = append(fields, discordwebhook.Field{
+			Name:   "Sizes",
+			Value:  strings.Join(sizesChunks[0], "\n"),
+			Inline: false,
+		})
+	} else {
+		for i, sizesChunk := range sizesChunks {
+			fields = append(fields, discordwebhook.Field{
+				Name:   fmt.Sprintf("Sizes %d", i+1),
+				Value:  strings.Join(sizesChunk, "\n"),
+				Inline: i != 0,
+			})
+		}
+	}
+
+	if len(event.MatchedQueries) > 0 {
+		matchedQueries := event.MatchedQueries
+		overshoot := 0
+		if len(matchedQueries) > DISCORD_MAX_FIELD_LINES {
+			overshoot = len(matchedQueries) - (DISCORD_MAX_FIELD_LINES - 1)
+			matchedQueries = matchedQueries[:DISCORD_MAX_FIELD_LINES-1]
+		}
+
+		strVal := strings.Join(matchedQueries, "\n")
+		if overshoot > 0 {
+			strVal += fmt.Sprintf("\n*[+ %d more]*", overshoot)
+		}
+
+		fields = append(fields, discordwebhook.Field{
+			Name:   "Keyword Query Hits",
+			Value:  strVal,
+			Inline: false,
+		})
+	}
+
+	links := []string{}
+	for i, link := range event.Links() {
+		if i == 0 {
+			links = append(links, fmt.Sprintf("[**%s**](%s)", link.Text, link.Url))
+		} else {
+			links = append(links, fmt.Sprintf("[%s](%s)", link.Text, link.Url))
+		}
+	}
+
+	fields = append(fields, discordwebhook.Field{
+		Name:   "Extra",
+		Value:  strings.Join(links, " | "),
+		Inline: false,
+	})
+
+	return fields
+}
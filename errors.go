@@ -1,6 +1,9 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 type TaskNotReadyError struct{}
 
@@ -59,6 +62,15 @@ func (e *QueryNotFoundError) Error() string {
 	return fmt.Sprintf("%s \"%s\" not found", e.queryType, e.queryValue)
 }
 
+type InvalidQueryError struct {
+	queryType  string
+	queryValue string
+}
+
+func (e *InvalidQueryError) Error() string {
+	return fmt.Sprintf("invalid %s \"%s\"", e.queryType, e.queryValue)
+}
+
 type AlreadyIncludedError struct {
 	statesType    string
 	includedType  string
@@ -78,3 +90,53 @@ type NotIncludedError struct {
 func (e *NotIncludedError) Error() string {
 	return fmt.Sprintf("%s \"%s\" not included in %s product states", e.includedType, e.includedValue, e.statesType)
 }
+
+type RegionNotFoundError struct {
+	regionName string
+}
+
+func (e *RegionNotFoundError) Error() string {
+	return fmt.Sprintf("region \"%s\" not found", e.regionName)
+}
+
+type ConfigValidationError struct {
+	problems []string
+}
+
+func (e *ConfigValidationError) Error() string {
+	return fmt.Sprintf("invalid config: %s", strings.Join(e.problems, "; "))
+}
+
+// Returned by notifiers that send to several targets. Only the failed targets are listed,
+// the others were delivered and must not be sent again
+type DeliveryError struct {
+	failures []DeliveryFailure
+}
+
+type DeliveryFailure struct {
+	target string // Webhook url or chat id
+	err    error
+}
+
+func (e *DeliveryError) add(target string, err error) {
+	e.failures = append(e.failures, DeliveryFailure{
+		target: target,
+		err:    err,
+	})
+}
+
+// Returns nil if every target was delivered
+func (e *DeliveryError) orNil() error {
+	if len(e.failures) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e *DeliveryError) Error() string {
+	errs := []string{}
+	for _, failure := range e.failures {
+		errs = append(errs, fmt.Sprintf("%s: %v", redactUrl(failure.target), failure.err))
+	}
+	return strings.Join(errs, "; ")
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	EVENT_RESTOCK   = "RESTOCK"
+	EVENT_PRICE     = "PRICE"
+	EVENT_AVAILABLE = "AVAILABLE"
+	EVENT_LOAD      = "LOAD"
+)
+
+var eventLabels map[string]string = map[string]string{
+	EVENT_RESTOCK:   "RESTOCK",
+	EVENT_PRICE:     "PRICE CHANGE",
+	EVENT_AVAILABLE: "AVAILABILITY CHANGE",
+	EVENT_LOAD:      "LOAD",
+}
+
+// Emitted by the task groups. Sinks render it with their own formatter
+type Event struct {
+	Kind           string       `json:"kind"`
+	Region         string       `json:"region"`
+	Time           time.Time    `json:"time"`
+	Product        ProductData  `json:"product"`
+	Previous       *ProductData `json:"previous,omitempty"` // State before the change. Nil for loads
+	Diff           EventDiff    `json:"diff"`
+	MatchedQueries []string     `json:"matchedQueries,omitempty"`
+	Digest         int          `json:"digest,omitempty"` // Number of restocks merged into this event
+	WebhookUrls    []string     `json:"-"`                // Overrides the region webhook urls if not nil
+}
+
+type EventDiff struct {
+	NewSizes        []AvailableSize `json:"newSizes,omitempty"`
+	OldPrice        *Price          `json:"oldPrice,omitempty"`
+	NewPrice        *Price          `json:"newPrice,omitempty"`
+	DropPercent     float64         `json:"dropPercent,omitempty"` // Negative for increases
+	LowestPrice     *Price          `json:"lowestPrice,omitempty"` // All-time low including the new price
+	BecameAvailable bool            `json:"becameAvailable,omitempty"`
+}
+
+type EventLink struct {
+	Text string
+	Url  string
+}
+
+type EventField struct {
+	Name  string
+	Value string
+}
+
+func (e *Event) Label() string {
+	label, ok := eventLabels[e.Kind]
+	if !ok {
+		label = e.Kind
+	}
+
+	if e.Digest > 1 {
+		return fmt.Sprintf("%s (%d UPDATES)", label, e.Digest)
+	}
+	return label
+}
+
+// Price as shown in notifications. Price changes show the previous price too
+func (e *Event) PriceText() string {
+	if e.Kind == EVENT_PRICE && e.Diff.OldPrice != nil {
+		return fmt.Sprintf("%s -> %s (%+.1f%%)", e.Diff.OldPrice, e.Product.Price, -e.Diff.DropPercent)
+	}
+	return e.Product.Price.String()
+}
+
+// Original price of products on sale and the all-time low of price changes
+func (e *Event) PriceFields() []EventField {
+	fields := []EventField{}
+
+	if e.Product.SalePrice != nil && e.Product.BasePrice.Amount > e.Product.Price.Amount {
+		fields = append(fields, EventField{
+			Name:  "BASE PRICE",
+			Value: e.Product.BasePrice.String(),
+		})
+	}
+
+	if e.Kind == EVENT_PRICE && e.Diff.LowestPrice != nil {
+		fields = append(fields, EventField{
+			Name:  "ALL-TIME LOW",
+			Value: e.Diff.LowestPrice.String(),
+		})
+	}
+
+	return fields
+}
+
+// Loads always show availability, other events only if the product is not for sale
+func (e *Event) ShowAvailable() bool {
+	return e.Kind == EVENT_LOAD || !e.Product.AvailableForSale
+}
+
+func (e *Event) SizeLines() []string {
+	lines := []string{}
+	for _, availableSize := range e.Product.AvailableSizes {
+		lines = append(lines, fmt.Sprintf("%s [%d]", availableSize.Name, availableSize.AmountInStock))
+	}
+	return lines
+}
+
+func (e *Event) Links() []EventLink {
+	return []EventLink{
+		{
+			Text: "StockX",
+			Url:  fmt.Sprintf("https://stockx.com/search?s=%s", e.Product.Sku),
+		},
+		{
+			Text: "App Link",
+			Url:  fmt.Sprintf("https://applinks.sneakersnstuff.com/product/%s", e.Product.Sku),
+		},
+	}
+}
+
+// Splits lines into chunks of at most size lines
+func chunkLines(lines []string, size int) [][]string {
+	chunks := [][]string{}
+	for len(lines) > size {
+		chunks = append(chunks, lines[:size])
+		lines = lines[size:]
+	}
+	if len(lines) > 0 {
+		chunks = append(chunks, lines)
+	}
+	return chunks
+}
@@ -7,118 +7,210 @@ import (
 	"log"
 	"os"
 	"strings"
-	"time"
 )
 
 var fileLogger *log.Logger = nil
 
 const (
-	pathConfig        string = "./config.json"
-	pathProductStates string = "./product_states.json"
-	pathLogfileFolder string = "./logs"
-	pathProxyFolder   string = "./proxies"
+	pathConfig                string = "./config.json"
+	pathProductStates         string = "./product_states.json" // Only read to migrate into the state store
+	pathProductStatesMigrated string = "./product_states.json.migrated"
+	pathStatesDb              string = "./states.db"
+	pathStateBackupFolder     string = "./backups"
+	pathLogfileFolder         string = "./logs"
+	pathProxyFolder           string = "./proxies"
+	pathCaptureFolder         string = "./captures"
+	pathTemplatesFolder       string = "./templates"
+	pathDeadLetterLog         string = "./logs/dead_letters.jsonl"
+	pathOutbox                string = "./outbox.jsonl"
+	pathHistoryDb             string = "./history.db"
 )
 
+// Replaces the current config only if config.json is valid
 func readConfig() error {
+	newConfig, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
 	configMu.Lock()
-	defer configMu.Unlock()
+	config = newConfig
+	configMu.Unlock()
+
+	return nil
+}
 
+// Reads, migrates and validates config.json. Creates it with the defaults if it is missing
+func loadConfig() (*Config, error) {
 	if _, err := os.Stat(pathConfig); os.IsNotExist(err) {
 		bytes, err := json.MarshalIndent(defaultConfig, "", "\t")
 		if err != nil {
-			return fmt.Errorf("create default config: error marshalling config: %v", err)
+			return nil, fmt.Errorf("create default config: error marshalling config: %v", err)
 		}
 
 		err = os.WriteFile(pathConfig, bytes, os.ModePerm)
 		if err != nil {
-			return fmt.Errorf("create default config: error creating logs folder: %v", err)
+			return nil, fmt.Errorf("create default config: error writing config: %v", err)
 		}
 
-		config = &Config{}
-		*config = defaultConfig
-	} else {
-		bytes, err := os.ReadFile(pathConfig)
-		if err != nil {
-			return fmt.Errorf("error reading config: %v", err)
-		}
+		newConfig := defaultConfig
+		return &newConfig, nil
+	}
 
-		var newConfig Config
+	bytes, err := os.ReadFile(pathConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config: %v", err)
+	}
+
+	migratedBytes, fromVersion, err := migrateConfig(bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	var newConfig Config
+
+	err = json.Unmarshal(migratedBytes, &newConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling config: %v", err)
+	}
+
+	err = newConfig.Validate()
+	if err != nil {
+		return nil, err
+	}
 
-		err = json.Unmarshal(bytes, &newConfig)
+	if fromVersion != newConfig.ConfigVersion {
+		err = writeMigratedConfig(bytes, &newConfig, fromVersion)
 		if err != nil {
-			return fmt.Errorf("error unmarshalling config: %v", err)
+			return nil, err
 		}
+	}
+
+	return &newConfig, nil
+}
+
+// Keeps the old file next to the migrated one
+func writeMigratedConfig(oldBytes []byte, newConfig *Config, fromVersion int) error {
+	backupPath := fmt.Sprintf("%s.v%d.bak", pathConfig, fromVersion)
+
+	err := writeFileAtomic(backupPath, oldBytes)
+	if err != nil {
+		return fmt.Errorf("error backing up config before migration: %v", err)
+	}
+
+	bytes, err := json.MarshalIndent(newConfig, "", "\t")
+	if err != nil {
+		return fmt.Errorf("error marshalling migrated config: %v", err)
+	}
 
-		config = &newConfig
+	err = writeFileAtomic(pathConfig, bytes)
+	if err != nil {
+		return fmt.Errorf("error writing migrated config: %v", err)
 	}
+
+	fileSystemLogger.Yellow(fmt.Sprintf("Migrated config from version %d to %d, the old config was saved as %s", fromVersion, newConfig.ConfigVersion, backupPath))
+
 	return nil
 }
 
-func refreshConfig() {
-	go func() {
-		for {
-			readConfig()
+// Loads the product states from the state store. An empty store is filled from product_states.json once
+func readProductStates(store StateStore) (*ProductStates, error) {
+	empty, err := store.IsEmpty()
+	if err != nil {
+		return nil, fmt.Errorf("error checking product states database: %v", err)
+	}
 
-			time.Sleep(time.Second * 1)
+	if empty {
+		err = migrateJsonProductStates(store)
+		if err != nil {
+			return nil, err
 		}
-	}()
+	}
+
+	return store.Load()
 }
 
-func readProductStates() (*ProductStates, error) {
-	productStateFileMu.Lock()
-	defer productStateFileMu.Unlock()
+// Moves the product states of the old json file into the state store and renames the file
+func migrateJsonProductStates(store StateStore) error {
+	bytes, err := os.ReadFile(pathProductStates)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading \"%s\": %v", pathProductStates, err)
+	}
 
-	if _, err := os.Stat(pathProductStates); os.IsNotExist(err) {
-		bytes, err := json.MarshalIndent(defaultProductStates, "", "\t")
-		if err != nil {
-			return nil, fmt.Errorf("create default product states: error marshalling product states: %v", err)
-		}
+	jsonProductStates, err := parseProductStates(bytes)
+	if err != nil {
+		return err
+	}
+	if jsonProductStates == nil {
+		return nil
+	}
 
-		err = os.WriteFile(pathProductStates, bytes, os.ModePerm)
-		if err != nil {
-			return nil, fmt.Errorf("create default product states: error creating logs folder: %v", err)
-		}
+	err = store.Save(jsonProductStates)
+	if err != nil {
+		return fmt.Errorf("error migrating \"%s\": %v", pathProductStates, err)
+	}
 
-		productStates := defaultProductStates
-		return &productStates, err
-	} else {
-		bytes, err := os.ReadFile(pathProductStates)
-		if err != nil {
-			return nil, fmt.Errorf("error reading \"%s\": %v", pathProductStates, err)
-		}
+	err = os.Rename(pathProductStates, pathProductStatesMigrated)
+	if err != nil {
+		return fmt.Errorf("error renaming \"%s\": %v", pathProductStates, err)
+	}
+
+	fileSystemLogger.Yellow(fmt.Sprintf("Migrated %s into %s", pathProductStates, pathStatesDb))
+
+	return nil
+}
 
-		var fileProductStates *ProductStates
+func parseProductStates(bytes []byte) (*ProductStates, error) {
+	var fileProductStates *ProductStates
 
-		err = json.Unmarshal(bytes, &fileProductStates)
+	err := json.Unmarshal(bytes, &fileProductStates)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling product states: %v", err)
+	}
+
+	if fileProductStates != nil && fileProductStates.Regions == nil {
+		err = migrateLegacyProductStates(bytes, fileProductStates)
 		if err != nil {
-			return nil, fmt.Errorf("error unmarshalling product states: %v", err)
+			return nil, fmt.Errorf("error migrating product states: %v", err)
 		}
-
-		return fileProductStates, nil
 	}
+
+	return fileProductStates, nil
 }
 
-func writeProductStates() {
-	if productStates == nil {
-		return
+// Moves product states written before multi region support into the first configured region
+func migrateLegacyProductStates(bytes []byte, productStates *ProductStates) error {
+	var legacyStates legacyProductStates
+
+	err := json.Unmarshal(bytes, &legacyStates)
+	if err != nil {
+		return err
 	}
 
-	statesNormalMu.Lock()
-	statesLoadMu.Lock()
-	productStateFileMu.Lock()
-	defer productStateFileMu.Unlock()
-	defer statesLoadMu.Unlock()
-	defer statesNormalMu.Unlock()
+	productStates.Regions = make(map[string]*RegionProductStates)
 
-	bytes, err := json.MarshalIndent(productStates, "", "\t")
-	if err != nil {
-		fileSystemLogger.Red(fmt.Sprintf("Error marshalling product states: %v", err))
+	if legacyStates.Normal == nil && legacyStates.Load == nil {
+		return nil
 	}
 
-	err = os.WriteFile(pathProductStates, bytes, 0644)
-	if err != nil {
-		fileSystemLogger.Red(fmt.Sprintf("Error writing product states: %v", err))
+	configMu.RLock()
+	regionName := strings.ToUpper(getRegionConfigs()[0].Name)
+	configMu.RUnlock()
+
+	regionStates := productStates.GetRegion(regionName)
+	if legacyStates.Normal != nil {
+		regionStates.Normal = *legacyStates.Normal
 	}
+	if legacyStates.Load != nil {
+		regionStates.Load = *legacyStates.Load
+	}
+
+	fileSystemLogger.Yellow(fmt.Sprintf("Migrated product states to region %s", regionName))
+
+	return nil
 }
 
 func readProxyfile(filename string) ([]*proxy, error) {
@@ -200,6 +292,16 @@ func checkProxyfolder() error {
 	return nil
 }
 
+func checkTemplatesfolder() error {
+	if _, err := os.Stat(pathTemplatesFolder); os.IsNotExist(err) {
+		err := os.Mkdir(pathTemplatesFolder, os.ModePerm)
+		if err != nil {
+			return fmt.Errorf("error creating templates folder: %v", err)
+		}
+	}
+	return nil
+}
+
 func createLogfile(path string) (*os.File, error) {
 	logfile, err := os.Create(path)
 	if err != nil {
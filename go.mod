@@ -2,15 +2,20 @@ module sns-app-monitor
 
 go 1.23.0
 
+require (
+	github.com/bensch777/discord-webhook-golang v0.0.6
+	github.com/bogdanfinn/fhttp v0.5.28
+	github.com/bogdanfinn/tls-client v1.7.8
+	github.com/fsnotify/fsnotify v1.8.0
+	github.com/gorilla/websocket v1.5.3
+	go.etcd.io/bbolt v1.3.11
+)
+
 require (
 	github.com/andybalholm/brotli v1.0.5 // indirect
-	github.com/bensch777/discord-webhook-golang v0.0.6 // indirect
-	github.com/bogdanfinn/fhttp v0.5.28 // indirect
-	github.com/bogdanfinn/tls-client v1.7.8 // indirect
 	github.com/bogdanfinn/utls v1.6.1 // indirect
 	github.com/cloudflare/circl v1.3.6 // indirect
 	github.com/google/uuid v1.6.0 // indirect
-	github.com/gorilla/websocket v1.5.3 // indirect
 	github.com/klauspost/compress v1.16.7 // indirect
 	github.com/quic-go/quic-go v0.37.4 // indirect
 	github.com/tam7t/hpkp v0.0.0-20160821193359-2b70b4024ed5 // indirect
@@ -0,0 +1,525 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	HISTORY_STATE = "STATE" // State transition detected by a normal task group
+	HISTORY_LOAD  = "LOAD"  // Product loaded by a load task group
+
+	HISTORY_QUEUE_SIZE    = 1000
+	HISTORY_BATCH_SIZE    = 100
+	HISTORY_DEFAULT_LIMIT = 100
+	BOLT_OPEN_TIMEOUT     = time.Second
+)
+
+var historyBucketName []byte = []byte("history")
+
+var historyStore *HistoryStore = nil
+
+// Time series of product state changes and load hits in an embedded bbolt database.
+// One bucket per region and sku, keyed by time so ranges are read with a cursor
+type HistoryStore struct {
+	db       *bolt.DB
+	recordCh chan *HistoryRecord
+	doneCh   chan struct{}
+	closeMu  sync.Mutex
+	closed   bool
+	logger   *Logger
+}
+
+type HistoryRecord struct {
+	Time             time.Time       `json:"time"`
+	Type             string          `json:"type"`
+	Region           string          `json:"region"`
+	Sku              string          `json:"sku"`
+	AvailableForSale bool            `json:"availableForSale"`
+	AvailableSizes   []AvailableSize `json:"availableSizes"`
+	NewSizes         []AvailableSize `json:"newSizes,omitempty"`
+	RemovedSizes     []AvailableSize `json:"removedSizes,omitempty"`
+	Price            Price           `json:"price"`
+	OldPrice         *Price          `json:"oldPrice,omitempty"`
+	MatchedQueries   []string        `json:"matchedQueries,omitempty"`
+}
+
+type HistoryQuery struct {
+	Region string
+	Sku    string
+	Type   string
+	Size   string // Only records where a matching size came back. Exact sizes or ranges like in restock filters
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+func OpenHistoryStore(path string) (*HistoryStore, error) {
+	db, err := openHistoryDb(path, false)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(historyBucketName)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating history bucket: %v", err)
+	}
+
+	store := &HistoryStore{
+		db:       db,
+		recordCh: make(chan *HistoryRecord, HISTORY_QUEUE_SIZE),
+		doneCh:   make(chan struct{}),
+		logger:   NewLogger("HISTORY"),
+	}
+
+	go store.run()
+
+	return store, nil
+}
+
+func openHistoryDb(path string, readOnly bool) (*bolt.DB, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{
+		Timeout:  BOLT_OPEN_TIMEOUT,
+		ReadOnly: readOnly,
+	})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("history database \"%s\" is in use by another process", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening history database: %v", err)
+	}
+
+	return db, nil
+}
+
+// Queues the record for writing. Never blocks the task groups, records are dropped if the queue is full
+func (s *HistoryStore) Append(record *HistoryRecord) {
+	if s == nil {
+		return
+	}
+
+	s.closeMu.Lock()
+	defer s.closeMu.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	select {
+	case s.recordCh <- record:
+	default:
+		s.logger.Red(fmt.Sprintf("%s: History queue full, dropping %s record", record.Sku, record.Type))
+	}
+}
+
+// Writes queued records in batches, one transaction per batch
+func (s *HistoryStore) run() {
+	defer close(s.doneCh)
+
+	for record := range s.recordCh {
+		batch := []*HistoryRecord{record}
+
+	collect:
+		for len(batch) < HISTORY_BATCH_SIZE {
+			select {
+			case record, ok := <-s.recordCh:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, record)
+			default:
+				break collect
+			}
+		}
+
+		err := s.write(batch)
+		if err != nil {
+			s.logger.Red(fmt.Sprintf("Error writing %d history records: %v", len(batch), err))
+		}
+	}
+}
+
+func (s *HistoryStore) write(batch []*HistoryRecord) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		root := tx.Bucket(historyBucketName)
+
+		for _, record := range batch {
+			bucket, err := root.CreateBucketIfNotExists(historyBucketKey(record.Region, record.Sku))
+			if err != nil {
+				return fmt.Errorf("error creating bucket for %s: %v", record.Sku, err)
+			}
+
+			seq, err := bucket.NextSequence()
+			if err != nil {
+				return err
+			}
+
+			bytes, err := json.Marshal(record)
+			if err != nil {
+				return fmt.Errorf("error marshalling history record: %v", err)
+			}
+
+			err = bucket.Put(historyRecordKey(record.Time, seq), bytes)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Writes the remaining queued records and closes the database
+func (s *HistoryStore) Close() error {
+	if s == nil {
+		return nil
+	}
+
+	s.closeMu.Lock()
+	if s.closed {
+		s.closeMu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.recordCh)
+	s.closeMu.Unlock()
+
+	<-s.doneCh
+
+	return s.db.Close()
+}
+
+func (s *HistoryStore) Query(query HistoryQuery) ([]HistoryRecord, error) {
+	return queryHistory(s.db, query)
+}
+
+// Returns the matching records, newest first
+func queryHistory(db *bolt.DB, query HistoryQuery) ([]HistoryRecord, error) {
+	query.Region = strings.ToUpper(strings.TrimSpace(query.Region))
+	query.Sku = strings.ToUpper(strings.TrimSpace(query.Sku))
+	query.Type = strings.ToUpper(strings.TrimSpace(query.Type))
+
+	if query.Limit <= 0 {
+		query.Limit = HISTORY_DEFAULT_LIMIT
+	}
+
+	records := []HistoryRecord{}
+
+	err := db.View(func(tx *bolt.Tx) error {
+		root := tx.Bucket(historyBucketName)
+		if root == nil {
+			return nil
+		}
+
+		return root.ForEachBucket(func(bucketKey []byte) error {
+			region, sku := splitHistoryBucketKey(bucketKey)
+			if query.Region != "" && region != query.Region {
+				return nil
+			}
+			if query.Sku != "" && sku != query.Sku {
+				return nil
+			}
+
+			bucketRecords, err := queryHistoryBucket(root.Bucket(bucketKey), query)
+			if err != nil {
+				return fmt.Errorf("error reading history of %s: %v", sku, err)
+			}
+
+			records = append(records, bucketRecords...)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Time.After(records[j].Time)
+	})
+
+	if len(records) > query.Limit {
+		records = records[:query.Limit]
+	}
+
+	return records, nil
+}
+
+// Walks the bucket backwards from the end of the range. Stops after the limit, older records can't make it into the result
+func queryHistoryBucket(bucket *bolt.Bucket, query HistoryQuery) ([]HistoryRecord, error) {
+	records := []HistoryRecord{}
+	cursor := bucket.Cursor()
+
+	var key, value []byte
+	if query.Until.IsZero() {
+		key, value = cursor.Last()
+	} else {
+		key, value = cursor.Seek(historyRecordKey(query.Until, 0))
+		if key == nil {
+			key, value = cursor.Last()
+		}
+
+		for key != nil && historyKeyTime(key).After(query.Until) {
+			key, value = cursor.Prev()
+		}
+	}
+
+	for ; key != nil && len(records) < query.Limit; key, value = cursor.Prev() {
+		if !query.Since.IsZero() && historyKeyTime(key).Before(query.Since) {
+			break
+		}
+
+		var record HistoryRecord
+		err := json.Unmarshal(value, &record)
+		if err != nil {
+			return nil, err
+		}
+
+		if query.Type != "" && record.Type != query.Type {
+			continue
+		}
+		if query.Size != "" && !historyRecordHasSize(record, query.Size) {
+			continue
+		}
+
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+func historyRecordHasSize(record HistoryRecord, sizeRule string) bool {
+	sizes := record.NewSizes
+	if record.Type == HISTORY_LOAD {
+		sizes = record.AvailableSizes
+	}
+
+	for _, availableSize := range sizes {
+		if matchSizeRule(sizeRule, availableSize.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+func historyBucketKey(region string, sku string) []byte {
+	return []byte(region + "/" + sku)
+}
+
+func splitHistoryBucketKey(bucketKey []byte) (string, string) {
+	region, sku, _ := strings.Cut(string(bucketKey), "/")
+	return region, sku
+}
+
+// Big endian unix nanoseconds followed by the bucket sequence, so keys sort by time
+func historyRecordKey(t time.Time, seq uint64) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key[:8], uint64(t.UnixNano()))
+	binary.BigEndian.PutUint64(key[8:], seq)
+	return key
+}
+
+func historyKeyTime(key []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(key[:8])))
+}
+
+// Accepts RFC 3339 times and durations like "24h", which count back from now
+func parseHistoryTime(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time \"%s\": use RFC 3339 or a duration like 24h", value)
+	}
+
+	return time.Now().Add(-duration), nil
+}
+
+// Parses the filters of the history api and the history command
+func parseHistoryQuery(values url.Values) (HistoryQuery, error) {
+	query := HistoryQuery{
+		Region: values.Get("region"),
+		Sku:    values.Get("sku"),
+		Type:   values.Get("type"),
+		Size:   values.Get("size"),
+	}
+
+	if query.Type != "" && !strings.EqualFold(query.Type, HISTORY_STATE) && !strings.EqualFold(query.Type, HISTORY_LOAD) {
+		return query, fmt.Errorf("invalid type \"%s\": use %s or %s", query.Type, HISTORY_STATE, HISTORY_LOAD)
+	}
+
+	var err error
+
+	query.Since, err = parseHistoryTime(values.Get("since"))
+	if err != nil {
+		return query, err
+	}
+	query.Until, err = parseHistoryTime(values.Get("until"))
+	if err != nil {
+		return query, err
+	}
+
+	if limitStr := values.Get("limit"); limitStr != "" {
+		query.Limit, err = strconv.Atoi(limitStr)
+		if err != nil || query.Limit < 0 {
+			return query, fmt.Errorf("invalid limit \"%s\"", limitStr)
+		}
+	}
+
+	return query, nil
+}
+
+func (r HistoryRecord) String() string {
+	parts := []string{
+		r.Time.Local().Format("02.01.06 15:04:05"),
+		r.Region,
+		r.Sku,
+		r.Type,
+	}
+
+	for _, newSize := range r.NewSizes {
+		parts = append(parts, fmt.Sprintf("+%s [%d]", newSize.Name, newSize.AmountInStock))
+	}
+	for _, removedSize := range r.RemovedSizes {
+		parts = append(parts, fmt.Sprintf("-%s", removedSize.Name))
+	}
+
+	if r.OldPrice != nil {
+		parts = append(parts, fmt.Sprintf("%s -> %s", r.OldPrice, r.Price))
+	} else {
+		parts = append(parts, r.Price.String())
+	}
+
+	if !r.AvailableForSale {
+		parts = append(parts, "not for sale")
+	}
+	if len(r.MatchedQueries) > 0 {
+		parts = append(parts, fmt.Sprintf("queries: %s", strings.Join(r.MatchedQueries, ", ")))
+	}
+
+	return strings.Join(parts, "  ")
+}
+
+// Prints the recorded history, e.g. "history -size "EU 44" -limit 1 DV0833-104" for the last restock of a size
+func runHistory(args []string) error {
+	flags := flag.NewFlagSet("history", flag.ContinueOnError)
+	region := flags.String("region", "", "only this region")
+	recordType := flags.String("type", "", "STATE or LOAD")
+	size := flags.String("size", "", "only records where this size came back, e.g. \"EU 44\" or \"EU 42-45\"")
+	since := flags.String("since", "", "RFC 3339 time or duration like 24h")
+	until := flags.String("until", "", "RFC 3339 time or duration like 1h")
+	limit := flags.Int("limit", 20, "maximum number of records")
+	asJson := flags.Bool("json", false, "print records as json lines")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() > 1 {
+		return errors.New("usage: history [flags] [sku]")
+	}
+
+	values := url.Values{}
+	values.Set("region", *region)
+	values.Set("sku", flags.Arg(0))
+	values.Set("type", *recordType)
+	values.Set("size", *size)
+	values.Set("since", *since)
+	values.Set("until", *until)
+	values.Set("limit", strconv.Itoa(*limit))
+
+	records, err := readHistory(values)
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		if *asJson {
+			bytes, err := json.Marshal(record)
+			if err != nil {
+				return fmt.Errorf("error marshalling history record: %v", err)
+			}
+			fmt.Println(string(bytes))
+		} else {
+			fmt.Println(record.String())
+		}
+	}
+
+	return nil
+}
+
+// Reads the database directly. While the monitor runs it holds the database lock, then the control api is asked instead
+func readHistory(values url.Values) ([]HistoryRecord, error) {
+	query, err := parseHistoryQuery(values)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(pathHistoryDb); os.IsNotExist(err) {
+		return []HistoryRecord{}, nil
+	}
+
+	db, dbErr := openHistoryDb(pathHistoryDb, true)
+	if dbErr == nil {
+		defer db.Close()
+		return queryHistory(db, query)
+	}
+
+	err = readConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	configMu.RLock()
+	controlApi := config.ControlApi
+	configMu.RUnlock()
+
+	if !controlApi.Enabled {
+		return nil, dbErr
+	}
+
+	resp, err := http.Get(fmt.Sprintf("http://localhost:%d/history?%s", controlApi.Port, values.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("%v, and the control api is not reachable: %v", dbErr, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiResponse ApiResponse
+		json.NewDecoder(resp.Body).Decode(&apiResponse)
+
+		return nil, fmt.Errorf("control api: %s", apiResponse.Message)
+	}
+
+	records := []HistoryRecord{}
+
+	err = json.NewDecoder(resp.Body).Decode(&records)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding control api response: %v", err)
+	}
+
+	return records, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Bump when fields of JsonNotificationPayload are renamed or removed. Adding fields keeps the version
+const JSON_NOTIFICATION_SCHEMA_VERSION = 1
+
+// Payload of the generic json webhook and the file sink: the event itself plus the schema version
+type JsonNotificationPayload struct {
+	SchemaVersion int `json:"schemaVersion"`
+	*Event
+}
+
+func newJsonNotificationPayload(event *Event) JsonNotificationPayload {
+	return JsonNotificationPayload{
+		SchemaVersion: JSON_NOTIFICATION_SCHEMA_VERSION,
+		Event:         event,
+	}
+}
+
+type JsonNotifier struct {
+	urls []string
+}
+
+// Take configMu RLock before calling NewJsonNotifier!
+func NewJsonNotifier() *JsonNotifier {
+	return &JsonNotifier{
+		urls: config.Notifiers.Json.Urls,
+	}
+}
+
+func (n *JsonNotifier) Name() string {
+	return SINK_JSON
+}
+
+func (n *JsonNotifier) Notify(event *Event) error {
+	var payload any = newJsonNotificationPayload(event)
+
+	// A template replaces the payload, it has to render valid json
+	if rendered, ok := notificationTemplates.Render(SINK_JSON, event); ok {
+		if !json.Valid([]byte(rendered)) {
+			return fmt.Errorf("json template for %s did not render valid json", event.Kind)
+		}
+
+		payload = json.RawMessage(rendered)
+	}
+
+	deliveryErr := &DeliveryError{}
+	for _, url := range n.urls {
+		err := postJson(url, payload)
+		if err != nil {
+			deliveryErr.add(url, err)
+		}
+	}
+
+	return deliveryErr.orNil()
+}
+
+var fileNotifierMu sync.Mutex = sync.Mutex{}
+
+// Appends one json payload per line to a file, or writes it to stdout if no path is set
+type FileNotifier struct {
+	path string
+}
+
+// Take configMu RLock before calling NewFileNotifier!
+func NewFileNotifier() *FileNotifier {
+	return &FileNotifier{
+		path: config.Notifiers.File.Path,
+	}
+}
+
+func (n *FileNotifier) Name() string {
+	return SINK_FILE
+}
+
+func (n *FileNotifier) Notify(event *Event) error {
+	var bytes []byte
+	var err error
+
+	if rendered, ok := notificationTemplates.Render(SINK_FILE, event); ok {
+		bytes = []byte(strings.TrimSuffix(rendered, "\n"))
+	} else {
+		bytes, err = json.Marshal(newJsonNotificationPayload(event))
+		if err != nil {
+			return fmt.Errorf("error marshalling payload: %v", err)
+		}
+	}
+	bytes = append(bytes, '\n')
+
+	fileNotifierMu.Lock()
+	defer fileNotifierMu.Unlock()
+
+	if n.path == "" {
+		_, err = os.Stdout.Write(bytes)
+		return err
+	}
+
+	file, err := os.OpenFile(n.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening \"%s\": %v", n.path, err)
+	}
+	defer file.Close()
+
+	_, err = file.Write(bytes)
+	if err != nil {
+		return fmt.Errorf("error writing \"%s\": %v", n.path, err)
+	}
+
+	return nil
+}
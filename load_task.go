@@ -16,7 +16,7 @@ func NewLoadTask(taskName string, group *LoadTaskGroup) (*LoadTask, error) {
 	}
 
 	loadTask := &LoadTask{
-		SnsTask: &SnsTask{},
+		SnsTask: &SnsTask{backend: group.backend, region: group.region, productsKind: CAPTURE_KIND_LOAD_CHECK},
 		group:   group,
 	}
 
@@ -37,21 +37,70 @@ func NewLoadTask(taskName string, group *LoadTaskGroup) (*LoadTask, error) {
 
 func (t *LoadTask) loopMonitor() {
 	configMu.RLock()
-	defer time.Sleep(time.Millisecond * time.Duration(config.LoadTask.Timeout))
+	defer t.sleep(time.Millisecond * time.Duration(config.LoadTask.Timeout))
 	configMu.RUnlock()
 
 	if checkExceededTimeCheckSystemTime() {
 		return
 	}
 
-	res, err := t.getNewArrivals()
+	newArrivals, err := t.getNewArrivalsPages()
 	if err != nil {
 		t.logger.Red(err)
 		return
 	}
 
-	go t.group.handleNewArrivalsResponse(res)
+	runInFlight(func() {
+		t.group.handleNewArrivals(newArrivals)
+	})
 
 	t.rotateProxy()
 
 }
+
+// Requests further pages until the last known product is included, so bursts of uploads between two polls are not missed
+func (t *LoadTask) getNewArrivalsPages() ([]NewArrival, error) {
+	configMu.RLock()
+	rows := config.LoadTask.NewArrivals.getRows()
+	maxPages := config.LoadTask.NewArrivals.getMaxPages()
+	configMu.RUnlock()
+
+	newArrivals, err := t.getNewArrivals(0)
+	if err != nil {
+		return nil, err
+	}
+
+	lastKnownPid := t.group.getLastKnownPid()
+	if lastKnownPid == "" {
+		return newArrivals, nil
+	}
+
+	pageNewArrivals := newArrivals
+	for page := 1; !containsPid(pageNewArrivals, lastKnownPid); page++ {
+		if len(pageNewArrivals) < rows {
+			break
+		}
+		if page == maxPages {
+			t.logger.Yellow(fmt.Sprintf("Last known product not within %d pages of new arrivals", maxPages))
+			break
+		}
+
+		pageNewArrivals, err = t.getNewArrivals(page)
+		if err != nil {
+			return nil, err
+		}
+
+		newArrivals = append(newArrivals, pageNewArrivals...)
+	}
+
+	return newArrivals, nil
+}
+
+func containsPid(newArrivals []NewArrival, pid string) bool {
+	for _, newArrival := range newArrivals {
+		if newArrival.Pid == pid {
+			return true
+		}
+	}
+	return false
+}
@@ -35,7 +35,7 @@ type LoadTaskGroup struct {
 	kwdQueries      []KwdQuery
 }
 
-func NewLoadTaskGroup(proxyHandler *ProxyHandler, webhookHandler *WebhookHandler, lastKnownPid string, kwdQueryStrings []string) (*LoadTaskGroup, error) {
+func NewLoadTaskGroup(region string, states *RegionProductStates, backend StoreBackend, proxyHandler *ProxyHandler, webhookHandler *WebhookHandler, lastKnownPid string, kwdQueryStrings []string) (*LoadTaskGroup, error) {
 	kwdQueries := []KwdQuery{}
 	for _, queryStr := range kwdQueryStrings {
 		queryStr = strings.ToLower(queryStr)
@@ -51,7 +51,7 @@ func NewLoadTaskGroup(proxyHandler *ProxyHandler, webhookHandler *WebhookHandler
 		kwdQueries:   kwdQueries,
 	}
 
-	baseTaskGroup, err := NewBaseTaskGroup("LOAD", proxyHandler, webhookHandler)
+	baseTaskGroup, err := NewBaseTaskGroup(TASK_TYPE_LOAD, region, states, backend, proxyHandler, webhookHandler)
 	if err != nil {
 		return nil, fmt.Errorf("error creating base task group: %v", err)
 	}
@@ -74,33 +74,116 @@ func (g *LoadTaskGroup) LinkToNormalTaskGroup(normalTaskGroup *NormalTaskGroup)
 	return nil
 }
 
-func (g *LoadTaskGroup) AddKwdQuery(kwdStr string) {
-	g.mu.Lock()
-	defer g.mu.Unlock()
-
-	kwdStr = strings.TrimSpace(strings.ToLower(kwdStr))
+// Subscribed to config changes in createRegion
+func (g *LoadTaskGroup) onConfigChange(diff *ConfigDiff) {
+	if !diff.LoadNumTasks {
+		return
+	}
 
-	query := MakeKeywordQuery(kwdStr)
+	err := g.reconcileTasks(diff.New.LoadTask.NumTasks, func(taskName string) (*BaseTask, error) {
+		loadTask, err := NewLoadTask(taskName, g)
+		if err != nil {
+			return nil, err
+		}
+		return loadTask.BaseTask, nil
+	})
+	if err != nil {
+		g.logger.Red(fmt.Sprintf("Error applying numTasks %d: %v", diff.New.LoadTask.NumTasks, err))
+	}
+}
 
-	g.kwdQueries = append(g.kwdQueries, query)
+func (g *LoadTaskGroup) AddKwdQuery(kwdStr string, metadata *WatchMetadata) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
 
 	statesLoadMu.Lock()
-	LoadAddKwd(query.rawQueryStr)
+	g.addKwdQuery(normalizeKwdStr(kwdStr), metadata)
 	statesLoadMu.Unlock()
 
-	go writeProductStates()
+	markProductStatesDirty()
 }
 
 func (g *LoadTaskGroup) RemoveKwdQuery(kwdStr string) {
 	g.mu.Lock()
 	defer g.mu.Unlock()
 
-	kwdStr = strings.TrimSpace(strings.ToLower(kwdStr))
+	statesLoadMu.Lock()
+	g.removeKwdQuery(normalizeKwdStr(kwdStr))
+	statesLoadMu.Unlock()
 
-	for strings.Contains(kwdStr, "  ") {
-		kwdStr = strings.Replace(kwdStr, "  ", " ", -1)
+	markProductStatesDirty()
+}
+
+// Adds the keyword queries of a validated batch. Queries must already carry their +/- prefix.
+// Take g.mu and statesLoadMu Lock before calling addKwdQueries! The caller is responsible for persisting the product states afterwards
+func (g *LoadTaskGroup) addKwdQueries(entries []WatchEntry) []BulkItemResult {
+	results := []BulkItemResult{}
+	for _, entry := range entries {
+		kwdStr := normalizeKwdStr(entry.Query)
+
+		result := BulkItemResult{
+			Region:    g.region,
+			InputType: "KWD_QUERY",
+			Query:     kwdStr,
+		}
+
+		if g.states.LoadGetIndexKwd(kwdStr) >= 0 {
+			result.Status = BULK_STATUS_ALREADY_MONITORED
+		} else {
+			g.addKwdQuery(kwdStr, entry.Metadata)
+
+			result.Status = BULK_STATUS_ADDED
+		}
+
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// Counterpart to addKwdQueries. Take g.mu and statesLoadMu Lock before calling removeKwdQueries!
+func (g *LoadTaskGroup) removeKwdQueries(kwdStrs []string) []BulkItemResult {
+	results := []BulkItemResult{}
+	for _, kwdStr := range kwdStrs {
+		kwdStr = normalizeKwdStr(kwdStr)
+
+		result := BulkItemResult{
+			Region:    g.region,
+			InputType: "KWD_QUERY",
+			Query:     kwdStr,
+		}
+
+		if kwdStr == "" {
+			result.Status = BULK_STATUS_INVALID
+		} else if g.states.LoadGetIndexKwd(kwdStr) == -1 {
+			result.Status = BULK_STATUS_NOT_FOUND
+		} else {
+			g.removeKwdQuery(kwdStr)
+
+			result.Status = BULK_STATUS_REMOVED
+		}
+
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// Take g.mu and statesLoadMu Lock before calling addKwdQuery!
+func (g *LoadTaskGroup) addKwdQuery(kwdStr string, metadata *WatchMetadata) {
+	query := MakeKeywordQuery(kwdStr)
+
+	g.kwdQueries = append(g.kwdQueries, query)
+
+	g.states.LoadAddKwd(query.rawQueryStr)
+
+	if metadata != nil {
+		g.states.LoadSetKwdMetadata(query.rawQueryStr, metadata)
 	}
+}
 
+// Take g.mu and statesLoadMu Lock before calling removeKwdQuery!
+func (g *LoadTaskGroup) removeKwdQuery(kwdStr string) {
 	removeIndex := -1
 	for i, query := range g.kwdQueries {
 		if query.rawQueryStr == kwdStr {
@@ -113,15 +196,28 @@ func (g *LoadTaskGroup) RemoveKwdQuery(kwdStr string) {
 		g.kwdQueries = append(g.kwdQueries[:removeIndex], g.kwdQueries[removeIndex+1:]...)
 	}
 
-	statesLoadMu.Lock()
-	LoadRemoveKwd(kwdStr)
-	statesLoadMu.Unlock()
+	g.states.LoadRemoveKwd(kwdStr)
+}
+
+func normalizeKwdStr(kwdStr string) string {
+	kwdStr = strings.TrimSpace(strings.ToLower(kwdStr))
+
+	for strings.Contains(kwdStr, "  ") {
+		kwdStr = strings.Replace(kwdStr, "  ", " ", -1)
+	}
+
+	return kwdStr
+}
+
+func (g *LoadTaskGroup) getLastKnownPid() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
 
-	go writeProductStates()
+	return g.lastKnownPid
 }
 
-func (g *LoadTaskGroup) handleNewArrivalsResponse(res *NewArrivalsResponse) {
-	if g.normalTaskGroup == nil || res == nil || len(res.Response.ProductNodes) == 0 {
+func (g *LoadTaskGroup) handleNewArrivals(newArrivals []NewArrival) {
+	if g.normalTaskGroup == nil || len(newArrivals) == 0 {
 		return
 	}
 
@@ -130,35 +226,44 @@ func (g *LoadTaskGroup) handleNewArrivalsResponse(res *NewArrivalsResponse) {
 
 	newSKUs := []SkuQuery{}
 
-	for _, productNode := range res.Response.ProductNodes {
-		if productNode.Pid == g.lastKnownPid {
+	for _, newArrival := range newArrivals {
+		if newArrival.Pid == g.lastKnownPid {
 			break
 		}
 
-		newSKUs = append(newSKUs, MakeSkuQuery(productNode.Sku))
+		newSKUs = append(newSKUs, MakeSkuQuery(newArrival.Sku))
 	}
 
 	if numNewSkus := len(newSKUs); numNewSkus > 0 {
 		g.logger.Yellow(fmt.Sprintf("%d new products loaded.", numNewSkus))
 
+		// Load checks are replayed from their own captures
+		if replayMode {
+			newSKUs = []SkuQuery{}
+		}
+
 		for len(newSKUs) > SKUS_BATCH_SIZE {
 			nextSKUs := newSKUs[:SKUS_BATCH_SIZE]
 
-			go g.loadCheckSkus(nextSKUs)
+			runInFlight(func() {
+				g.loadCheckSkus(nextSKUs)
+			})
 
 			newSKUs = newSKUs[SKUS_BATCH_SIZE:]
 		}
 		if len(newSKUs) > 0 {
-			go g.loadCheckSkus(newSKUs)
+			runInFlight(func() {
+				g.loadCheckSkus(newSKUs)
+			})
 		}
 
-		g.lastKnownPid = res.Response.ProductNodes[0].Pid
+		g.lastKnownPid = newArrivals[0].Pid
 
 		statesLoadMu.Lock()
-		LoadSetLastKnownPid(g.lastKnownPid)
+		g.states.LoadSetLastKnownPid(g.lastKnownPid)
 		statesLoadMu.Unlock()
 
-		go writeProductStates()
+		markProductStatesDirty()
 	} else {
 		g.logger.Grey("No new products loaded")
 	}
@@ -173,6 +278,18 @@ func (g *LoadTaskGroup) loadCheckSkus(skus []SkuQuery) {
 		return
 	}
 
+	// Stopping the group interrupts the retries, so a shutdown does not wait for them
+	checkDoneCh := make(chan struct{})
+	defer close(checkDoneCh)
+
+	go func() {
+		select {
+		case <-g.stopCh:
+			loadTask.stop()
+		case <-checkDoneCh:
+		}
+	}()
+
 	includedSkuQueries := make(map[SkuQuery]bool)
 
 	configMu.RLock()
@@ -191,37 +308,23 @@ func (g *LoadTaskGroup) loadCheckSkus(skus []SkuQuery) {
 
 		g.logger.Yellow(fmt.Sprintf("Requesting new products: %d (%d/%d)", len(skusStr), i+1, LOAD_CHECK_RETRIES))
 
-		res, err := loadTask.getProductsBySku(skusStr)
+		products, err := loadTask.getProductsBySku(skusStr)
 		if err != nil {
 			g.logger.Red(err)
 			return
 		}
 
-		if res == nil || res.Data.Site.Search.SearchProducts.Products.Edges == nil {
+		if products == nil {
 			continue
 		}
 
-		loadProductData := []ProductData{}
-
-		for _, productEdge := range res.Data.Site.Search.SearchProducts.Products.Edges {
-			if productEdge.Node.Variants == nil {
-				g.logger.Red(fmt.Sprintf("%s: Variants property nil. Skipping product edge...", productEdge.Node.Sku))
-				continue
-			}
-			if productEdge.Node.Variants.Edges == nil {
-				g.logger.Red(fmt.Sprintf("%s: Variants.Edges property nil. Skipping product edge...", productEdge.Node.Sku))
-				continue
-			}
-
-			pSkuQuery := MakeSkuQuery(productEdge.Node.Sku)
-			includedSkuQueries[pSkuQuery] = true
-
-			productData := GetProductData(productEdge.Node)
-
-			loadProductData = append(loadProductData, productData)
+		for _, product := range products {
+			includedSkuQueries[MakeSkuQuery(product.Sku)] = true
 		}
 
-		go g.handleSkuCheckResponse(loadProductData)
+		runInFlight(func() {
+			g.handleSkuCheckResponse(products)
+		})
 
 		uncheckedSkus := []SkuQuery{}
 		for _, sku := range skus {
@@ -232,17 +335,20 @@ func (g *LoadTaskGroup) loadCheckSkus(skus []SkuQuery) {
 
 		skus = uncheckedSkus
 
-		time.Sleep(time.Millisecond * time.Duration(timeout))
+		if !loadTask.sleep(time.Millisecond * time.Duration(timeout)) {
+			g.logger.Grey(fmt.Sprintf("Load check of %d products stopped", len(skus)))
+			return
+		}
 	}
 }
 
 func (g *LoadTaskGroup) handleSkuCheckResponse(productData []ProductData) {
 	g.mu.Lock()
-	defer g.mu.Unlock()
 
 	g.logger.Yellow(fmt.Sprintf("Checking %d new products", len(productData)))
 
 	syncRequired := false
+	events := []*Event{}
 
 	for _, product := range productData {
 		matchingKwdQueries := g.keywordQueriesMatchingProduct(product)
@@ -260,7 +366,7 @@ func (g *LoadTaskGroup) handleSkuCheckResponse(productData []ProductData) {
 		statesLoadMu.Lock()
 
 		alreadyNotified := false
-		for _, notifiedState := range productStates.Load.NotifiedProducts {
+		for _, notifiedState := range g.states.Load.NotifiedProducts {
 			if notifiedState.Sku == product.Sku {
 				alreadyNotified = true
 				break
@@ -275,7 +381,18 @@ func (g *LoadTaskGroup) handleSkuCheckResponse(productData []ProductData) {
 
 		// Notify
 		g.logger.Green(fmt.Sprintf("%s loaded. Matching keywords: %v", product.Sku, matchingKwdQueries))
-		g.notifyLoad(product, matchingKwdQueries)
+		events = append(events, g.newLoadEvent(product, matchingKwdQueries))
+
+		historyStore.Append(&HistoryRecord{
+			Time:             time.Now(),
+			Type:             HISTORY_LOAD,
+			Region:           g.region,
+			Sku:              product.Sku,
+			AvailableForSale: product.AvailableForSale,
+			AvailableSizes:   product.AvailableSizes,
+			Price:            product.Price,
+			MatchedQueries:   matchingKwdQueries,
+		})
 
 		stateChanged := g.matchProductStates(product.Sku, matchingKwdQueries)
 		if stateChanged {
@@ -283,8 +400,15 @@ func (g *LoadTaskGroup) handleSkuCheckResponse(productData []ProductData) {
 		}
 	}
 
+	g.mu.Unlock()
+
+	// Emitted without the group lock, the cooldown and the sinks must not hold up the tasks
+	for _, event := range events {
+		webhookHandler.Emit(event)
+	}
+
 	if syncRequired {
-		go writeProductStates()
+		markProductStatesDirty()
 	}
 }
 
@@ -295,7 +419,7 @@ func (g *LoadTaskGroup) matchProductStates(sku string, matchingKeywordQueries []
 	stateChanged := false
 	included := false
 
-	for _, query := range productStates.Load.NotifiedProducts {
+	for _, query := range g.states.Load.NotifiedProducts {
 		if query.Sku == sku {
 			included = true
 
@@ -315,7 +439,7 @@ func (g *LoadTaskGroup) matchProductStates(sku string, matchingKeywordQueries []
 			MatchingKeywordQueries: matchingKeywordQueries,
 		}
 
-		productStates.Load.NotifiedProducts = append(productStates.Load.NotifiedProducts, newNotifiedProduct)
+		g.states.Load.NotifiedProducts = append(g.states.Load.NotifiedProducts, newNotifiedProduct)
 	}
 
 	return stateChanged
@@ -373,8 +497,43 @@ func (g *LoadTaskGroup) keywordQueriesMatchingProduct(product ProductData) []str
 	return matchingQueries
 }
 
-func (g *LoadTaskGroup) notifyLoad(productData ProductData, matchingKwdQueries []string) {
-	webhookHandler.NotifyLoad(productData, matchingKwdQueries)
+func (g *LoadTaskGroup) newLoadEvent(productData ProductData, matchingKwdQueries []string) *Event {
+	return &Event{
+		Kind:           EVENT_LOAD,
+		Region:         g.region,
+		Time:           time.Now(),
+		Product:        productData,
+		MatchedQueries: matchingKwdQueries,
+		WebhookUrls:    g.webhookUrlsForQueries(matchingKwdQueries),
+	}
+}
+
+// Collects the webhook urls of all matching queries. Queries without an override contribute the region webhook urls
+func (g *LoadTaskGroup) webhookUrlsForQueries(kwdQueries []string) []string {
+	configMu.RLock()
+	defer configMu.RUnlock()
+
+	statesLoadMu.Lock()
+	defer statesLoadMu.Unlock()
+
+	webhookUrls := []string{}
+	included := make(map[string]bool)
+
+	for _, kwdQuery := range kwdQueries {
+		queryWebhookUrls := metadataWebhookUrls(g.states.LoadGetKwdMetadata(kwdQuery))
+		if queryWebhookUrls == nil {
+			queryWebhookUrls = loadWebhookUrls(g.region)
+		}
+
+		for _, webhookUrl := range queryWebhookUrls {
+			if !included[webhookUrl] {
+				webhookUrls = append(webhookUrls, webhookUrl)
+				included[webhookUrl] = true
+			}
+		}
+	}
+
+	return webhookUrls
 }
 
 func MakeSkuQuery(skuStr string) SkuQuery {
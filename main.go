@@ -3,26 +3,27 @@ package main
 import (
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
-	"unsafe"
 )
 
 const (
 	VERSION = "0.3.7"
 )
 
-// Lock order as below. Before that, take the individual handler lock
+// Lock order as below. Before that, take the individual handler lock (normal task group before load task group)
 var configMu sync.RWMutex = sync.RWMutex{}
 var statesNormalMu sync.Mutex = sync.Mutex{}
 var statesLoadMu sync.Mutex = sync.Mutex{}
 var proxyfileMu sync.Mutex = sync.Mutex{}
-var productStateFileMu sync.Mutex = sync.Mutex{}
 
 var tasksWg sync.WaitGroup = sync.WaitGroup{}
 
@@ -36,12 +37,18 @@ var config *Config = nil
 var proxyHandler *ProxyHandler = nil
 var webhookHandler *WebhookHandler = nil
 
-var normalTaskGroup *NormalTaskGroup = nil
-var loadTaskGroup *LoadTaskGroup = nil
-
 var fileLoggingEnabled bool = true
 
 func main() {
+	if len(os.Args) > 1 {
+		err := runCommand(os.Args[1], os.Args[2:])
+		if err != nil {
+			log.Printf("%s: %v", os.Args[1], err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	exceeded, err := checkExceededTimeCheckFetch()
 	if err != nil {
 		log.Printf("Error checking application expiration on start: %v", err)
@@ -53,22 +60,32 @@ func main() {
 
 	runTimeBomb()
 
-	err = checkLogfolder()
+	os.Exit(run())
+}
+
+// Returns the exit code. Deferred cleanups have run once it returns
+func run() int {
+	err := checkLogfolder()
 	if err != nil {
 		log.Printf("Init: %v", err)
-		return
+		return EXIT_INIT_ERROR
 	}
 	err = checkProxyfolder()
 	if err != nil {
 		log.Printf("Init: %v", err)
-		return
+		return EXIT_INIT_ERROR
+	}
+	err = checkTemplatesfolder()
+	if err != nil {
+		log.Printf("Init: %v", err)
+		return EXIT_INIT_ERROR
 	}
 
 	// Logfile setup
 	logfile, err := initLogfile()
 	if err != nil {
 		log.Printf("Init: Error setting up logfile: %v\n", err)
-		return
+		return EXIT_INIT_ERROR
 	}
 	defer logfile.Close()
 
@@ -76,34 +93,65 @@ func main() {
 	fileLogger = log.New(logfile, "", log.LstdFlags|log.Lshortfile)
 	if fileLogger == nil {
 		log.Println("Init: Error creating file logger")
-		return
+		return EXIT_INIT_ERROR
 	}
 
 	// Load config
 	err = readConfig()
 	if err != nil {
 		mainLogger.Red(fmt.Sprintf("Init: %v", err))
-		return
+		return EXIT_INIT_ERROR
 	}
-	refreshConfig()
+	// Reloaded by the config watcher afterwards
+	notificationTemplates.Reload()
 
 	initTerminal()
 
-	tasksWg.Add(1)
-	go handleWebsocketClientConnection()
+	configMu.RLock()
+	websocketPort := config.WebsocketPort
+	websocketSecret := config.WebsocketSecret
+	configMu.RUnlock()
+
+	// The websocket control channel is optional when the control API is used instead
+	if websocketPort != 0 {
+		if websocketSecret == "" {
+			mainLogger.Yellow("No websocketSecret set, control messages are accepted without authentication")
+		}
+
+		go handleWebsocketClientConnection()
+	}
 
 	// Load product states
-	productStates, err = readProductStates()
+	stateStore, productStates, err = openProductStates()
 	if err != nil {
 		mainLogger.Red(fmt.Sprintf("Init: %v", err))
-		return
-	}
-	if productStates == nil {
-		productStates = &ProductStates{}
+		return EXIT_INIT_ERROR
 	}
+	defer stateStore.Close()
 
 	formatProductStates()
 
+	// Known good states to fall back to
+	if _, err := backupProductStates(); err != nil {
+		mainLogger.Red(fmt.Sprintf("Init: Error backing up product states: %v", err))
+	}
+
+	statePersister = NewStatePersister()
+	statePersister.Start()
+	defer statePersister.Stop()
+
+	configMu.RLock()
+	captureResponses := config.CaptureResponses
+	configMu.RUnlock()
+
+	if captureResponses {
+		captureRecorder, err = NewCaptureRecorder()
+		if err != nil {
+			mainLogger.Red(fmt.Sprintf("Init: %v", err))
+			return EXIT_INIT_ERROR
+		}
+	}
+
 	configMu.RLock()
 
 	// Check file logging
@@ -118,7 +166,7 @@ func main() {
 		configMu.RUnlock()
 
 		mainLogger.Red(fmt.Sprintf("Init: %v", err))
-		return
+		return EXIT_INIT_ERROR
 	}
 	configMu.RUnlock()
 
@@ -126,6 +174,32 @@ func main() {
 	proxyHandler = NewProxyHandler(proxies)
 	webhookHandler = NewWebhookHandler()
 
+	subscribeConfig(proxyHandler.onConfigChange)
+
+	notificationOutbox, err = OpenOutbox(pathOutbox)
+	if err != nil {
+		mainLogger.Red(fmt.Sprintf("Init: %v", err))
+		return EXIT_INIT_ERROR
+	}
+	// Kept open if the shutdown did not drain, requests and notifications still running would write to it
+	drained := true
+	defer func() {
+		if drained {
+			notificationOutbox.Close()
+		}
+	}()
+
+	historyStore, err = OpenHistoryStore(pathHistoryDb)
+	if err != nil {
+		mainLogger.Red(fmt.Sprintf("Init: %v", err))
+		return EXIT_INIT_ERROR
+	}
+	defer func() {
+		if drained {
+			historyStore.Close()
+		}
+	}()
+
 	configMu.RLock()
 
 	mainLogger.White(fmt.Sprintf("Starting SNS monitor (v%s) ...", VERSION))
@@ -134,86 +208,78 @@ func main() {
 	statesNormalMu.Lock()
 	statesLoadMu.Lock()
 
-	normalSkus := NormalGetAllSkus()
+	for _, regionConfig := range getRegionConfigs() {
+		region, err := createRegion(regionConfig)
+		if err != nil {
+			statesLoadMu.Unlock()
+			statesNormalMu.Unlock()
+			configMu.RUnlock()
 
-	normalTaskGroup, err = NewNormalTaskGroup(proxyHandler, webhookHandler, normalSkus)
-	if err != nil {
-		mainLogger.Red(fmt.Sprintf("Error creating normal task group: %v", err))
-		return
-	}
+			mainLogger.Red(fmt.Sprintf("Error creating region %s: %v", regionConfig.Name, err))
+			return EXIT_INIT_ERROR
+		}
 
-	loadTaskGroup, err = NewLoadTaskGroup(proxyHandler, webhookHandler, productStates.Load.LastKnownPid, productStates.Load.KeywordQueries)
-	if err != nil {
-		mainLogger.Red(fmt.Sprintf("Error creating normal task group: %v", err))
-		return
+		regions = append(regions, region)
 	}
 
-	// Associate task groups with one another
-	normalTaskGroup.LinkToLoadTaskGroup(loadTaskGroup)
-	loadTaskGroup.LinkToNormalTaskGroup(normalTaskGroup)
+	statesNormalMu.Unlock()
+	statesLoadMu.Unlock()
 
-	// Create normal tasks
-	for i := range config.NormalTask.NumTasks {
-		tasksWg.Add(1)
+	// Start webhook handler
+	webhookHandler.Start()
 
-		taskName := fmt.Sprintf("NORMAL: %02d", i)
-		normalTask, err := NewNormalTask(taskName, normalTaskGroup)
+	// Launch tasks
+	for _, region := range regions {
+		err = region.normalTaskGroup.StartAllTasks()
 		if err != nil {
-			mainLogger.Red(fmt.Sprintf("Error creating initial normal task %s: %v", taskName, err))
-			return
-		}
+			configMu.RUnlock()
 
-		err = normalTaskGroup.AddTask(normalTask.BaseTask)
-		if err != nil {
-			mainLogger.Red(fmt.Sprintf("Error adding normal task %s to task group: %v", taskName, err))
-			return
+			mainLogger.Red(fmt.Sprintf("Error starting normal tasks (%s): %v", region.name, err))
+			return EXIT_INIT_ERROR
 		}
-	}
-
-	// Create load tasks
-	for i := range config.LoadTask.NumTasks {
-		tasksWg.Add(1)
 
-		taskName := fmt.Sprintf("LOAD: %02d", i)
-		loadTask, err := NewLoadTask(taskName, loadTaskGroup)
+		err = region.loadTaskGroup.StartAllTasks()
 		if err != nil {
-			mainLogger.Red(fmt.Sprintf("Error creating initial load task %s: %v", taskName, err))
-			return
-		}
+			configMu.RUnlock()
 
-		err = loadTaskGroup.AddTask(loadTask.BaseTask)
-		if err != nil {
-			mainLogger.Red(fmt.Sprintf("Error adding load task %s to task group: %v", taskName, err))
-			return
+			mainLogger.Red(fmt.Sprintf("Error starting load tasks (%s): %v", region.name, err))
+			return EXIT_INIT_ERROR
 		}
 	}
 
-	statesNormalMu.Unlock()
-	statesLoadMu.Unlock()
+	var controlApiServer *http.Server = nil
+	if config.ControlApi.Enabled {
+		controlApiServer = startControlApi(config.ControlApi.Port)
+	}
 
-	// Start webhook handler
-	webhookHandler.Start()
+	configMu.RUnlock()
 
-	// Launch tasks
-	err = normalTaskGroup.StartAllTasks()
+	// Subscribers exist and the task groups are running now
+	stopConfigWatcher, err := watchConfig()
 	if err != nil {
-		mainLogger.Red(fmt.Sprintf("Error starting normal tasks: %v", err))
-		return
+		mainLogger.Red(fmt.Sprintf("Config and template hot reload disabled: %v", err))
 	}
-	defer normalTaskGroup.StopAllTasks()
 
-	err = loadTaskGroup.StartAllTasks()
-	if err != nil {
-		mainLogger.Red(fmt.Sprintf("Error starting load tasks: %v", err))
-		return
-	}
-	defer loadTaskGroup.StopAllTasks()
+	webhookHandler.ReplayOutbox()
 
-	configMu.RUnlock()
+	signalCh := make(chan os.Signal, 2)
+	signal.Notify(signalCh, os.Interrupt, syscall.SIGTERM)
+
+	sig := <-signalCh
+	mainLogger.Yellow(fmt.Sprintf("Received %s, shutting down ...", sig))
+
+	go func() {
+		<-signalCh
+		mainLogger.Red("Received a second signal, exiting without finishing the shutdown")
+		os.Exit(EXIT_SHUTDOWN_FORCED)
+	}()
 
-	tasksWg.Wait()
+	exitCode := shutdown(controlApiServer, websocketPort != 0, stopConfigWatcher)
+	drained = exitCode == EXIT_OK
 
-	webhookHandler.Stop()
+	mainLogger.White(fmt.Sprintf("Stopped (exit code %d)", exitCode))
+
+	return exitCode
 }
 
 func initTerminal() {
@@ -242,35 +308,39 @@ func initLogfile() (*os.File, error) {
 	return logfile, nil
 }
 
-func enableVirtualTerminalProcessing() {
-	kernel32 := syscall.NewLazyDLL("kernel32.dll")
-	setConsoleMode := kernel32.NewProc("SetConsoleMode")
-	getConsoleMode := kernel32.NewProc("GetConsoleMode")
-
-	var mode uint32
-	handle := syscall.Handle(os.Stdout.Fd())
-	getConsoleMode.Call(uintptr(handle), uintptr(unsafe.Pointer(&mode)))
-	mode |= 0x0004
-	setConsoleMode.Call(uintptr(handle), uintptr(mode))
-}
-
 func formatProductStates() {
 	statesNormalMu.Lock()
 	defer statesNormalMu.Unlock()
 	statesLoadMu.Lock()
 	defer statesLoadMu.Unlock()
 
-	for i, state := range productStates.Normal.ProductStates {
-		productStates.Normal.ProductStates[i].Sku = strings.ToUpper(strings.TrimSpace(state.Sku))
-	}
+	for _, regionStates := range productStates.Regions {
+		for i, state := range regionStates.Normal.ProductStates {
+			regionStates.Normal.ProductStates[i].Sku = strings.ToUpper(strings.TrimSpace(state.Sku))
+		}
 
-	productStates.Load.LastKnownPid = strings.ToUpper(strings.TrimSpace(productStates.Load.LastKnownPid))
+		regionStates.Load.LastKnownPid = strings.ToUpper(strings.TrimSpace(regionStates.Load.LastKnownPid))
 
-	for i, query := range productStates.Load.KeywordQueries {
-		productStates.Load.KeywordQueries[i] = strings.ToLower(strings.TrimSpace(query))
-	}
+		// Metadata moves to the formatted query, queries differing only in case are merged into the first one
+		kwdQueries := []string{}
+		kwdMetadata := make(map[string]*WatchMetadata)
+		for _, query := range regionStates.Load.KeywordQueries {
+			kwdStr := strings.ToLower(strings.TrimSpace(query))
 
-	for i, notified := range productStates.Load.NotifiedProducts {
-		productStates.Load.NotifiedProducts[i].Sku = strings.ToUpper(strings.TrimSpace(notified.Sku))
+			if !slices.Contains(kwdQueries, kwdStr) {
+				kwdQueries = append(kwdQueries, kwdStr)
+			}
+			if metadata, ok := regionStates.Load.KeywordMetadata[query]; ok && kwdMetadata[kwdStr] == nil {
+				kwdMetadata[kwdStr] = metadata
+			}
+		}
+		regionStates.Load.KeywordQueries = kwdQueries
+		if regionStates.Load.KeywordMetadata != nil {
+			regionStates.Load.KeywordMetadata = kwdMetadata
+		}
+
+		for i, notified := range regionStates.Load.NotifiedProducts {
+			regionStates.Load.NotifiedProducts[i].Sku = strings.ToUpper(strings.TrimSpace(notified.Sku))
+		}
 	}
 }
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// Tests log to the console only
+	fileLoggingEnabled = false
+
+	os.Exit(m.Run())
+}
+
+func TestFormatProductStatesKeywordMetadata(t *testing.T) {
+	oldProductStates := productStates
+	t.Cleanup(func() {
+		productStates = oldProductStates
+	})
+
+	regionStates := &RegionProductStates{}
+	regionStates.Load.KeywordQueries = []string{" +Dunk Low", "+dunk low", "-Kids"}
+	regionStates.Load.KeywordMetadata = map[string]*WatchMetadata{
+		" +Dunk Low": {Note: "mixed case"},
+		"+dunk low":  {Note: "formatted"},
+	}
+	productStates = &ProductStates{Regions: map[string]*RegionProductStates{"EU": regionStates}}
+
+	formatProductStates()
+
+	if queries := regionStates.Load.KeywordQueries; !slices.Equal(queries, []string{"+dunk low", "-kids"}) {
+		t.Errorf("got keyword queries %v, want the formatted queries merged", queries)
+	}
+
+	metadata := regionStates.LoadGetKwdMetadata("+dunk low")
+	if metadata == nil || metadata.Note != "mixed case" {
+		t.Errorf("got metadata %+v for +dunk low, want the metadata of the first query", metadata)
+	}
+	if len(regionStates.Load.KeywordMetadata) != 1 {
+		t.Errorf("got metadata keys %v, want only +dunk low", regionStates.Load.KeywordMetadata)
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	pathMockFolder      string = "./mock"
+	pathMockScript      string = "./mock/script.json"
+	pathMockWebhookSink string = "./mock/webhooks.jsonl"
+
+	DEFAULT_MOCK_PORT = 8090
+)
+
+var mockLogger *Logger = NewLogger("MOCK")
+
+// mock/script.json
+type MockScript struct {
+	NewArrivals   []MockStep `json:"newArrivals"`
+	ProductsBySku []MockStep `json:"productsBySku"`
+	Webhook       []MockStep `json:"webhook"`
+}
+
+// Each step is served Repeat times (at least once). The last step of an endpoint is repeated forever
+type MockStep struct {
+	File   string `json:"file"`
+	Status int    `json:"status"`
+	Repeat int    `json:"repeat"`
+}
+
+type mockEndpoint struct {
+	mu     sync.Mutex
+	steps  []MockStep
+	pos    int
+	served int
+}
+
+func (e *mockEndpoint) reset(steps []MockStep) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.steps = steps
+	e.pos = 0
+	e.served = 0
+}
+
+func (e *mockEndpoint) next() (MockStep, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if len(e.steps) == 0 {
+		return MockStep{}, false
+	}
+
+	step := e.steps[e.pos]
+
+	e.served += 1
+	if e.served >= max(step.Repeat, 1) && e.pos < len(e.steps)-1 {
+		e.pos += 1
+		e.served = 0
+	}
+
+	if step.Status == 0 {
+		step.Status = http.StatusOK
+	}
+
+	return step, true
+}
+
+type MockServer struct {
+	newArrivals   mockEndpoint
+	productsBySku mockEndpoint
+	webhook       mockEndpoint
+	sinkMu        sync.Mutex
+}
+
+// Serves the new arrivals and products by sku endpoints from the fixtures in the mock folder and
+// records every webhook posted to it. Point the "endpoints" config section and webhook urls at it
+func runMockServer(args []string) error {
+	port := DEFAULT_MOCK_PORT
+	if len(args) > 0 {
+		p, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid port \"%s\": %v", args[0], err)
+		}
+		port = p
+	}
+
+	if _, err := os.Stat(pathMockFolder); os.IsNotExist(err) {
+		err := os.Mkdir(pathMockFolder, os.ModePerm)
+		if err != nil {
+			return fmt.Errorf("error creating mock folder: %v", err)
+		}
+	}
+
+	server := &MockServer{}
+
+	err := server.loadScript()
+	if err != nil {
+		return err
+	}
+
+	mockLogger.White(fmt.Sprintf("Mock server listening on localhost:%d", port))
+
+	return http.ListenAndServe(fmt.Sprintf("localhost:%d", port), server.handler())
+}
+
+func (s *MockServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/core/", s.handleNewArrivals)
+	mux.HandleFunc("POST /graphql", s.handleProductsBySku)
+	mux.HandleFunc("POST /webhook/", s.handleWebhook)
+	mux.HandleFunc("POST /mock/reset", s.handleReset)
+
+	return mux
+}
+
+func (s *MockServer) loadScript() error {
+	script := MockScript{}
+
+	bytes, err := os.ReadFile(pathMockScript)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error reading \"%s\": %v", pathMockScript, err)
+	}
+	if err == nil {
+		err = json.Unmarshal(bytes, &script)
+		if err != nil {
+			return fmt.Errorf("error unmarshalling mock script: %v", err)
+		}
+	}
+
+	s.newArrivals.reset(script.NewArrivals)
+	s.productsBySku.reset(script.ProductsBySku)
+	s.webhook.reset(script.Webhook)
+
+	mockLogger.Grey(fmt.Sprintf("Loaded mock script (%d new arrivals, %d products by sku, %d webhook steps)", len(script.NewArrivals), len(script.ProductsBySku), len(script.Webhook)))
+
+	return nil
+}
+
+func (s *MockServer) handleReset(w http.ResponseWriter, r *http.Request) {
+	err := s.loadScript()
+	if err != nil {
+		mockLogger.Red(fmt.Sprintf("Reset: %v", err))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *MockServer) handleNewArrivals(w http.ResponseWriter, r *http.Request) {
+	step, ok := s.newArrivals.next()
+	if !ok {
+		writeMockJson(w, http.StatusOK, map[string]any{"response": map[string]any{"docs": []any{}}})
+		return
+	}
+
+	fixture, err := readMockFixture(step.File)
+	if err != nil {
+		mockLogger.Red(fmt.Sprintf("New arrivals: %v", err))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if step.Status != http.StatusOK || fixture == nil {
+		writeMockJson(w, step.Status, fixture)
+		return
+	}
+
+	// Slice the fixture docs like the real endpoint paginates
+	start, _ := strconv.Atoi(r.URL.Query().Get("start"))
+	rows, err := strconv.Atoi(r.URL.Query().Get("rows"))
+	if err != nil {
+		rows = DEFAULT_NEW_ARRIVALS_ROWS
+	}
+
+	if response, ok := fixture["response"].(map[string]any); ok {
+		if docs, ok := response["docs"].([]any); ok {
+			start = min(max(start, 0), len(docs))
+			response["docs"] = docs[start:min(start+rows, len(docs))]
+		}
+	}
+
+	mockLogger.Grey(fmt.Sprintf("New arrivals: served %s (start=%d rows=%d)", step.File, start, rows))
+
+	writeMockJson(w, step.Status, fixture)
+}
+
+func (s *MockServer) handleProductsBySku(w http.ResponseWriter, r *http.Request) {
+	var body productsBySkuBody
+
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error decoding body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	step, ok := s.productsBySku.next()
+	if !ok {
+		writeMockJson(w, http.StatusOK, map[string]any{})
+		return
+	}
+
+	fixture, err := readMockFixture(step.File)
+	if err != nil {
+		mockLogger.Red(fmt.Sprintf("Products by sku: %v", err))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if step.Status != http.StatusOK || fixture == nil {
+		writeMockJson(w, step.Status, fixture)
+		return
+	}
+
+	// Only return the products that have been requested
+	requested := make(map[string]bool)
+	for _, sku := range body.Variables.Skus {
+		requested[strings.ToUpper(sku)] = true
+	}
+
+	products := lookupMockPath(fixture, "data", "site", "search", "searchProducts", "products")
+	if edges, ok := products["edges"].([]any); ok {
+		filtered := []any{}
+		for _, edge := range edges {
+			edgeMap, _ := edge.(map[string]any)
+			node, _ := edgeMap["node"].(map[string]any)
+			if sku, ok := node["sku"].(string); ok && requested[strings.ToUpper(sku)] {
+				filtered = append(filtered, edge)
+			}
+		}
+		products["edges"] = filtered
+
+		mockLogger.Grey(fmt.Sprintf("Products by sku: served %d/%d products from %s", len(filtered), len(body.Variables.Skus), step.File))
+	}
+
+	writeMockJson(w, step.Status, fixture)
+}
+
+func (s *MockServer) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	payload, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error reading body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	status := http.StatusNoContent
+	if step, ok := s.webhook.next(); ok && step.Status != http.StatusOK {
+		status = step.Status
+	}
+
+	if status == http.StatusTooManyRequests {
+		mockLogger.Yellow(fmt.Sprintf("Webhook %s: answering with rate limit", r.URL.Path))
+
+		w.Header().Set("Retry-After", "1")
+		writeMockJson(w, status, map[string]any{"message": "You are being rate limited.", "retry_after": 1.0, "global": false})
+		return
+	}
+
+	s.sinkMu.Lock()
+	err = appendMockWebhook(r.URL.Path, payload)
+	s.sinkMu.Unlock()
+	if err != nil {
+		mockLogger.Red(fmt.Sprintf("Webhook %s: %v", r.URL.Path, err))
+	}
+
+	var hook struct {
+		Embeds []struct {
+			Title string `json:"title"`
+		} `json:"embeds"`
+	}
+	json.Unmarshal(payload, &hook)
+
+	for _, embed := range hook.Embeds {
+		mockLogger.Green(fmt.Sprintf("Webhook %s: %s", r.URL.Path, embed.Title))
+	}
+
+	w.WriteHeader(status)
+}
+
+func readMockFixture(filename string) (map[string]any, error) {
+	if filename == "" {
+		return nil, nil
+	}
+
+	path := filepath.Join(pathMockFolder, filename)
+
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading fixture \"%s\": %v", path, err)
+	}
+
+	var fixture map[string]any
+
+	err = json.Unmarshal(bytes, &fixture)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling fixture \"%s\": %v", path, err)
+	}
+
+	return fixture, nil
+}
+
+func lookupMockPath(value map[string]any, keys ...string) map[string]any {
+	for _, key := range keys {
+		next, ok := value[key].(map[string]any)
+		if !ok {
+			return map[string]any{}
+		}
+		value = next
+	}
+	return value
+}
+
+func appendMockWebhook(path string, payload []byte) error {
+	entry := struct {
+		Time    time.Time       `json:"time"`
+		Path    string          `json:"path"`
+		Payload json.RawMessage `json:"payload"`
+	}{
+		Time:    time.Now(),
+		Path:    path,
+		Payload: payload,
+	}
+
+	bytes, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("error marshalling webhook entry: %v", err)
+	}
+
+	file, err := os.OpenFile(pathMockWebhookSink, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening \"%s\": %v", pathMockWebhookSink, err)
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(bytes, '\n'))
+	return err
+}
+
+func writeMockJson(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if value != nil {
+		json.NewEncoder(w).Encode(value)
+	}
+}
@@ -128,16 +128,20 @@ type ProductNode struct {
 	} `json:"categories"`
 	Prices struct {
 		Price struct {
-			CurrencyCode string `json:"currencyCode"`
-			Value        int    `json:"value"`
-			Typename     string `json:"__typename"`
+			CurrencyCode string  `json:"currencyCode"`
+			Value        float64 `json:"value"`
+			Typename     string  `json:"__typename"`
 		} `json:"price"`
 		BasePrice struct {
-			CurrencyCode string `json:"currencyCode"`
-			Value        int    `json:"value"`
-			Typename     string `json:"__typename"`
+			CurrencyCode string  `json:"currencyCode"`
+			Value        float64 `json:"value"`
+			Typename     string  `json:"__typename"`
 		} `json:"basePrice"`
-		SalePrice  interface{} `json:"salePrice"`
+		SalePrice *struct {
+			CurrencyCode string  `json:"currencyCode"`
+			Value        float64 `json:"value"`
+			Typename     string  `json:"__typename"`
+		} `json:"salePrice"`
 		PriceRange struct {
 			Min struct {
 				CurrencyCode string `json:"currencyCode"`
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Regions without default categories are not filtered
+func (c NewArrivalsConfig) getCategories(instance string) []string {
+	if c.Categories == nil {
+		return defaultNewArrivalsCategories[strings.ToUpper(instance)]
+	}
+	return c.Categories
+}
+
+func (c NewArrivalsConfig) getSort() string {
+	if c.Sort == "" {
+		return DEFAULT_NEW_ARRIVALS_SORT
+	}
+	return c.Sort
+}
+
+func (c NewArrivalsConfig) getRows() int {
+	if c.Rows <= 0 {
+		return DEFAULT_NEW_ARRIVALS_ROWS
+	}
+	return c.Rows
+}
+
+func (c NewArrivalsConfig) getMaxPages() int {
+	if c.MaxPages <= 0 {
+		return DEFAULT_NEW_ARRIVALS_MAX_PAGES
+	}
+	return c.MaxPages
+}
+
+// Builds the category filter, sort and pagination part of the new arrivals query
+func (c NewArrivalsConfig) queryString(instance string, page int) string {
+	query := ""
+
+	if categories := c.getCategories(instance); len(categories) > 0 {
+		quoted := []string{}
+		for _, category := range categories {
+			quoted = append(quoted, fmt.Sprintf("\"%s\"", category))
+		}
+
+		query += fmt.Sprintf("&fq=%s", escapeQueryValue(fmt.Sprintf("Category:%s", strings.Join(quoted, " OR "))))
+	}
+
+	rows := c.getRows()
+
+	query += fmt.Sprintf("&sort=%s&start=%d&rows=%d", escapeQueryValue(c.getSort()), page*rows, rows)
+
+	return query
+}
+
+// Same as url.QueryEscape, but encodes spaces as %20 like the app does
+func escapeQueryValue(value string) string {
+	return strings.ReplaceAll(url.QueryEscape(value), "+", "%20")
+}
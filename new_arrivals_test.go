@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewArrivalsDefaultCategoriesByRegion(t *testing.T) {
+	newArrivalsConfig := NewArrivalsConfig{}
+
+	if query := newArrivalsConfig.queryString("EU", 0); !strings.Contains(query, escapeQueryValue("\"Laufschuhe\"")) {
+		t.Errorf("got EU query %s, want the german categories", query)
+	}
+	if query := newArrivalsConfig.queryString("uk", 0); !strings.Contains(query, escapeQueryValue("\"Running Shoes\"")) {
+		t.Errorf("got UK query %s, want the english categories", query)
+	}
+	if query := newArrivalsConfig.queryString("JP", 0); strings.Contains(query, "fq=") {
+		t.Errorf("got query %s for a region without default categories, want no category filter", query)
+	}
+
+	// An empty list disables the filter
+	newArrivalsConfig.Categories = []string{}
+	if query := newArrivalsConfig.queryString("EU", 0); strings.Contains(query, "fq=") {
+		t.Errorf("got query %s, want no category filter", query)
+	}
+}
+
+func TestNewArrivalsDefaults(t *testing.T) {
+	newArrivalsConfig := NewArrivalsConfig{}
+
+	if maxPages := newArrivalsConfig.getMaxPages(); maxPages != defaultConfig.LoadTask.NewArrivals.MaxPages {
+		t.Errorf("got max pages %d, want the default %d", maxPages, defaultConfig.LoadTask.NewArrivals.MaxPages)
+	}
+	if query := newArrivalsConfig.queryString("EU", 2); !strings.Contains(query, "&start=80&rows=40") {
+		t.Errorf("got query %s, want the third page of 40 rows", query)
+	}
+}
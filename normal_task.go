@@ -16,7 +16,7 @@ func NewNormalTask(taskName string, group *NormalTaskGroup) (*NormalTask, error)
 	}
 
 	normalTask := &NormalTask{
-		SnsTask: &SnsTask{},
+		SnsTask: &SnsTask{backend: group.backend, region: group.region, productsKind: CAPTURE_KIND_NORMAL},
 		group:   group,
 	}
 
@@ -37,7 +37,7 @@ func NewNormalTask(taskName string, group *NormalTaskGroup) (*NormalTask, error)
 
 func (t *NormalTask) loopMonitor() {
 	configMu.RLock()
-	defer time.Sleep(time.Millisecond * time.Duration(config.NormalTask.Timeout))
+	defer t.sleep(time.Millisecond * time.Duration(config.NormalTask.Timeout))
 	configMu.RUnlock()
 
 	if checkExceededTimeCheckSystemTime() {
@@ -52,11 +52,13 @@ func (t *NormalTask) loopMonitor() {
 		return
 	}
 
-	res, err := t.getProductsBySku(skus)
+	products, err := t.getProductsBySku(skus)
 	if err != nil {
 		t.logger.Red(err)
 		return
 	}
 
-	go t.group.checkProductsBySkusResponse(res, skus)
+	runInFlight(func() {
+		t.group.checkProducts(products, skus)
+	})
 }
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"reflect"
 	"strings"
+	"time"
 )
 
 const (
@@ -22,7 +23,7 @@ type NormalTaskGroup struct {
 	resetVariantsCount map[SkuQuery]int
 }
 
-func NewNormalTaskGroup(proxyHandler *ProxyHandler, webhookHandler *WebhookHandler, skuQueryStrings []string) (*NormalTaskGroup, error) {
+func NewNormalTaskGroup(region string, states *RegionProductStates, backend StoreBackend, proxyHandler *ProxyHandler, webhookHandler *WebhookHandler, skuQueryStrings []string) (*NormalTaskGroup, error) {
 	skuQueries := []SkuQuery{}
 	for _, queryStr := range skuQueryStrings {
 		queryStr = strings.ToUpper(queryStr)
@@ -37,7 +38,7 @@ func NewNormalTaskGroup(proxyHandler *ProxyHandler, webhookHandler *WebhookHandl
 		resetVariantsCount: make(map[SkuQuery]int),
 	}
 
-	baseTaskGroup, err := NewBaseTaskGroup("NORMAL", proxyHandler, webhookHandler)
+	baseTaskGroup, err := NewBaseTaskGroup(TASK_TYPE_NORMAL, region, states, backend, proxyHandler, webhookHandler)
 	if err != nil {
 		return nil, fmt.Errorf("error creating base task group: %v", err)
 	}
@@ -60,34 +61,118 @@ func (g *NormalTaskGroup) LinkToLoadTaskGroup(loadTaskGroup *LoadTaskGroup) erro
 	return nil
 }
 
-func (g *NormalTaskGroup) AddSkuQuery(skuStr string) {
+// Subscribed to config changes in createRegion
+func (g *NormalTaskGroup) onConfigChange(diff *ConfigDiff) {
+	if !diff.NormalNumTasks {
+		return
+	}
+
+	err := g.reconcileTasks(diff.New.NormalTask.NumTasks, func(taskName string) (*BaseTask, error) {
+		normalTask, err := NewNormalTask(taskName, g)
+		if err != nil {
+			return nil, err
+		}
+		return normalTask.BaseTask, nil
+	})
+	if err != nil {
+		g.logger.Red(fmt.Sprintf("Error applying numTasks %d: %v", diff.New.NormalTask.NumTasks, err))
+	}
+}
+
+func (g *NormalTaskGroup) AddSkuQuery(skuStr string, metadata *WatchMetadata) {
 	g.mu.Lock()
 	defer g.mu.Unlock()
 
-	skuStr = strings.TrimSpace(strings.ToUpper(skuStr))
+	statesNormalMu.Lock()
+	g.addSkuQuery(strings.TrimSpace(strings.ToUpper(skuStr)), metadata)
+	statesNormalMu.Unlock()
+
+	markProductStatesDirty()
+}
+
+func (g *NormalTaskGroup) RemoveSkuQuery(skuStr string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	statesNormalMu.Lock()
+	g.removeSkuQuery(MakeSkuQuery(skuStr))
+	statesNormalMu.Unlock()
+
+	markProductStatesDirty()
+}
+
+// Adds the skus of a validated batch. Take g.mu and statesNormalMu Lock before calling addSkuQueries!
+// The caller is responsible for persisting the product states afterwards
+func (g *NormalTaskGroup) addSkuQueries(entries []WatchEntry) []BulkItemResult {
+	results := []BulkItemResult{}
+	for _, entry := range entries {
+		skuStr := strings.TrimSpace(strings.ToUpper(entry.Query))
+
+		result := BulkItemResult{
+			Region:    g.region,
+			InputType: "SKU",
+			Query:     skuStr,
+		}
+
+		if _, i := g.states.NormalGetState(skuStr); i >= 0 {
+			result.Status = BULK_STATUS_ALREADY_MONITORED
+		} else {
+			g.addSkuQuery(skuStr, entry.Metadata)
 
+			result.Status = BULK_STATUS_ADDED
+		}
+
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// Counterpart to addSkuQueries. Take g.mu and statesNormalMu Lock before calling removeSkuQueries!
+func (g *NormalTaskGroup) removeSkuQueries(skuStrs []string) []BulkItemResult {
+	results := []BulkItemResult{}
+	for _, skuStr := range skuStrs {
+		skuQuery := MakeSkuQuery(skuStr)
+
+		result := BulkItemResult{
+			Region:    g.region,
+			InputType: "SKU",
+			Query:     string(skuQuery),
+		}
+
+		if skuQuery == "" {
+			result.Status = BULK_STATUS_INVALID
+		} else if _, i := g.states.NormalGetState(string(skuQuery)); i == -1 {
+			result.Status = BULK_STATUS_NOT_FOUND
+		} else {
+			g.removeSkuQuery(skuQuery)
+
+			result.Status = BULK_STATUS_REMOVED
+		}
+
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// Take g.mu and statesNormalMu Lock before calling addSkuQuery!
+func (g *NormalTaskGroup) addSkuQuery(skuStr string, metadata *WatchMetadata) {
 	g.skuQueries = append(g.skuQueries, SkuQuery(skuStr))
 
 	newState := &ProductStateNormal{
 		Sku:              skuStr,
 		AvailableForSale: true,
 		AvailableSizes:   []AvailableSize{},
-		Price:            "0",
+		Price:            Price{},
+		Metadata:         metadata,
 	}
 
-	statesNormalMu.Lock()
-	NormalSetState(skuStr, newState)
-	statesNormalMu.Unlock()
-
-	go writeProductStates()
+	g.states.NormalSetState(skuStr, newState)
 }
 
-func (g *NormalTaskGroup) RemoveSkuQuery(skuStr string) {
-	g.mu.Lock()
-	defer g.mu.Unlock()
-
-	skuQuery := MakeSkuQuery(skuStr)
-
+// Take g.mu and statesNormalMu Lock before calling removeSkuQuery!
+func (g *NormalTaskGroup) removeSkuQuery(skuQuery SkuQuery) {
 	removeIndex := -1
 	for i, query := range g.skuQueries {
 		if query == skuQuery {
@@ -98,44 +183,36 @@ func (g *NormalTaskGroup) RemoveSkuQuery(skuStr string) {
 
 	if removeIndex >= 0 {
 		g.skuQueries = append(g.skuQueries[:removeIndex], g.skuQueries[removeIndex+1:]...)
-	}
 
-	statesNormalMu.Lock()
-	NormalUnsetState(string(skuQuery))
-	statesNormalMu.Unlock()
+		// Keep pointing at the same next sku, getNextSkus indexes with it
+		if removeIndex < g.nextPosToCheck {
+			g.nextPosToCheck -= 1
+		}
+		if g.nextPosToCheck >= len(g.skuQueries) {
+			g.nextPosToCheck = 0
+		}
+	}
 
-	go writeProductStates()
+	g.states.NormalUnsetState(string(skuQuery))
 }
 
-func (g *NormalTaskGroup) checkProductsBySkusResponse(res *ProductsBySkusResponse, skusInRequest []string) {
-	if g.loadTaskGroup == nil || res == nil {
+func (g *NormalTaskGroup) checkProducts(products []ProductData, skusInRequest []string) {
+	if g.loadTaskGroup == nil {
 		return
 	}
 
 	g.mu.Lock()
-	defer g.mu.Unlock()
 
 	syncRequired := false
+	events := []*Event{}
 
 	includedSkuQueries := make(map[SkuQuery]bool)
-	for _, productEdge := range res.Data.Site.Search.SearchProducts.Products.Edges {
-		if productEdge.Node.Variants == nil {
-			g.logger.Red(fmt.Sprintf("%s: Variants property nil. Skipping product edge...", productEdge.Node.Sku))
-			continue
-		}
-		if productEdge.Node.Variants.Edges == nil {
-			g.logger.Red(fmt.Sprintf("%s: Variants.Edges property nil. Skipping product edge...", productEdge.Node.Sku))
-			continue
-		}
-
-		pSkuQuery := MakeSkuQuery(productEdge.Node.Sku)
+	for _, productData := range products {
+		pSkuQuery := MakeSkuQuery(productData.Sku)
 
 		includedSkuQueries[pSkuQuery] = true
 		g.unloadCount[pSkuQuery] = 0
 
-		// Determine if sku is from normal or load
-		productData := GetProductData(productEdge.Node)
-
 		ignoreVariants := true
 		if len(productData.AvailableSizes) == 0 {
 			g.resetVariantsCount[pSkuQuery] += 1
@@ -150,10 +227,11 @@ func (g *NormalTaskGroup) checkProductsBySkusResponse(res *ProductsBySkusRespons
 			ignoreVariants = false
 		}
 
-		stateChanged := g.matchProductStates(productData, ignoreVariants)
+		stateChanged, productEvents := g.matchProductStates(productData, ignoreVariants)
 		if stateChanged {
 			syncRequired = true
 		}
+		events = append(events, productEvents...)
 	}
 
 	// Handling for SKUs that have been requested but are not included in the response
@@ -166,11 +244,18 @@ func (g *NormalTaskGroup) checkProductsBySkusResponse(res *ProductsBySkusRespons
 				resetStates := &ProductStateNormal{
 					Sku:              string(skuQuery),
 					AvailableForSale: true,
-					Price:            "0",
+					Price:            Price{},
 					AvailableSizes:   []AvailableSize{},
 				}
 
-				NormalSetState(resetStates.Sku, resetStates)
+				// Keep the metadata of the watch entry and the price history
+				if state, i := g.states.NormalGetState(resetStates.Sku); i >= 0 {
+					resetStates.Metadata = state.Metadata
+					resetStates.LowestPrice = state.LowestPrice
+					resetStates.PriceHistory = state.PriceHistory
+				}
+
+				g.states.NormalSetState(resetStates.Sku, resetStates)
 				statesNormalMu.Unlock()
 
 				g.unloadCount[skuQuery] = UNLOAD_THRESHOLD + 1 // Make sure to only reset once
@@ -184,12 +269,24 @@ func (g *NormalTaskGroup) checkProductsBySkusResponse(res *ProductsBySkusRespons
 		}
 	}
 
+	g.mu.Unlock()
+
+	// Emitted without the group lock, the cooldown and the sinks must not hold up the tasks
+	for _, event := range events {
+		webhookHandler.Emit(event)
+	}
+
 	if syncRequired {
-		go writeProductStates()
+		markProductStatesDirty()
 	}
 }
 
-func (g *NormalTaskGroup) matchProductStates(product ProductData, ignoreVariants bool) bool {
+// Take g.mu Lock before calling matchProductStates! Returns the events to emit once the lock is released
+func (g *NormalTaskGroup) matchProductStates(product ProductData, ignoreVariants bool) (bool, []*Event) {
+	configMu.RLock()
+	priceDrop := config.NormalTask.PriceDrop
+	configMu.RUnlock()
+
 	statesNormalMu.Lock()
 	defer statesNormalMu.Unlock()
 
@@ -202,13 +299,33 @@ func (g *NormalTaskGroup) matchProductStates(product ProductData, ignoreVariants
 	notifyAvailableForSale := false
 
 	newAvailableSizes := []AvailableSize{}
-	oldPrice := ""
+	removedSizes := []AvailableSize{}
+	oldPrice := Price{}
+	var lowestPrice *Price
+
+	availabilityChanged := false
+
+	now := time.Now()
+
+	var metadata *WatchMetadata
+	var previous *ProductData
 
 	productInStates := false
 
-	for _, state := range productStates.Normal.ProductStates {
+	for _, state := range g.states.Normal.ProductStates {
 		if state.Sku == product.Sku {
 			productInStates = true
+			metadata = state.Metadata
+
+			previous = &ProductData{
+				ProductUrl:       product.ProductUrl,
+				Title:            product.Title,
+				Sku:              state.Sku,
+				AvailableForSale: state.AvailableForSale,
+				AvailableSizes:   state.AvailableSizes,
+				Price:            state.Price,
+				ImageUrl:         product.ImageUrl,
+			}
 
 			if !ignoreVariants && !reflect.DeepEqual(state.AvailableSizes, product.AvailableSizes) {
 				stateChange = true
@@ -217,6 +334,7 @@ func (g *NormalTaskGroup) matchProductStates(product ProductData, ignoreVariants
 				if len(newAvailableSizes) != 0 {
 					notifySize = true
 				}
+				removedSizes = getChangesToAvailable(product.AvailableSizes, state.AvailableSizes)
 
 				state.AvailableSizes = product.AvailableSizes
 			}
@@ -224,14 +342,19 @@ func (g *NormalTaskGroup) matchProductStates(product ProductData, ignoreVariants
 			if state.Price != product.Price {
 				stateChange = true
 
-				notifyPrice = true
+				notifyPrice = priceDrop.shouldNotify(state.Price, product.Price)
 
 				oldPrice = state.Price
 
 				state.Price = product.Price
 			}
 
+			state.recordPrice(product.Price, now)
+			lowestPrice = state.LowestPrice
+
 			if state.AvailableForSale != product.AvailableForSale {
+				availabilityChanged = true
+
 				if !state.AvailableForSale {
 					notifyAvailableForSale = true
 				}
@@ -248,13 +371,19 @@ func (g *NormalTaskGroup) matchProductStates(product ProductData, ignoreVariants
 			AvailableSizes:   product.AvailableSizes,
 			Price:            product.Price,
 		}
+		newState.recordPrice(product.Price, now)
 
-		NormalSetState(newState.Sku, newState)
+		g.states.NormalSetState(newState.Sku, newState)
 
 		stateChange = true
 	}
 
 	// Console log
+	// Prices of old product states have no currency, filling it in is not a change worth logging
+	if oldPrice.Amount != product.Price.Amount && !oldPrice.IsZero() && !notifyPrice {
+		g.logger.Grey(fmt.Sprintf("%s: Price changed: %s -> %s (not notified)", product.Sku, oldPrice, product.Price))
+	}
+
 	if notifySize || notifyPrice || notifyAvailableForSale {
 		notifyStr := fmt.Sprintf("%s:", product.Sku)
 		colorGreen := false
@@ -265,7 +394,7 @@ func (g *NormalTaskGroup) matchProductStates(product ProductData, ignoreVariants
 		}
 		if notifyPrice {
 			notifyStr = fmt.Sprintf("%s Price changed: %s -> %s |", notifyStr, oldPrice, product.Price)
-			if oldPrice > product.Price {
+			if product.Price.Amount < oldPrice.Amount {
 				colorGreen = true
 			}
 		}
@@ -288,20 +417,62 @@ func (g *NormalTaskGroup) matchProductStates(product ProductData, ignoreVariants
 		g.logger.Grey(fmt.Sprintf("%s: No changes", product.Sku))
 	}
 
+	// History
+	if stateChange || availabilityChanged {
+		record := &HistoryRecord{
+			Time:             now,
+			Type:             HISTORY_STATE,
+			Region:           g.region,
+			Sku:              product.Sku,
+			AvailableForSale: product.AvailableForSale,
+			NewSizes:         newAvailableSizes,
+			RemovedSizes:     removedSizes,
+			Price:            product.Price,
+		}
+		if !ignoreVariants {
+			record.AvailableSizes = product.AvailableSizes
+		}
+		if !oldPrice.IsZero() && oldPrice.Amount != product.Price.Amount {
+			recordOldPrice := oldPrice
+			record.OldPrice = &recordOldPrice
+		}
+
+		historyStore.Append(record)
+	}
+
 	// Webhook notify
+	diff := EventDiff{
+		NewSizes:        newAvailableSizes,
+		BecameAvailable: notifyAvailableForSale,
+	}
+	if notifyPrice {
+		newPrice := product.Price
+
+		diff.OldPrice = &oldPrice
+		diff.NewPrice = &newPrice
+		diff.DropPercent = priceDropPercent(oldPrice, newPrice)
+		diff.LowestPrice = lowestPrice
+	}
+
+	kinds := []string{}
 	if notifySize && isAvailableForSale {
-		g.notifySize(product)
+		kinds = append(kinds, EVENT_RESTOCK)
 	}
 	if notifyPrice {
-		if oldPrice > product.Price {
-			g.notifyPrice(product, oldPrice)
-		}
+		kinds = append(kinds, EVENT_PRICE)
 	}
 	if notifyAvailableForSale {
-		g.notifyAvailable(product)
+		kinds = append(kinds, EVENT_AVAILABLE)
+	}
+
+	events := []*Event{}
+	for _, kind := range kinds {
+		if event, ok := g.newEvent(kind, product, previous, diff, metadata); ok {
+			events = append(events, event)
+		}
 	}
 
-	return stateChange
+	return stateChange, events
 }
 
 func (g *NormalTaskGroup) isNormalSku(sku SkuQuery) bool {
@@ -351,14 +522,25 @@ func (g *NormalTaskGroup) getNextSkus() []string {
 	return nextSkus
 }
 
-func (t *NormalTaskGroup) notifySize(productData ProductData) {
-	webhookHandler.NotifyRestock(productData)
-}
+// Applies the filter of the watch entry. Returns false if the event is filtered out
+func (g *NormalTaskGroup) newEvent(kind string, productData ProductData, previous *ProductData, diff EventDiff, metadata *WatchMetadata) (*Event, bool) {
+	event := &Event{
+		Kind:        kind,
+		Region:      g.region,
+		Time:        time.Now(),
+		Product:     productData,
+		Previous:    previous,
+		Diff:        diff,
+		WebhookUrls: metadataWebhookUrls(metadata),
+	}
 
-func (t *NormalTaskGroup) notifyPrice(productData ProductData, oldPrice string) {
-	webhookHandler.NotifyPrice(productData, oldPrice)
-}
+	filter := metadataFilter(metadata)
+
+	event, ok := filter.Apply(event)
+	if !ok {
+		g.logger.Grey(fmt.Sprintf("%s: %s filtered out (%s)", productData.Sku, kind, filter))
+		return nil, false
+	}
 
-func (t *NormalTaskGroup) notifyAvailable(productData ProductData) {
-	webhookHandler.NotifyAvailable(productData)
+	return event, true
 }
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	SINK_DISCORD  = "discord"
+	SINK_TELEGRAM = "telegram"
+	SINK_SLACK    = "slack"
+	SINK_JSON     = "json"
+	SINK_FILE     = "file"
+)
+
+type Notifier interface {
+	Name() string
+	Notify(event *Event) error
+}
+
+// Take configMu RLock before calling getNotifiers! Event types without configured sinks go to discord
+func getNotifiers(eventType string) ([]Notifier, error) {
+	sinkNames, ok := config.Notifiers.Events[eventType]
+	if !ok {
+		sinkNames = []string{SINK_DISCORD}
+	}
+
+	notifiers := []Notifier{}
+	for _, sinkName := range sinkNames {
+		notifier, err := newNotifier(sinkName)
+		if err != nil {
+			return notifiers, err
+		}
+
+		notifiers = append(notifiers, notifier)
+	}
+
+	return notifiers, nil
+}
+
+// Take configMu RLock before calling newNotifier! Sinks copy their settings, so sending does not hold the config lock
+func newNotifier(sinkName string) (Notifier, error) {
+	switch strings.ToLower(strings.TrimSpace(sinkName)) {
+	case SINK_DISCORD:
+		return NewDiscordNotifier(), nil
+	case SINK_TELEGRAM:
+		return NewTelegramNotifier(), nil
+	case SINK_SLACK:
+		return NewSlackNotifier(), nil
+	case SINK_JSON:
+		return NewJsonNotifier(), nil
+	case SINK_FILE:
+		return NewFileNotifier(), nil
+	default:
+		return nil, fmt.Errorf("unknown notification sink: %s", sinkName)
+	}
+}
+
+// Plain text rendering shared by the chat sinks. formatLink renders a link in the markup of the sink
+func formatEventText(event *Event, formatLink func(text string, url string) string) string {
+	lines := []string{
+		event.Product.Title,
+		event.Product.ProductUrl,
+		"",
+		fmt.Sprintf("SKU/PID: %s", event.Product.Sku),
+		fmt.Sprintf("PRICE: %s", event.PriceText()),
+		fmt.Sprintf("TYPE: %s", event.Label()),
+	}
+
+	for _, field := range event.PriceFields() {
+		lines = append(lines, fmt.Sprintf("%s: %s", field.Name, field.Value))
+	}
+
+	if event.ShowAvailable() {
+		lines = append(lines, fmt.Sprintf("AVAILABLE: %t", event.Product.AvailableForSale))
+	}
+
+	sizeLines := event.SizeLines()
+	if len(sizeLines) == 0 {
+		lines = append(lines, "Sizes: none")
+	} else {
+		lines = append(lines, "Sizes:")
+		lines = append(lines, sizeLines...)
+	}
+
+	if len(event.MatchedQueries) > 0 {
+		lines = append(lines, "Keyword Query Hits:")
+		lines = append(lines, event.MatchedQueries...)
+	}
+
+	links := []string{}
+	for _, link := range event.Links() {
+		links = append(links, formatLink(link.Text, link.Url))
+	}
+	lines = append(lines, fmt.Sprintf("Extra: %s", strings.Join(links, " | ")))
+
+	return strings.Join(lines, "\n")
+}
+
+// Errors of the http client repeat the url, which holds the bot token of telegram and the secret of webhooks
+func redactUrlError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		redacted := *urlErr
+		redacted.URL = redactUrl(urlErr.URL)
+		return &redacted
+	}
+	return err
+}
+
+// Hides the secret part of webhook and bot api urls for logs and dead letters
+func redactUrl(rawUrl string) string {
+	if strings.HasPrefix(rawUrl, TELEGRAM_API_URL+"/bot") {
+		rest := strings.TrimPrefix(rawUrl, TELEGRAM_API_URL+"/bot")
+		if j := strings.Index(rest, "/"); j >= 0 {
+			return TELEGRAM_API_URL + "/bot***" + rest[j:]
+		}
+		return TELEGRAM_API_URL + "/bot***"
+	}
+	return redactWebhookUrl(rawUrl)
+}
+
+func postJson(targetUrl string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error marshalling payload: %v", err)
+	}
+
+	req, err := http.NewRequest("POST", targetUrl, bytes.NewBuffer(body))
+	if err != nil {
+		return fmt.Errorf("error creating request: %v", redactUrlError(err))
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+
+	client := &http.Client{
+		Timeout: 15 * time.Second,
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %v", redactUrlError(err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := io.ReadAll(resp.Body)
+
+		return fmt.Errorf("unexpected response (%d): %s", resp.StatusCode, respBody)
+	}
+
+	return nil
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	OUTBOX_OP_ADD  = "add"
+	OUTBOX_OP_DONE = "done"
+
+	// Deliveries with the same key are skipped while pending and for this long after they completed.
+	// Covers events that are detected again after a restart because the product states were not written yet
+	OUTBOX_DEDUP_WINDOW = 2 * time.Minute
+
+	// The log is rewritten once this share of its lines is outdated by finished deliveries.
+	// Each delivery leaves one outdated add line, so the share stays below 0.5
+	OUTBOX_COMPACT_MIN_LINES  = 256
+	OUTBOX_COMPACT_STALE_RATE = 0.4
+)
+
+var notificationOutbox *Outbox = nil
+
+// Append-only log of pending deliveries. A delivery is added before it is handed to a sink
+// and marked done once it was sent or dead-lettered. Pending deliveries are replayed on startup
+type Outbox struct {
+	mu        sync.Mutex
+	path      string
+	file      *os.File
+	lines     int // Lines in the file, including the ones of finished deliveries
+	pending   map[string]*OutboxRecord
+	completed map[string]time.Time
+	logger    *Logger
+}
+
+type OutboxRecord struct {
+	Op      string          `json:"op"`
+	Key     string          `json:"key"`
+	Time    time.Time       `json:"time"`
+	Sink    string          `json:"sink,omitempty"`
+	Target  string          `json:"target,omitempty"`
+	Event   *Event          `json:"event,omitempty"`
+	Targets []string        `json:"targets,omitempty"` // Webhook urls of the event, the event does not serialize them
+	Payload json.RawMessage `json:"payload,omitempty"` // Rendered discord payload
+}
+
+// Reads the outbox log, keeps pending deliveries and recent completions and compacts the file
+func OpenOutbox(path string) (*Outbox, error) {
+	outbox := &Outbox{
+		mu:        sync.Mutex{},
+		path:      path,
+		pending:   make(map[string]*OutboxRecord),
+		completed: make(map[string]time.Time),
+		logger:    NewLogger("OUTBOX"),
+	}
+
+	err := outbox.read(path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = outbox.compact()
+	if err != nil {
+		return nil, err
+	}
+
+	return outbox, nil
+}
+
+func (o *Outbox) read(path string) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error opening outbox: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		var record OutboxRecord
+
+		// A crash can leave a partial last line
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			o.logger.Yellow(fmt.Sprintf("Skipping unreadable outbox line: %v", err))
+			continue
+		}
+
+		switch record.Op {
+		case OUTBOX_OP_ADD:
+			o.pending[record.Key] = &record
+		case OUTBOX_OP_DONE:
+			delete(o.pending, record.Key)
+			o.completed[record.Key] = record.Time
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Rewrites the log with the pending deliveries and recent completions and reopens it for appending.
+// Take o.mu Lock before calling compact, unless the outbox is being opened!
+func (o *Outbox) compact() error {
+	var sb strings.Builder
+
+	lines := 0
+	for key, doneTime := range o.completed {
+		if time.Since(doneTime) > OUTBOX_DEDUP_WINDOW {
+			delete(o.completed, key)
+			continue
+		}
+
+		if err := writeOutboxLine(&sb, &OutboxRecord{Op: OUTBOX_OP_DONE, Key: key, Time: doneTime}); err != nil {
+			return err
+		}
+		lines += 1
+	}
+	for _, record := range o.pending {
+		if err := writeOutboxLine(&sb, record); err != nil {
+			return err
+		}
+		lines += 1
+	}
+
+	if o.file != nil {
+		err := o.file.Close()
+		if err != nil {
+			return fmt.Errorf("error closing outbox: %v", err)
+		}
+		o.file = nil
+	}
+
+	err := writeFileAtomic(o.path, []byte(sb.String()))
+	if err != nil {
+		return fmt.Errorf("error writing compacted outbox: %v", err)
+	}
+
+	file, err := os.OpenFile(o.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening outbox: %v", err)
+	}
+	o.file = file
+	o.lines = lines
+
+	return nil
+}
+
+// Take o.mu Lock before calling compactIfStale!
+func (o *Outbox) compactIfStale() {
+	if o.lines < OUTBOX_COMPACT_MIN_LINES {
+		return
+	}
+
+	staleLines := o.lines - len(o.pending) - len(o.completed)
+	if float64(staleLines) < float64(o.lines)*OUTBOX_COMPACT_STALE_RATE {
+		return
+	}
+
+	err := o.compact()
+	if err != nil {
+		o.logger.Red(fmt.Sprintf("Error compacting outbox: %v", err))
+	}
+}
+
+func writeOutboxLine(sb *strings.Builder, record *OutboxRecord) error {
+	bytes, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("error marshalling outbox record: %v", err)
+	}
+
+	sb.Write(bytes)
+	sb.WriteByte('\n')
+
+	return nil
+}
+
+// Returns the deliveries that were not done when the outbox was opened, oldest first
+func (o *Outbox) Pending() []*OutboxRecord {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	records := []*OutboxRecord{}
+	for _, record := range o.pending {
+		records = append(records, record)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Time.Before(records[j].Time)
+	})
+
+	return records
+}
+
+// Persists the delivery before it is sent. Returns false if a delivery with the same key is pending or completed recently
+func (o *Outbox) Add(record *OutboxRecord) bool {
+	if o == nil {
+		return true
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if _, ok := o.pending[record.Key]; ok {
+		return false
+	}
+	if doneTime, ok := o.completed[record.Key]; ok && time.Since(doneTime) < OUTBOX_DEDUP_WINDOW {
+		return false
+	}
+
+	record.Op = OUTBOX_OP_ADD
+	record.Time = time.Now()
+
+	o.pending[record.Key] = record
+
+	// Sync adds, a delivery must not get lost once accepted
+	o.append(record, true)
+
+	return true
+}
+
+// Persists the record without the duplicate check. A pending record with the same key is replaced,
+// for records whose content changes until they are done
+func (o *Outbox) Put(record *OutboxRecord) {
+	if o == nil {
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	record.Op = OUTBOX_OP_ADD
+	record.Time = time.Now()
+
+	o.pending[record.Key] = record
+
+	o.append(record, true)
+}
+
+func (o *Outbox) Done(key string) {
+	if o == nil || key == "" {
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	delete(o.pending, key)
+
+	now := time.Now()
+	o.completed[key] = now
+
+	for completedKey, doneTime := range o.completed {
+		if now.Sub(doneTime) > OUTBOX_DEDUP_WINDOW {
+			delete(o.completed, completedKey)
+		}
+	}
+
+	o.append(&OutboxRecord{Op: OUTBOX_OP_DONE, Key: key, Time: now}, false)
+
+	o.compactIfStale()
+}
+
+// Take o.mu Lock before calling append!
+func (o *Outbox) append(record *OutboxRecord, sync bool) {
+	bytes, err := json.Marshal(record)
+	if err != nil {
+		o.logger.Red(fmt.Sprintf("Error marshalling outbox record: %v", err))
+		return
+	}
+
+	if o.file == nil {
+		o.logger.Red("Error writing outbox: outbox not open")
+		return
+	}
+
+	_, err = o.file.Write(append(bytes, '\n'))
+	if err != nil {
+		o.logger.Red(fmt.Sprintf("Error writing outbox: %v", err))
+		return
+	}
+	o.lines += 1
+
+	if sync {
+		err = o.file.Sync()
+		if err != nil {
+			o.logger.Red(fmt.Sprintf("Error syncing outbox: %v", err))
+		}
+	}
+}
+
+func (o *Outbox) Close() error {
+	if o == nil {
+		return nil
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.file == nil {
+		return nil
+	}
+
+	err := o.file.Close()
+	o.file = nil
+
+	return err
+}
+
+// Identifies a delivery of an event to one sink target. Equal detections of the same change produce the same key
+func outboxKey(sink string, target string, event *Event) string {
+	sizes := []string{}
+	for _, availableSize := range event.Product.AvailableSizes {
+		sizes = append(sizes, fmt.Sprintf("%s:%d", availableSize.Name, availableSize.AmountInStock))
+	}
+
+	parts := []string{
+		sink,
+		target,
+		event.Kind,
+		event.Region,
+		event.Product.Sku,
+		event.Product.Price.String(),
+		strings.Join(sizes, ","),
+		strings.Join(event.MatchedQueries, ","),
+	}
+
+	hash := sha256.Sum256([]byte(strings.Join(parts, "|")))
+
+	return hex.EncodeToString(hash[:16])
+}
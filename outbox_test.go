@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openTestOutbox(t *testing.T, path string) *Outbox {
+	t.Helper()
+
+	outbox, err := OpenOutbox(path)
+	if err != nil {
+		t.Fatalf("error opening outbox: %v", err)
+	}
+	t.Cleanup(func() { outbox.Close() })
+
+	return outbox
+}
+
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+
+	fileBytes, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Count(fileBytes, []byte("\n"))
+}
+
+func TestOutboxReplaysPendingInOrder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.jsonl")
+
+	outbox := openTestOutbox(t, path)
+	for _, key := range []string{"c", "a", "b"} {
+		if !outbox.Add(&OutboxRecord{Key: key, Sink: SINK_JSON, Event: &Event{Kind: EVENT_RESTOCK}}) {
+			t.Fatalf("record %s not added", key)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	outbox.Done("a")
+
+	if outbox.Add(&OutboxRecord{Key: "a", Sink: SINK_JSON}) {
+		t.Errorf("completed record added again within the dedup window")
+	}
+	if outbox.Add(&OutboxRecord{Key: "b", Sink: SINK_JSON}) {
+		t.Errorf("pending record added twice")
+	}
+	outbox.Close()
+
+	reopened := openTestOutbox(t, path)
+
+	pending := reopened.Pending()
+	if len(pending) != 2 || pending[0].Key != "c" || pending[1].Key != "b" {
+		keys := []string{}
+		for _, record := range pending {
+			keys = append(keys, record.Key)
+		}
+		t.Fatalf("got pending %v, want [c b]", keys)
+	}
+
+	if reopened.Add(&OutboxRecord{Key: "a", Sink: SINK_JSON}) {
+		t.Errorf("completion was not kept across a restart")
+	}
+}
+
+func TestOutboxCompactsFinishedRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.jsonl")
+
+	outbox := openTestOutbox(t, path)
+	outbox.Add(&OutboxRecord{Key: "pending", Sink: SINK_JSON})
+
+	for i := range OUTBOX_COMPACT_MIN_LINES * 2 {
+		key := fmt.Sprintf("key-%d", i)
+		outbox.Add(&OutboxRecord{Key: key, Sink: SINK_JSON})
+		outbox.Done(key)
+	}
+
+	// Every delivery leaves an add and a done line until the log is compacted
+	if lines := countLines(t, path); lines >= OUTBOX_COMPACT_MIN_LINES*4 {
+		t.Errorf("got %d lines, the log was never compacted", lines)
+	}
+
+	outbox.Close()
+
+	reopened := openTestOutbox(t, path)
+	if pending := reopened.Pending(); len(pending) != 1 || pending[0].Key != "pending" {
+		t.Errorf("got %d pending records after compaction, want the one pending record", len(pending))
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	PRICE_MINOR_UNITS = 100 // All supported currencies have two decimals
+	MAX_PRICE_HISTORY = 100 // Price changes kept per sku
+)
+
+type Price struct {
+	Amount   int64  `json:"amount"` // Minor units
+	Currency string `json:"currency"`
+}
+
+type PricePoint struct {
+	Time  time.Time `json:"time"`
+	Price Price     `json:"price"`
+}
+
+func NewPrice(value float64, currency string) Price {
+	return Price{
+		Amount:   int64(math.Round(value * PRICE_MINOR_UNITS)),
+		Currency: strings.ToUpper(currency),
+	}
+}
+
+// Accepts the old product states format that stored the price as a string in major units
+func (p *Price) UnmarshalJSON(bytes []byte) error {
+	var legacy string
+	if err := json.Unmarshal(bytes, &legacy); err == nil {
+		value, err := strconv.ParseFloat(strings.TrimSpace(legacy), 64)
+		if err != nil {
+			return fmt.Errorf("error parsing legacy price %q: %v", legacy, err)
+		}
+
+		*p = NewPrice(value, "")
+		return nil
+	}
+
+	type price Price
+	var newPrice price
+
+	err := json.Unmarshal(bytes, &newPrice)
+	if err != nil {
+		return err
+	}
+
+	*p = Price(newPrice)
+	return nil
+}
+
+func (p Price) IsZero() bool {
+	return p.Amount == 0
+}
+
+func (p Price) Major() float64 {
+	return float64(p.Amount) / PRICE_MINOR_UNITS
+}
+
+// Prices without currency come from old product states and are comparable to any currency
+func (p Price) SameCurrency(other Price) bool {
+	return p.Currency == "" || other.Currency == "" || p.Currency == other.Currency
+}
+
+func (p Price) String() string {
+	amount := strconv.FormatInt(p.Amount/PRICE_MINOR_UNITS, 10)
+	if cents := p.Amount % PRICE_MINOR_UNITS; cents != 0 {
+		amount = fmt.Sprintf("%s.%02d", amount, cents)
+	}
+
+	if p.Currency == "" {
+		return amount
+	}
+	return fmt.Sprintf("%s %s", amount, p.Currency)
+}
+
+// Returns the drop from old to new in percent of the old price. Negative for increases
+func priceDropPercent(oldPrice Price, newPrice Price) float64 {
+	if oldPrice.Amount == 0 {
+		return 0
+	}
+	return float64(oldPrice.Amount-newPrice.Amount) / float64(oldPrice.Amount) * 100
+}
+
+// Decides if a price change is notified. Changes from or to an unknown price are never notified
+func (c PriceDropConfig) shouldNotify(oldPrice Price, newPrice Price) bool {
+	if oldPrice.IsZero() || newPrice.IsZero() || !oldPrice.SameCurrency(newPrice) {
+		return false
+	}
+
+	drop := oldPrice.Amount - newPrice.Amount
+	if drop < 0 {
+		return c.NotifyIncrease
+	}
+	if drop == 0 {
+		return false
+	}
+
+	if c.MinAmount > 0 && drop < int64(math.Round(c.MinAmount*PRICE_MINOR_UNITS)) {
+		return false
+	}
+	if c.MinPercent > 0 && priceDropPercent(oldPrice, newPrice) < c.MinPercent {
+		return false
+	}
+
+	return true
+}
+
+// Take statesNormalMu before calling recordPrice! Appends the price if it changed and keeps the all-time low
+func (s *ProductStateNormal) recordPrice(price Price, now time.Time) {
+	if price.IsZero() {
+		return
+	}
+
+	if len(s.PriceHistory) == 0 || s.PriceHistory[len(s.PriceHistory)-1].Price != price {
+		s.PriceHistory = append(s.PriceHistory, PricePoint{
+			Time:  now,
+			Price: price,
+		})
+
+		if len(s.PriceHistory) > MAX_PRICE_HISTORY {
+			s.PriceHistory = s.PriceHistory[len(s.PriceHistory)-MAX_PRICE_HISTORY:]
+		}
+	}
+
+	// A currency change makes the old low meaningless
+	if s.LowestPrice == nil || !s.LowestPrice.SameCurrency(price) || price.Amount < s.LowestPrice.Amount {
+		lowestPrice := price
+		s.LowestPrice = &lowestPrice
+	}
+}
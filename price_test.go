@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestNewPrice(t *testing.T) {
+	tests := []struct {
+		value    float64
+		currency string
+		want     Price
+		str      string
+	}{
+		{119.99, "eur", Price{Amount: 11999, Currency: "EUR"}, "119.99 EUR"},
+		{0.1 + 0.2, "EUR", Price{Amount: 30, Currency: "EUR"}, "0.30 EUR"},
+		{95, "", Price{Amount: 9500}, "95"},
+		{120.5, "USD", Price{Amount: 12050, Currency: "USD"}, "120.50 USD"},
+	}
+
+	for _, test := range tests {
+		price := NewPrice(test.value, test.currency)
+		if price != test.want {
+			t.Errorf("NewPrice(%g, %q) = %+v, want %+v", test.value, test.currency, price, test.want)
+		}
+		if price.String() != test.str {
+			t.Errorf("got %q for %+v, want %q", price.String(), price, test.str)
+		}
+	}
+}
+
+func TestPriceUnmarshalLegacy(t *testing.T) {
+	var state ProductStateNormal
+
+	err := json.Unmarshal([]byte(`{"sku": "DV0833-104", "price": "95.90"}`), &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Price != (Price{Amount: 9590}) {
+		t.Errorf("got legacy price %+v, want 9590 minor units", state.Price)
+	}
+
+	err = json.Unmarshal([]byte(`{"sku": "DV0833-104", "price": {"amount": 12000, "currency": "EUR"}}`), &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Price != (Price{Amount: 12000, Currency: "EUR"}) {
+		t.Errorf("got price %+v, want 120 EUR", state.Price)
+	}
+
+	err = json.Unmarshal([]byte(`{"price": "n/a"}`), &state)
+	if err == nil {
+		t.Error("invalid legacy price accepted")
+	}
+}
+
+func TestPriceDropPercent(t *testing.T) {
+	if got := priceDropPercent(NewPrice(120, "EUR"), NewPrice(90, "EUR")); got != 25 {
+		t.Errorf("got %g%%, want 25%%", got)
+	}
+	if got := priceDropPercent(NewPrice(100, "EUR"), NewPrice(110, "EUR")); got != -10 {
+		t.Errorf("got %g%% for an increase, want -10%%", got)
+	}
+	if got := priceDropPercent(Price{}, NewPrice(110, "EUR")); got != 0 {
+		t.Errorf("got %g%% from an unknown price, want 0", got)
+	}
+}
+
+func TestShouldNotify(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   PriceDropConfig
+		oldPrice Price
+		newPrice Price
+		want     bool
+	}{
+		// Compared as strings "95" > "120" would not be a drop
+		{"numeric drop", PriceDropConfig{}, NewPrice(120, "EUR"), NewPrice(95, "EUR"), true},
+		{"unchanged", PriceDropConfig{}, NewPrice(120, "EUR"), NewPrice(120, "EUR"), false},
+		{"increase", PriceDropConfig{}, NewPrice(95, "EUR"), NewPrice(120, "EUR"), false},
+		{"increase notified", PriceDropConfig{NotifyIncrease: true}, NewPrice(95, "EUR"), NewPrice(120, "EUR"), true},
+		{"unknown old price", PriceDropConfig{}, Price{}, NewPrice(95, "EUR"), false},
+		{"unknown new price", PriceDropConfig{}, NewPrice(120, "EUR"), Price{}, false},
+		{"currency change", PriceDropConfig{}, NewPrice(120, "EUR"), NewPrice(95, "USD"), false},
+		{"legacy currency", PriceDropConfig{}, NewPrice(120, ""), NewPrice(95, "EUR"), true},
+		{"below min amount", PriceDropConfig{MinAmount: 10}, NewPrice(100, "EUR"), NewPrice(90.01, "EUR"), false},
+		{"at min amount", PriceDropConfig{MinAmount: 10}, NewPrice(100, "EUR"), NewPrice(90, "EUR"), true},
+		{"below min percent", PriceDropConfig{MinPercent: 20}, NewPrice(100, "EUR"), NewPrice(81, "EUR"), false},
+		{"at min percent", PriceDropConfig{MinPercent: 20}, NewPrice(100, "EUR"), NewPrice(80, "EUR"), true},
+		{"both thresholds", PriceDropConfig{MinAmount: 30, MinPercent: 20}, NewPrice(100, "EUR"), NewPrice(75, "EUR"), false},
+	}
+
+	for _, test := range tests {
+		if got := test.config.shouldNotify(test.oldPrice, test.newPrice); got != test.want {
+			t.Errorf("%s: shouldNotify(%s, %s) = %t, want %t", test.name, test.oldPrice, test.newPrice, got, test.want)
+		}
+	}
+}
+
+func TestRecordPrice(t *testing.T) {
+	state := &ProductStateNormal{Sku: "DV0833-104"}
+	now := time.Now()
+
+	prices := []float64{120, 120, 95, 110, 95}
+	for i, value := range prices {
+		state.recordPrice(NewPrice(value, "EUR"), now.Add(time.Duration(i)*time.Minute))
+	}
+	state.recordPrice(Price{}, now)
+
+	// Unchanged and unknown prices are not recorded
+	wantHistory := []int64{12000, 9500, 11000, 9500}
+	if len(state.PriceHistory) != len(wantHistory) {
+		t.Fatalf("got %d price points, want %d", len(state.PriceHistory), len(wantHistory))
+	}
+	for i, amount := range wantHistory {
+		if state.PriceHistory[i].Price.Amount != amount {
+			t.Errorf("price point %d: got %d, want %d", i, state.PriceHistory[i].Price.Amount, amount)
+		}
+	}
+
+	if state.LowestPrice == nil || state.LowestPrice.Amount != 9500 {
+		t.Errorf("got lowest price %v, want 95 EUR", state.LowestPrice)
+	}
+
+	// A new currency resets the all-time low
+	state.recordPrice(NewPrice(150, "USD"), now)
+	if state.LowestPrice.Currency != "USD" || state.LowestPrice.Amount != 15000 {
+		t.Errorf("got lowest price %s after the currency change, want 150 USD", state.LowestPrice)
+	}
+}
+
+func TestRecordPriceKeepsHistoryBounded(t *testing.T) {
+	state := &ProductStateNormal{}
+	now := time.Now()
+
+	for i := 1; i <= MAX_PRICE_HISTORY+10; i++ {
+		state.recordPrice(Price{Amount: int64(i), Currency: "EUR"}, now)
+	}
+
+	if len(state.PriceHistory) != MAX_PRICE_HISTORY {
+		t.Fatalf("got %d price points, want %d", len(state.PriceHistory), MAX_PRICE_HISTORY)
+	}
+	if oldest := state.PriceHistory[0].Price.Amount; oldest != 11 {
+		t.Errorf("got oldest price %d, want the oldest changes dropped", oldest)
+	}
+	if state.LowestPrice.Amount != 1 {
+		t.Errorf("got lowest price %d, want 1 from before the history was trimmed", state.LowestPrice.Amount)
+	}
+}
@@ -2,16 +2,39 @@ package main
 
 var productStates *ProductStates = nil
 
-func NormalSetState(skuStr string, state *ProductStateNormal) {
-	if _, i := NormalGetState(skuStr); i >= 0 {
-		productStates.Normal.ProductStates[i] = state
+// Take statesNormalMu and statesLoadMu before calling GetRegion!
+func (s *ProductStates) GetRegion(regionName string) *RegionProductStates {
+	if s.Regions == nil {
+		s.Regions = make(map[string]*RegionProductStates)
+	}
+
+	regionStates, ok := s.Regions[regionName]
+	if !ok || regionStates == nil {
+		regionStates = &RegionProductStates{
+			Normal: ProductStatesNormal{
+				ProductStates: []*ProductStateNormal{},
+			},
+			Load: ProductStatesLoad{
+				NotifiedProducts: []*ProductStateLoad{},
+				KeywordQueries:   []string{},
+			},
+		}
+		s.Regions[regionName] = regionStates
+	}
+
+	return regionStates
+}
+
+func (s *RegionProductStates) NormalSetState(skuStr string, state *ProductStateNormal) {
+	if _, i := s.NormalGetState(skuStr); i >= 0 {
+		s.Normal.ProductStates[i] = state
 	} else {
-		productStates.Normal.ProductStates = append(productStates.Normal.ProductStates, state)
+		s.Normal.ProductStates = append(s.Normal.ProductStates, state)
 	}
 }
 
-func NormalUnsetState(skuStr string) error {
-	_, i := NormalGetState(skuStr)
+func (s *RegionProductStates) NormalUnsetState(skuStr string) error {
+	_, i := s.NormalGetState(skuStr)
 	if i == -1 {
 		return &NotIncludedError{
 			statesType:    "normal",
@@ -20,13 +43,13 @@ func NormalUnsetState(skuStr string) error {
 		}
 	}
 
-	productStates.Normal.ProductStates = append(productStates.Normal.ProductStates[:i], productStates.Normal.ProductStates[i+1:]...)
+	s.Normal.ProductStates = append(s.Normal.ProductStates[:i], s.Normal.ProductStates[i+1:]...)
 
 	return nil
 }
 
-func NormalGetState(skuStr string) (*ProductStateNormal, int) {
-	for i, state := range productStates.Normal.ProductStates {
+func (s *RegionProductStates) NormalGetState(skuStr string) (*ProductStateNormal, int) {
+	for i, state := range s.Normal.ProductStates {
 		if state.Sku == skuStr {
 			return state, i
 		}
@@ -35,18 +58,18 @@ func NormalGetState(skuStr string) (*ProductStateNormal, int) {
 	return nil, -1
 }
 
-func NormalGetAllSkus() []string {
+func (s *RegionProductStates) NormalGetAllSkus() []string {
 	skus := []string{}
 
-	for _, state := range productStates.Normal.ProductStates {
+	for _, state := range s.Normal.ProductStates {
 		skus = append(skus, state.Sku)
 	}
 
 	return skus
 }
 
-func LoadAddKwd(kwdStr string) error {
-	if i := LoadGetIndexKwd(kwdStr); i >= 0 {
+func (s *RegionProductStates) LoadAddKwd(kwdStr string) error {
+	if i := s.LoadGetIndexKwd(kwdStr); i >= 0 {
 		return &AlreadyIncludedError{
 			statesType:    "load",
 			includedType:  "kwd",
@@ -54,13 +77,13 @@ func LoadAddKwd(kwdStr string) error {
 		}
 	}
 
-	productStates.Load.KeywordQueries = append(productStates.Load.KeywordQueries, kwdStr)
+	s.Load.KeywordQueries = append(s.Load.KeywordQueries, kwdStr)
 
 	return nil
 }
 
-func LoadRemoveKwd(kwdStr string) error {
-	i := LoadGetIndexKwd(kwdStr)
+func (s *RegionProductStates) LoadRemoveKwd(kwdStr string) error {
+	i := s.LoadGetIndexKwd(kwdStr)
 	if i == -1 {
 		return &NotIncludedError{
 			statesType:    "load",
@@ -69,13 +92,15 @@ func LoadRemoveKwd(kwdStr string) error {
 		}
 	}
 
-	productStates.Load.KeywordQueries = append(productStates.Load.KeywordQueries[:i], productStates.Load.KeywordQueries[i+1:]...)
+	s.Load.KeywordQueries = append(s.Load.KeywordQueries[:i], s.Load.KeywordQueries[i+1:]...)
+
+	delete(s.Load.KeywordMetadata, kwdStr)
 
 	return nil
 }
 
-func LoadGetIndexKwd(kwdStr string) int {
-	for i, sku := range productStates.Load.KeywordQueries {
+func (s *RegionProductStates) LoadGetIndexKwd(kwdStr string) int {
+	for i, sku := range s.Load.KeywordQueries {
 		if sku == kwdStr {
 			return i
 		}
@@ -84,10 +109,22 @@ func LoadGetIndexKwd(kwdStr string) int {
 	return -1
 }
 
-func LoadSetLastKnownPid(pid string) {
-	productStates.Load.LastKnownPid = pid
+func (s *RegionProductStates) LoadSetKwdMetadata(kwdStr string, metadata *WatchMetadata) {
+	if s.Load.KeywordMetadata == nil {
+		s.Load.KeywordMetadata = make(map[string]*WatchMetadata)
+	}
+
+	s.Load.KeywordMetadata[kwdStr] = metadata
+}
+
+func (s *RegionProductStates) LoadGetKwdMetadata(kwdStr string) *WatchMetadata {
+	return s.Load.KeywordMetadata[kwdStr]
+}
+
+func (s *RegionProductStates) LoadSetLastKnownPid(pid string) {
+	s.Load.LastKnownPid = pid
 }
 
-func LoadGetLastKnownPid(pid string) string {
-	return productStates.Load.LastKnownPid
+func (s *RegionProductStates) LoadGetLastKnownPid() string {
+	return s.Load.LastKnownPid
 }
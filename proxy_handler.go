@@ -20,6 +20,9 @@ type ProxyHandler struct {
 	proxyUsage    map[*proxy]int
 	cond          *sync.Cond
 	proxyfileName string
+
+	maxTasksPerProxy int
+	removeBadProxy   bool
 }
 
 func NewProxyHandler(proxies []*proxy) *ProxyHandler {
@@ -30,6 +33,9 @@ func NewProxyHandler(proxies []*proxy) *ProxyHandler {
 		proxies:       proxies,
 		proxyUsage:    make(map[*proxy]int),
 		proxyfileName: config.ProxyfileName,
+
+		maxTasksPerProxy: config.MaxTasksPerProxy,
+		removeBadProxy:   config.RemoveBadProxy,
 	}
 
 	configMu.RUnlock()
@@ -48,13 +54,6 @@ func NewProxyHandler(proxies []*proxy) *ProxyHandler {
 func (h *ProxyHandler) GetProxy() *proxy {
 	h.mu.Lock()
 	defer h.mu.Unlock()
-	configMu.RLock()
-	defer configMu.RUnlock()
-
-	// Check for new proxyfile
-	if config.ProxyfileName != h.proxyfileName {
-		h.updateProxies()
-	}
 
 	if len(h.proxies) == 0 {
 		return nil
@@ -62,7 +61,7 @@ func (h *ProxyHandler) GetProxy() *proxy {
 
 	for {
 		for _, p := range h.proxies {
-			if h.proxyUsage[p] < config.MaxTasksPerProxy {
+			if h.proxyUsage[p] < h.maxTasksPerProxy {
 				h.proxyUsage[p]++
 
 				// Append proxy to end of slice to reduce its priority
@@ -101,9 +100,8 @@ func (h *ProxyHandler) ReportBadProxy(p *proxy) {
 
 	h.mu.Lock()
 	defer h.mu.Unlock()
-	configMu.RLock()
-	defer configMu.RUnlock()
-	if !config.RemoveBadProxy {
+
+	if !h.removeBadProxy {
 		return
 	}
 
@@ -130,10 +128,48 @@ func (h *ProxyHandler) ReportBadProxy(p *proxy) {
 	}
 }
 
-// Take locks before calling updateProxies! [configMu RLock, h.mu Lock]
-func (h *ProxyHandler) updateProxies() {
+func (h *ProxyHandler) GetProxyPool() ApiProxyPool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	pool := ApiProxyPool{
+		Proxyfile: h.proxyfileName,
+		Proxies:   []ProxyInfo{},
+	}
+
+	for _, p := range h.proxies {
+		pool.Proxies = append(pool.Proxies, ProxyInfo{
+			Host:  p.host,
+			Port:  p.port,
+			InUse: h.proxyUsage[p],
+		})
+	}
+
+	return pool
+}
+
+// Subscribed to config changes in main
+func (h *ProxyHandler) onConfigChange(diff *ConfigDiff) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if diff.ProxyLimits {
+		h.maxTasksPerProxy = diff.New.MaxTasksPerProxy
+		h.removeBadProxy = diff.New.RemoveBadProxy
+
+		// A higher limit can free up proxies for waiting tasks
+		h.cond.Broadcast()
+	}
+
+	if diff.Proxyfile {
+		h.updateProxies(diff.New.ProxyfileName)
+	}
+}
+
+// Take lock before calling updateProxies! [h.mu]
+func (h *ProxyHandler) updateProxies(proxyfileName string) {
 	filenameOld := h.proxyfileName
-	filenameNew := config.ProxyfileName
+	filenameNew := proxyfileName
 	if filenameOld == "" {
 		filenameOld = "localhost"
 	}
@@ -143,9 +179,7 @@ func (h *ProxyHandler) updateProxies() {
 
 	h.logger.Yellow(fmt.Sprintf("Reloading proxyfile (%s -> %s)", filenameOld, filenameNew))
 
-	h.proxyfileName = config.ProxyfileName
-
-	newProxies, err := readProxyfile(h.proxyfileName)
+	newProxies, err := readProxyfile(proxyfileName)
 	if err != nil {
 		h.logger.Red(fmt.Sprintf("Reload proxyfile: %v (Sticking to old proxy list)", err))
 		return
@@ -153,7 +187,7 @@ func (h *ProxyHandler) updateProxies() {
 
 	// Update the proxy list
 	h.proxies = newProxies
-	h.proxyfileName = config.ProxyfileName
+	h.proxyfileName = proxyfileName
 	h.proxyUsage = make(map[*proxy]int)
 
 	h.shuffleProxies()
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type Region struct {
+	name            string
+	normalTaskGroup *NormalTaskGroup
+	loadTaskGroup   *LoadTaskGroup
+}
+
+var regions []*Region = []*Region{}
+
+// Returns the region with the given name. An empty name selects the first configured region
+func getRegion(regionName string) (*Region, error) {
+	regionName = strings.ToUpper(strings.TrimSpace(regionName))
+
+	if regionName == "" && len(regions) > 0 {
+		return regions[0], nil
+	}
+
+	for _, region := range regions {
+		if region.name == regionName {
+			return region, nil
+		}
+	}
+
+	return nil, &RegionNotFoundError{
+		regionName: regionName,
+	}
+}
+
+// Take configMu RLock before calling getRegionConfigs!
+func getRegionConfigs() []RegionConfig {
+	if len(config.Regions) == 0 {
+		return []RegionConfig{defaultRegionConfig}
+	}
+
+	return config.Regions
+}
+
+// Take configMu RLock before calling getRegionConfig!
+func getRegionConfig(regionName string) (RegionConfig, bool) {
+	for _, regionConfig := range getRegionConfigs() {
+		if strings.EqualFold(regionConfig.Name, regionName) {
+			return regionConfig, true
+		}
+	}
+
+	return RegionConfig{}, false
+}
+
+// Take configMu RLock before calling normalWebhookUrls! Falls back to the global webhook urls
+func normalWebhookUrls(regionName string) []string {
+	if regionConfig, ok := getRegionConfig(regionName); ok && len(regionConfig.NormalWebhookUrls) > 0 {
+		return regionConfig.NormalWebhookUrls
+	}
+
+	return config.NormalTask.WebhookUrls
+}
+
+// Take configMu RLock before calling loadWebhookUrls! Falls back to the global webhook urls
+func loadWebhookUrls(regionName string) []string {
+	if regionConfig, ok := getRegionConfig(regionName); ok && len(regionConfig.LoadWebhookUrls) > 0 {
+		return regionConfig.LoadWebhookUrls
+	}
+
+	return config.LoadTask.WebhookUrls
+}
+
+// Returns nil if the watch entry has no webhook override
+func metadataWebhookUrls(metadata *WatchMetadata) []string {
+	if metadata == nil || len(metadata.WebhookUrls) == 0 {
+		return nil
+	}
+
+	return metadata.WebhookUrls
+}
+
+// Take configMu RLock, statesNormalMu and statesLoadMu before calling createRegion!
+func createRegion(regionConfig RegionConfig) (*Region, error) {
+	regionName := strings.ToUpper(strings.TrimSpace(regionConfig.Name))
+	if regionName == "" {
+		return nil, errors.New("region name empty")
+	}
+	if _, err := getRegion(regionName); err == nil {
+		return nil, fmt.Errorf("region %s configured more than once", regionName)
+	}
+
+	backend, err := NewStoreBackend(config.Backend, regionConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error creating store backend: %v", err)
+	}
+
+	regionStates := productStates.GetRegion(regionName)
+
+	normalTaskGroup, err := NewNormalTaskGroup(regionName, regionStates, backend, proxyHandler, webhookHandler, regionStates.NormalGetAllSkus())
+	if err != nil {
+		return nil, fmt.Errorf("error creating normal task group: %v", err)
+	}
+
+	loadTaskGroup, err := NewLoadTaskGroup(regionName, regionStates, backend, proxyHandler, webhookHandler, regionStates.Load.LastKnownPid, regionStates.Load.KeywordQueries)
+	if err != nil {
+		return nil, fmt.Errorf("error creating load task group: %v", err)
+	}
+
+	// Associate task groups with one another
+	normalTaskGroup.LinkToLoadTaskGroup(loadTaskGroup)
+	loadTaskGroup.LinkToNormalTaskGroup(normalTaskGroup)
+
+	subscribeConfig(normalTaskGroup.onConfigChange)
+	subscribeConfig(loadTaskGroup.onConfigChange)
+
+	// Create normal tasks
+	for i := range config.NormalTask.NumTasks {
+		taskName := fmt.Sprintf("%s NORMAL: %02d", regionName, i)
+		normalTask, err := NewNormalTask(taskName, normalTaskGroup)
+		if err != nil {
+			return nil, fmt.Errorf("error creating initial normal task %s: %v", taskName, err)
+		}
+
+		err = normalTaskGroup.AddTask(normalTask.BaseTask)
+		if err != nil {
+			return nil, fmt.Errorf("error adding normal task %s to task group: %v", taskName, err)
+		}
+	}
+
+	// Create load tasks
+	for i := range config.LoadTask.NumTasks {
+		taskName := fmt.Sprintf("%s LOAD: %02d", regionName, i)
+		loadTask, err := NewLoadTask(taskName, loadTaskGroup)
+		if err != nil {
+			return nil, fmt.Errorf("error creating initial load task %s: %v", taskName, err)
+		}
+
+		err = loadTaskGroup.AddTask(loadTask.BaseTask)
+		if err != nil {
+			return nil, fmt.Errorf("error adding load task %s to task group: %v", taskName, err)
+		}
+	}
+
+	return &Region{
+		name:            regionName,
+		normalTaskGroup: normalTaskGroup,
+		loadTaskGroup:   loadTaskGroup,
+	}, nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// While replaying, product states are not written and notifications are only logged
+var replayMode bool = false
+
+var replayLogger *Logger = NewLogger("REPLAY")
+
+// Feeds the responses of a capture folder back through the task groups in the order they were fetched.
+// Starts from the product states snapshot taken when the capture began
+func runReplay(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: replay <capture folder>")
+	}
+	folder := args[0]
+
+	replayMode = true
+
+	err := readConfig()
+	if err != nil {
+		return err
+	}
+
+	configMu.Lock()
+	config.NormalTask.NumTasks = 0
+	config.LoadTask.NumTasks = 0
+	configMu.Unlock()
+
+	statesBytes, err := os.ReadFile(filepath.Join(folder, captureStatesFilename))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error reading product states snapshot: %v", err)
+	}
+	if err == nil {
+		productStates, err = parseProductStates(statesBytes)
+		if err != nil {
+			return err
+		}
+	}
+	if productStates == nil {
+		productStates = &ProductStates{
+			Regions: make(map[string]*RegionProductStates),
+		}
+	}
+
+	formatProductStates()
+
+	proxyHandler = NewProxyHandler([]*proxy{})
+	webhookHandler = NewWebhookHandler()
+
+	configMu.RLock()
+	statesNormalMu.Lock()
+	statesLoadMu.Lock()
+
+	for _, regionConfig := range getRegionConfigs() {
+		region, err := createRegion(regionConfig)
+		if err != nil {
+			statesLoadMu.Unlock()
+			statesNormalMu.Unlock()
+			configMu.RUnlock()
+
+			return fmt.Errorf("error creating region %s: %v", regionConfig.Name, err)
+		}
+
+		regions = append(regions, region)
+	}
+
+	statesLoadMu.Unlock()
+	statesNormalMu.Unlock()
+	configMu.RUnlock()
+
+	replayed, err := replayCaptureFolder(folder)
+	if err != nil {
+		return err
+	}
+
+	replayLogger.White(fmt.Sprintf("Replayed %d responses", replayed))
+
+	return nil
+}
+
+// Replays the captured responses into the existing regions and returns how many were replayed
+func replayCaptureFolder(folder string) (int, error) {
+	// Sequence numbers are zero padded, so the directory order is the capture order
+	dirEntries, err := os.ReadDir(folder)
+	if err != nil {
+		return 0, fmt.Errorf("error reading capture folder: %v", err)
+	}
+
+	replayer := &captureReplayer{
+		newArrivals: make(map[*Region][]NewArrival),
+	}
+
+	replayed := 0
+	for _, dirEntry := range dirEntries {
+		if dirEntry.IsDir() || dirEntry.Name() == captureStatesFilename || !strings.HasSuffix(dirEntry.Name(), ".json") {
+			continue
+		}
+
+		bytes, err := os.ReadFile(filepath.Join(folder, dirEntry.Name()))
+		if err != nil {
+			return replayed, fmt.Errorf("error reading \"%s\": %v", dirEntry.Name(), err)
+		}
+
+		var entry CaptureEntry
+
+		err = json.Unmarshal(bytes, &entry)
+		if err != nil {
+			return replayed, fmt.Errorf("error unmarshalling \"%s\": %v", dirEntry.Name(), err)
+		}
+
+		replayLogger.White(fmt.Sprintf("#%d %s %s (captured %s)", entry.Seq, entry.Region, entry.Kind, entry.Time.Format("02.01.06 15:04:05.000")))
+
+		err = replayer.replayEntry(entry)
+		if err != nil {
+			replayLogger.Red(fmt.Sprintf("#%d: %v", entry.Seq, err))
+			continue
+		}
+
+		replayed += 1
+	}
+
+	replayer.flushAll()
+
+	return replayed, nil
+}
+
+// The load task requests further new arrivals pages before it handles them, the replay collects them the same way
+type captureReplayer struct {
+	newArrivals map[*Region][]NewArrival
+}
+
+func (r *captureReplayer) replayEntry(entry CaptureEntry) error {
+	region, err := getRegion(entry.Region)
+	if err != nil {
+		return err
+	}
+
+	backend := region.normalTaskGroup.backend
+
+	if entry.Kind == CAPTURE_KIND_NEW_ARRIVALS && entry.Page > 0 {
+		if _, ok := r.newArrivals[region]; !ok {
+			return fmt.Errorf("new arrivals page %d without the first page", entry.Page)
+		}
+
+		newArrivals, err := backend.ParseNewArrivals(entry.Response)
+		if err != nil {
+			return err
+		}
+
+		r.newArrivals[region] = append(r.newArrivals[region], newArrivals...)
+		return nil
+	}
+
+	r.flush(region)
+
+	switch entry.Kind {
+	case CAPTURE_KIND_NEW_ARRIVALS:
+		newArrivals, err := backend.ParseNewArrivals(entry.Response)
+		if err != nil {
+			return err
+		}
+
+		r.newArrivals[region] = newArrivals
+	case CAPTURE_KIND_NORMAL:
+		products, err := backend.ParseProductsBySku(entry.Response)
+		if err != nil {
+			return err
+		}
+
+		region.normalTaskGroup.checkProducts(products, entry.Skus)
+	case CAPTURE_KIND_LOAD_CHECK:
+		products, err := backend.ParseProductsBySku(entry.Response)
+		if err != nil {
+			return err
+		}
+
+		region.loadTaskGroup.handleSkuCheckResponse(products)
+	default:
+		return fmt.Errorf("unexpected capture kind: %s", entry.Kind)
+	}
+
+	return nil
+}
+
+// Hands the collected new arrivals pages of the region to its load task group
+func (r *captureReplayer) flush(region *Region) {
+	newArrivals, ok := r.newArrivals[region]
+	if !ok {
+		return
+	}
+	delete(r.newArrivals, region)
+
+	region.loadTaskGroup.handleNewArrivals(newArrivals)
+}
+
+func (r *captureReplayer) flushAll() {
+	for region := range r.newArrivals {
+		r.flush(region)
+	}
+}
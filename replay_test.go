@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// Captures a new arrivals page and a load check from the mock server, then replays them into fresh states
+func TestReplayReproducesKeywordHit(t *testing.T) {
+	region := setupTestRegion(t)
+	region.mock.newArrivals.reset([]MockStep{{File: "new_arrivals.json"}})
+	region.mock.productsBySku.reset([]MockStep{{File: "products_by_sku.json"}})
+
+	folder := t.TempDir()
+	captureRecorder = &CaptureRecorder{
+		folder: folder,
+		logger: NewLogger("CAPTURE"),
+	}
+	t.Cleanup(func() {
+		captureRecorder = nil
+	})
+
+	task, err := NewLoadTask("EU LOAD: 00", region.loadTaskGroup)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = task.getNewArrivals(0)
+	if err != nil {
+		t.Fatalf("error requesting new arrivals: %v", err)
+	}
+	_, err = task.getProductsBySku([]string{"DV0833-104", "FQ8138-002"})
+	if err != nil {
+		t.Fatalf("error requesting products: %v", err)
+	}
+
+	captureRecorder = nil
+	region.drain()
+
+	dirEntries, err := os.ReadDir(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirEntries) != 2 {
+		t.Fatalf("got %d capture files, want 2", len(dirEntries))
+	}
+
+	replayRegion := setupTestRegion(t)
+	replayRegion.loadTaskGroup.AddKwdQuery("+dunk", nil)
+
+	replayMode = true
+	t.Cleanup(func() {
+		replayMode = false
+	})
+
+	replayed, err := replayCaptureFolder(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed != 2 {
+		t.Errorf("replayed %d responses, want 2", replayed)
+	}
+
+	replayRegion.drain()
+
+	if pid := replayRegion.loadTaskGroup.getLastKnownPid(); pid == "" {
+		t.Error("new arrivals were not replayed")
+	}
+
+	// Notifications are only logged while replaying, the notified states show the hit
+	notified := make(map[string][]string)
+	for _, notifiedProduct := range replayRegion.loadTaskGroup.states.Load.NotifiedProducts {
+		notified[notifiedProduct.Sku] = notifiedProduct.MatchingKeywordQueries
+	}
+
+	if queries := notified["DV0833-104"]; len(queries) != 1 || queries[0] != "+dunk" {
+		t.Errorf("got matched queries %v for DV0833-104, want [+dunk]", queries)
+	}
+	if _, ok := notified["FQ8138-002"]; ok {
+		t.Error("FQ8138-002 notified without a matching keyword query")
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var kidsSizePattern *regexp.Regexp = regexp.MustCompile(`(?i)(\b(GS|PS|TD|KIDS?|JUNIOR|YOUTH)\b|\d\s?[CY]\b)`)
+
+// Restrict which new sizes of a restock are notified. Set per watch entry or per webhook url / sink name
+type RestockFilter struct {
+	Sizes      []string `json:"sizes,omitempty"`    // Exact sizes like "EU 42.5" or ranges like "EU 42-45" or "EU 42 – 45"
+	MinStock   int      `json:"minStock,omitempty"` // Minimum AmountInStock of a size
+	MaxPrice   float64  `json:"maxPrice,omitempty"` // Highest price that is still notified. 0 disables
+	IgnoreKids bool     `json:"ignoreKids,omitempty"`
+}
+
+// Returns a copy of the restock event that only contains the matching new sizes.
+// Returns false if no size matches or the price is too high
+func (f *RestockFilter) Apply(event *Event) (*Event, bool) {
+	if f == nil || event.Kind != EVENT_RESTOCK {
+		return event, true
+	}
+
+	if f.MaxPrice > 0 && event.Product.Price.Amount > NewPrice(f.MaxPrice, "").Amount {
+		return nil, false
+	}
+
+	matchingSizes := []AvailableSize{}
+	for _, newSize := range event.Diff.NewSizes {
+		if f.matchesSize(newSize) {
+			matchingSizes = append(matchingSizes, newSize)
+		}
+	}
+
+	if len(matchingSizes) == 0 {
+		return nil, false
+	}
+
+	filtered := *event
+	filtered.Diff.NewSizes = matchingSizes
+
+	return &filtered, true
+}
+
+func (f *RestockFilter) matchesSize(size AvailableSize) bool {
+	if size.AmountInStock < f.MinStock {
+		return false
+	}
+	if f.IgnoreKids && isKidsSize(size.Name) {
+		return false
+	}
+	if len(f.Sizes) == 0 {
+		return true
+	}
+
+	for _, rule := range f.Sizes {
+		if matchSizeRule(rule, size.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+func isKidsSize(sizeName string) bool {
+	return kidsSizePattern.MatchString(sizeName)
+}
+
+// Matches exact sizes ignoring case and spacing, and numeric ranges within the same size system
+func matchSizeRule(rule string, sizeName string) bool {
+	if normalizeSizeName(rule) == normalizeSizeName(sizeName) {
+		return true
+	}
+
+	// Ranges copied from size charts often use an en dash
+	rule = strings.ReplaceAll(rule, "–", "-")
+
+	i := strings.LastIndex(rule, "-")
+	if i < 0 {
+		return false
+	}
+
+	rulePrefix, lowStr := splitSize(rule[:i])
+	_, highStr := splitSize(rule[i+1:])
+	sizePrefix, sizeStr := splitSize(sizeName)
+
+	if rulePrefix != "" && !strings.EqualFold(rulePrefix, sizePrefix) {
+		return false
+	}
+
+	low, err := strconv.ParseFloat(lowStr, 64)
+	if err != nil {
+		return false
+	}
+	high, err := strconv.ParseFloat(highStr, 64)
+	if err != nil {
+		return false
+	}
+	sizeNum, err := strconv.ParseFloat(sizeStr, 64)
+	if err != nil {
+		return false
+	}
+
+	return sizeNum >= low && sizeNum <= high
+}
+
+func normalizeSizeName(sizeName string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(strings.ReplaceAll(sizeName, ",", ".")), ""))
+}
+
+// Returns nil if the watch entry has no filter
+func metadataFilter(metadata *WatchMetadata) *RestockFilter {
+	if metadata == nil {
+		return nil
+	}
+	return metadata.Filter
+}
+
+// Take configMu RLock before calling applySinkFilters! Splits the event by the filters of the sink and its webhook urls
+func applySinkFilters(sinkName string, event *Event) []*Event {
+	filters := config.Notifiers.Filters
+
+	if event.Kind != EVENT_RESTOCK || len(filters) == 0 {
+		return []*Event{event}
+	}
+
+	if sinkName != SINK_DISCORD {
+		filter, ok := filters[sinkName]
+		if !ok {
+			return []*Event{event}
+		}
+
+		filtered, ok := filter.Apply(event)
+		if !ok {
+			return []*Event{}
+		}
+		return []*Event{filtered}
+	}
+
+	// A filter for the discord sink applies to every webhook url, before the filters of the urls
+	if filter, ok := filters[SINK_DISCORD]; ok {
+		filtered, ok := filter.Apply(event)
+		if !ok {
+			return []*Event{}
+		}
+		event = filtered
+	}
+
+	events := []*Event{}
+	unfilteredUrls := []string{}
+
+	for _, webhookUrl := range event.WebhookUrls {
+		filter, ok := filters[webhookUrl]
+		if !ok {
+			unfilteredUrls = append(unfilteredUrls, webhookUrl)
+			continue
+		}
+
+		filtered, ok := filter.Apply(event)
+		if !ok {
+			continue
+		}
+
+		// Apply returns the event itself for unchanged events
+		if filtered == event {
+			copied := *event
+			filtered = &copied
+		}
+		filtered.WebhookUrls = []string{webhookUrl}
+
+		events = append(events, filtered)
+	}
+
+	if len(unfilteredUrls) > 0 {
+		unfiltered := *event
+		unfiltered.WebhookUrls = unfilteredUrls
+
+		events = append([]*Event{&unfiltered}, events...)
+	}
+
+	return events
+}
+
+func (f *RestockFilter) String() string {
+	parts := []string{}
+	if len(f.Sizes) > 0 {
+		parts = append(parts, fmt.Sprintf("sizes %s", strings.Join(f.Sizes, ", ")))
+	}
+	if f.MinStock > 0 {
+		parts = append(parts, fmt.Sprintf("stock >= %d", f.MinStock))
+	}
+	if f.MaxPrice > 0 {
+		parts = append(parts, fmt.Sprintf("price <= %g", f.MaxPrice))
+	}
+	if f.IgnoreKids {
+		parts = append(parts, "no kids sizes")
+	}
+	return strings.Join(parts, ", ")
+}
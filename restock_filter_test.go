@@ -0,0 +1,133 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestMatchSizeRule(t *testing.T) {
+	tests := []struct {
+		rule     string
+		sizeName string
+		want     bool
+	}{
+		{"EU 42.5", "eu 42,5", true},
+		{"EU 42.5", "EU 42", false},
+		{"EU 42-45", "EU 44", true},
+		{"EU 42-45", "EU 45", true},
+		{"EU 42-45", "EU 45.5", false},
+		{"EU 42-45", "US 44", false},
+		{"42-45", "UK 43", true},
+		{"EU 42–45", "EU 43", true},
+		{"EU 42 – 45", "EU 42.5", true},
+		{"EU 42 – 45", "EU 46", false},
+		{"EU 42 - 45", "EU 45", true},
+	}
+
+	for _, test := range tests {
+		if got := matchSizeRule(test.rule, test.sizeName); got != test.want {
+			t.Errorf("matchSizeRule(%q, %q) = %t, want %t", test.rule, test.sizeName, got, test.want)
+		}
+	}
+}
+
+func TestIsKidsSize(t *testing.T) {
+	kidsSizes := []string{"EU 35.5 GS", "US 3Y", "US 10C", "Kids 30", "PS 28"}
+	adultSizes := []string{"EU 42", "US 9.5", "UK 8", "M"}
+
+	for _, size := range kidsSizes {
+		if !isKidsSize(size) {
+			t.Errorf("%q not detected as kids size", size)
+		}
+	}
+	for _, size := range adultSizes {
+		if isKidsSize(size) {
+			t.Errorf("%q detected as kids size", size)
+		}
+	}
+}
+
+func TestRestockFilterApply(t *testing.T) {
+	event := testRestockEvent("DV0833-104")
+	event.Product.Price = NewPrice(120, "EUR")
+	event.Diff.NewSizes = []AvailableSize{
+		{Name: "EU 41", AmountInStock: 5},
+		{Name: "EU 43", AmountInStock: 1},
+		{Name: "EU 44", AmountInStock: 3},
+		{Name: "EU 36 GS", AmountInStock: 9},
+	}
+
+	filter := &RestockFilter{Sizes: []string{"EU 42–45", "EU 36 GS"}, MinStock: 2, IgnoreKids: true}
+
+	filtered, ok := filter.Apply(event)
+	if !ok {
+		t.Fatal("event filtered out")
+	}
+	if len(filtered.Diff.NewSizes) != 1 || filtered.Diff.NewSizes[0].Name != "EU 44" {
+		t.Errorf("got sizes %v, want [EU 44]", filtered.Diff.NewSizes)
+	}
+	if len(event.Diff.NewSizes) != 4 {
+		t.Errorf("filter changed the sizes of the original event")
+	}
+
+	if _, ok := (&RestockFilter{MaxPrice: 100}).Apply(event); ok {
+		t.Errorf("event above the max price not filtered out")
+	}
+	if _, ok := (&RestockFilter{Sizes: []string{"EU 47"}}).Apply(event); ok {
+		t.Errorf("event without matching sizes not filtered out")
+	}
+}
+
+func TestApplySinkFiltersDiscord(t *testing.T) {
+	oldConfig := config
+	t.Cleanup(func() {
+		config = oldConfig
+	})
+
+	filteredUrl := "https://discord.com/api/webhooks/1/filtered"
+	plainUrl := "https://discord.com/api/webhooks/2/plain"
+
+	testConfig := defaultConfig
+	testConfig.Notifiers.Filters = map[string]*RestockFilter{
+		SINK_DISCORD: {Sizes: []string{"EU 42-45"}},
+		filteredUrl:  {MinStock: 2},
+	}
+	config = &testConfig
+
+	event := testRestockEvent("DV0833-104")
+	event.WebhookUrls = []string{filteredUrl, plainUrl}
+	event.Diff.NewSizes = []AvailableSize{
+		{Name: "EU 38", AmountInStock: 5},
+		{Name: "EU 43", AmountInStock: 1},
+		{Name: "EU 44", AmountInStock: 3},
+	}
+
+	events := applySinkFilters(SINK_DISCORD, event)
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want one per webhook url", len(events))
+	}
+
+	wantSizes := map[string][]string{
+		plainUrl:    {"EU 43", "EU 44"},
+		filteredUrl: {"EU 44"},
+	}
+	for _, sinkEvent := range events {
+		if len(sinkEvent.WebhookUrls) != 1 {
+			t.Fatalf("got webhook urls %v, want one", sinkEvent.WebhookUrls)
+		}
+
+		sizes := []string{}
+		for _, size := range sinkEvent.Diff.NewSizes {
+			sizes = append(sizes, size.Name)
+		}
+		if want := wantSizes[sinkEvent.WebhookUrls[0]]; !slices.Equal(sizes, want) {
+			t.Errorf("%s: got sizes %v, want %v", sinkEvent.WebhookUrls[0], sizes, want)
+		}
+	}
+
+	// Nothing within the sizes of the discord filter
+	event.Diff.NewSizes = []AvailableSize{{Name: "EU 38", AmountInStock: 5}}
+	if events := applySinkFilters(SINK_DISCORD, event); len(events) != 0 {
+		t.Errorf("got %d events, want the event filtered out for every url", len(events))
+	}
+}
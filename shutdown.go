@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	EXIT_OK                  = 0
+	EXIT_INIT_ERROR          = 1
+	EXIT_SHUTDOWN_INCOMPLETE = 2 // A deadline passed, pending notifications are replayed from the outbox on the next start
+	EXIT_SHUTDOWN_FORCED     = 3 // A second signal arrived during the shutdown
+)
+
+const (
+	SHUTDOWN_CONTROL_API_TIMEOUT = 5 * time.Second
+	SHUTDOWN_WEBSOCKET_TIMEOUT   = 3 * time.Second
+	SHUTDOWN_TASKS_TIMEOUT       = 15 * time.Second
+	SHUTDOWN_WEBHOOK_TIMEOUT     = 30 * time.Second
+)
+
+// Stops accepting commands and config changes, stops the tasks, then drains the notifications and saves the
+// product states. Returns the exit code
+func shutdown(controlApiServer *http.Server, websocketEnabled bool, stopConfigWatcher func()) int {
+	exitCode := EXIT_OK
+
+	if controlApiServer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_CONTROL_API_TIMEOUT)
+		err := controlApiServer.Shutdown(ctx)
+		cancel()
+
+		if err != nil {
+			mainLogger.Red(fmt.Sprintf("Shutdown: Error stopping control API: %v", err))
+		}
+	}
+
+	if websocketEnabled {
+		closeWebsocketConnection(SHUTDOWN_WEBSOCKET_TIMEOUT)
+	}
+
+	// A reload could scale the task groups up again after they were stopped
+	if stopConfigWatcher != nil {
+		stopConfigWatcher()
+	}
+
+	for _, region := range regions {
+		region.normalTaskGroup.StopAllTasks()
+		region.loadTaskGroup.StopAllTasks()
+	}
+
+	mainLogger.White("Shutdown: Waiting for requests in flight ...")
+
+	if !runWithTimeout(tasksWg.Wait, SHUTDOWN_TASKS_TIMEOUT) {
+		mainLogger.Red(fmt.Sprintf("Shutdown: Requests still running after %s", SHUTDOWN_TASKS_TIMEOUT))
+		exitCode = EXIT_SHUTDOWN_INCOMPLETE
+	}
+
+	mainLogger.White("Shutdown: Sending queued notifications ...")
+
+	if !runWithTimeout(webhookHandler.Stop, SHUTDOWN_WEBHOOK_TIMEOUT) {
+		mainLogger.Red(fmt.Sprintf("Shutdown: Notifications still queued after %s, they are sent on the next start", SHUTDOWN_WEBHOOK_TIMEOUT))
+		exitCode = EXIT_SHUTDOWN_INCOMPLETE
+	}
+
+	statePersister.Stop()
+
+	mainLogger.White("Shutdown: Product states saved")
+
+	return exitCode
+}
+
+// Returns false if fn did not return within the timeout. fn keeps running in the background
+func runWithTimeout(fn func(), timeout time.Duration) bool {
+	done := make(chan struct{})
+
+	go func() {
+		fn()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
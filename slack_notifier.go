@@ -0,0 +1,42 @@
+package main
+
+import "fmt"
+
+type SlackNotifier struct {
+	webhookUrls []string
+}
+
+// Take configMu RLock before calling NewSlackNotifier!
+func NewSlackNotifier() *SlackNotifier {
+	return &SlackNotifier{
+		webhookUrls: config.Notifiers.Slack.WebhookUrls,
+	}
+}
+
+func (n *SlackNotifier) Name() string {
+	return SINK_SLACK
+}
+
+// Posts to Slack incoming webhooks. Links use the Slack mrkdwn <url|text> syntax
+func (n *SlackNotifier) Notify(event *Event) error {
+	text, ok := notificationTemplates.Render(SINK_SLACK, event)
+	if !ok {
+		text = formatEventText(event, func(text string, url string) string {
+			return fmt.Sprintf("<%s|%s>", url, text)
+		})
+	}
+
+	payload := map[string]any{
+		"text": text,
+	}
+
+	deliveryErr := &DeliveryError{}
+	for _, webhookUrl := range n.webhookUrls {
+		err := postJson(webhookUrl, payload)
+		if err != nil {
+			deliveryErr.add(webhookUrl, err)
+		}
+	}
+
+	return deliveryErr.orNil()
+}
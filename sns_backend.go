@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	http "github.com/bogdanfinn/fhttp"
+)
+
+const (
+	NEW_ARRIVALS_BASE_URL string = "https://core.dxpapi.com"
+	PRODUCTS_BASE_URL     string = "https://app-api.sneakersnstuffapp.com"
+	PRODUCTS_PATH         string = "/graphql"
+	NEW_ARRIVALS_PATH     string = "/api/v1/core/?request_type=search&fl=pid%2Cprice%2Ctitle%2Cbrand%2Cthumb_image%2Cdescription%2Csku%2Cavailability%2Ccategories_path%2Csub_brand%2Cbrand_color%2Ccolor%2Cproduct_level%2Cstyle%2Cgender%2Cseason%2Coriginal_price_usd%2Coriginal_price_eur%2Coriginal_price_gbp%2Coriginal_price_dkk%2Coriginal_price_sek%2Csignup_end_date%2Craffle_delayed%2Cprice_usd%2Cprice_eur%2Cprice_gbp%2Cprice_dkk%2Cprice_sek%2Cproduct_type%2Cproduct_group%2Cis_raffle%2Cdiscount_usd%2Cdiscount_eur%2Cdiscount_gbp%2Cdiscount_dkk%2Cdiscount_sek%2Ccustom_tag%2Cmarket_reference_eu%2Cmarket_reference_uk%2Cmarket_reference_us%2Csize_clothing_US%2Csize_clothing_EU%2Csize_clothing_UK%2Csize_clothing_JP%2Csize_shoes_US%2Csize_shoes_EU%2Csize_shoes_UK%2Csize_shoes_JP%2Cpublishing_date%2Craffle_finalized%2Cis_in_stock%2Ceu_category_ids%2Cuk_category_ids%2Cus_category_ids%2Crelease_date_eu%2Crelease_date_uk%2Crelease_date_us&account_id=7488&view_id={viewId}&domain_key={domainKey}&q=31d26a6487e08357bd771619e894b0c6&search_type=category&url=https%3A%2F%2Fsneakersnstuff.com"
+	PRODUCTS_BY_SKU_QUERY string = "\n    query FindBySkus($skus: [String!]!, $currencyCode: currencyCode!, $includeTax: Boolean!) {\n      site {\n        search {\n          searchProducts(filters: { productAttributes: [{ attribute: \"sku\", values: $skus }] }) {\n            products(first: 50) {\n              ...ProductListFragment\n              __typename\n            }\n            __typename\n          }\n          __typename\n        }\n        __typename\n      }\n    }\n    \n    fragment CustomFieldsFragment on CustomFieldConnection {\n      edges {\n        node {\n          entityId\n          name\n          value\n          __typename\n        }\n        __typename\n      }\n      __typename\n    }\n    \n    fragment MetafieldFragment on Metafields {\n      id\n      key\n      value\n      __typename\n    }\n    \n    fragment MetafieldsFragment on MetafieldConnection {\n      edges {\n        node {\n          ...MetafieldFragment\n          __typename\n        }\n        __typename\n      }\n      __typename\n    }\n    \n    fragment ListProductFragment on Product {\n      id\n      entityId\n      name\n      sku\n      path\n      defaultImage {\n        url(height: 250, width: 250)\n        __typename\n      }\n      brand {\n        name\n        __typename\n      }\n      images(first: 50) {\n        edges {\n          node {\n            url(height: 2000, width: 2000)\n            __typename\n          }\n          __typename\n        }\n        __typename\n      }\n      customFields(first: 50) {\n        ...CustomFieldsFragment\n        __typename\n      }\n      metafields(namespace: \"sns_metafields\", first: 50) {\n        ...MetafieldsFragment\n        __typename\n      }\n      availabilityV2 {\n        status\n        description\n        ... on ProductPreOrder {\n          willBeReleasedAt {\n            utc\n            __typename\n          }\n          __typename\n        }\n        __typename\n      }\n      categories(first: 50) {\n        edges {\n          node {\n            metafields(namespace: \"sns_metafields\") {\n              edges {\n                node {\n                  entityId\n                  key\n                  value\n                  __typename\n                }\n                __typename\n              }\n              __typename\n            }\n            id\n            entityId\n            name\n            __typename\n          }\n          __typename\n        }\n        __typename\n      }\n      prices(includeTax: $includeTax, currencyCode: $currencyCode) {\n        price {\n          currencyCode\n          value\n          __typename\n        }\n        basePrice {\n          currencyCode\n          value\n          __typename\n        }\n        salePrice {\n          currencyCode\n          value\n          __typename\n        }\n        priceRange {\n          min {\n            currencyCode\n            value\n            __typename\n          }\n          max {\n            currencyCode\n            value\n            __typename\n          }\n          __typename\n        }\n        __typename\n      }\n      inventory {\n        isInStock\n        __typename\n      }\n      description\n      variants(first: 50) {\n        edges {\n          node {\n            entityId\n            id\n            sku\n            prices(currencyCode: $currencyCode, includeTax: $includeTax) {\n              basePrice {\n                currencyCode\n                value\n                __typename\n              }\n              price {\n                currencyCode\n                value\n                __typename\n              }\n              salePrice {\n                currencyCode\n                value\n                __typename\n              }\n              __typename\n            }\n            inventory {\n              byLocation(first: 50) {\n                edges {\n                  node {\n                    locationEntityId\n                    availableToSell\n                    warningLevel\n                    isInStock\n                    locationEntityTypeId\n                    locationEntityCode\n                    __typename\n                  }\n                  __typename\n                }\n                __typename\n              }\n              aggregated {\n                availableToSell\n              }\n              isInStock\n              __typename\n            }\n            productOptions(first: 50) {\n              edges {\n                node {\n                  entityId\n                  __typename\n                  displayName\n                  ... on MultipleChoiceOption {\n                    values(first: 50) {\n                      edges {\n                        node {\n                          entityId\n                          label\n                          __typename\n                        }\n                        __typename\n                      }\n                      __typename\n                    }\n                    __typename\n                  }\n                }\n                __typename\n              }\n              __typename\n            }\n            metafields(namespace: \"sns_metafields\", first: 50) {\n              ...MetafieldsFragment\n              __typename\n            }\n            __typename\n          }\n          __typename\n        }\n        __typename\n      }\n      __typename\n    }\n    \n    fragment ProductNodeFragment on ProductEdge {\n      node {\n        ...ListProductFragment\n        __typename\n      }\n      __typename\n    }\n    \n    fragment ProductListFragment on ProductConnection {\n      edges {\n        ...ProductNodeFragment\n        __typename\n      }\n      __typename\n    }\n    "
+	USER_AGENT            string = "okhttp/4.12.0"
+)
+
+type SnsBackend struct {
+	region RegionConfig
+	logger *Logger
+}
+
+func NewSnsBackend(region RegionConfig) *SnsBackend {
+	if region.Instance == "" {
+		region.Instance = defaultRegionConfig.Instance
+	}
+	if region.CurrencyCode == "" {
+		region.CurrencyCode = defaultRegionConfig.CurrencyCode
+	}
+	if region.ProductUrlPrefix == "" {
+		region.ProductUrlPrefix = defaultRegionConfig.ProductUrlPrefix
+	}
+	if region.NewArrivalsViewId == "" {
+		region.NewArrivalsViewId = defaultRegionConfig.NewArrivalsViewId
+	}
+	if region.NewArrivalsDomainKey == "" {
+		region.NewArrivalsDomainKey = defaultRegionConfig.NewArrivalsDomainKey
+	}
+
+	return &SnsBackend{
+		region: region,
+		logger: NewLogger(fmt.Sprintf("%s SNS", strings.ToUpper(region.Name))),
+	}
+}
+
+func (b *SnsBackend) Name() string {
+	return "Sneakersnstuff"
+}
+
+func (b *SnsBackend) FetchNewArrivals(t *BaseTask, page int) ([]byte, error) {
+	configMu.RLock()
+	newArrivalsConfig := config.LoadTask.NewArrivals
+	baseUrl := getBaseUrl(config.Endpoints.NewArrivalsBaseUrl, NEW_ARRIVALS_BASE_URL)
+	configMu.RUnlock()
+
+	newArrivalsUrl := baseUrl + strings.NewReplacer(
+		"{viewId}", b.region.NewArrivalsViewId,
+		"{domainKey}", b.region.NewArrivalsDomainKey,
+	).Replace(NEW_ARRIVALS_PATH)
+
+	newArrivalsUrl += newArrivalsConfig.queryString(b.region.Instance, page)
+
+	req, err := http.NewRequest("GET", newArrivalsUrl, nil)
+	if err != nil {
+		return nil, fmt.Errorf("new arrivals: error creating request: %v", err)
+	}
+
+	req.Header = http.Header{
+		"Host":            {req.URL.Host},
+		"Accept":          {"application/json, text/plain, */*"},
+		"Accept-Encoding": {"gzip, deflate, br"},
+		"User-Agent":      {USER_AGENT},
+		"Connection":      {"keep-alive"},
+		"Header-Order:": {
+			"Host",
+			"BC-Instance",
+			"Accept",
+			"Accept-Encoding",
+			"User-Agent",
+			"Connection",
+		},
+	}
+
+	res, err := t.httpClient.Do(req)
+	if err != nil {
+		requestErr := &RequestError{
+			location: "new arrivals",
+			err:      err,
+		}
+
+		if t.proxy != nil {
+			requestErr.proxyAsString = ProxyAsString(*t.proxy)
+		}
+
+		return nil, requestErr
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		statusCodeErr := &StatusCodeError{
+			location:   "new arrivals",
+			statusCode: res.StatusCode,
+			statusText: res.Status,
+		}
+
+		if t.proxy != nil {
+			if res.StatusCode == http.StatusForbidden {
+				t.proxyHandler.ReportBadProxy(t.proxy)
+			}
+
+			statusCodeErr.proxyAsString = ProxyAsString(*t.proxy)
+		}
+
+		return nil, statusCodeErr
+	}
+
+	bytes, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("new arrivals: error reading body: %v", err)
+	}
+
+	return bytes, nil
+}
+
+func (b *SnsBackend) ParseNewArrivals(bytes []byte) ([]NewArrival, error) {
+	newArrivalsResponse := NewArrivalsResponse{}
+
+	err := json.Unmarshal(bytes, &newArrivalsResponse)
+	if err != nil {
+		return nil, fmt.Errorf("new arrivals: error unmarshalling response bytes: %v", err)
+	}
+
+	newArrivals := []NewArrival{}
+	for _, productNode := range newArrivalsResponse.Response.ProductNodes {
+		newArrivals = append(newArrivals, NewArrival{
+			Pid: productNode.Pid,
+			Sku: productNode.Sku,
+		})
+	}
+
+	return newArrivals, nil
+}
+
+func (b *SnsBackend) FetchProductsBySku(t *BaseTask, skus []string) ([]byte, error) {
+	productsBySkuBody := productsBySkuBody{
+		Query: PRODUCTS_BY_SKU_QUERY,
+		Variables: bodyVariables{
+			CurrencyCode: b.region.CurrencyCode,
+			IncludeTax:   true,
+			Skus:         skus,
+		},
+	}
+
+	bodyBytes, err := json.Marshal(productsBySkuBody)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling products by sku body: %v", err)
+	}
+
+	configMu.RLock()
+	baseUrl := getBaseUrl(config.Endpoints.ProductsBaseUrl, PRODUCTS_BASE_URL)
+	configMu.RUnlock()
+
+	req, err := http.NewRequest("POST", baseUrl+PRODUCTS_PATH, bytes.NewReader(bodyBytes))
+	if err != nil {
+		return nil, fmt.Errorf("products by sku: error creating request: %v", err)
+	}
+
+	req.Header = http.Header{
+		"Host":            {req.URL.Host},
+		"BC-Instance":     {b.region.Instance},
+		"Accept":          {"application/json"},
+		"Content-Type":    {"application/json"},
+		"Accept-Encoding": {"gzip, deflate, br"},
+		"User-Agent":      {USER_AGENT},
+		"Header-Order:": {
+			"Host",
+			"BC-Instance",
+			"Accept",
+			"Content-Type",
+			"Content-Length",
+			"Accept-Encoding",
+			"User-Agent",
+		},
+	}
+
+	res, err := t.httpClient.Do(req)
+	if err != nil {
+		requestErr := &RequestError{
+			location: "products by sku",
+			err:      err,
+		}
+
+		if t.proxy != nil {
+			requestErr.proxyAsString = ProxyAsString(*t.proxy)
+		}
+
+		return nil, requestErr
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		statusCodeErr := &StatusCodeError{
+			location:   "products by sku",
+			statusCode: res.StatusCode,
+			statusText: res.Status,
+		}
+
+		if t.proxy != nil {
+			if res.StatusCode == http.StatusForbidden {
+				t.proxyHandler.ReportBadProxy(t.proxy)
+			}
+
+			statusCodeErr.proxyAsString = ProxyAsString(*t.proxy)
+		}
+
+		return nil, statusCodeErr
+	}
+
+	resbytes, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("products by sku: error reading body: %v", err)
+	}
+
+	return resbytes, nil
+}
+
+// Returns nil if the response contains no product list at all
+func (b *SnsBackend) ParseProductsBySku(bytes []byte) ([]ProductData, error) {
+	productsBySkusResponse := ProductsBySkusResponse{}
+
+	err := json.Unmarshal(bytes, &productsBySkusResponse)
+	if err != nil {
+		return nil, fmt.Errorf("products by sku: error unmarshalling response bytes: %v", err)
+	}
+
+	productEdges := productsBySkusResponse.Data.Site.Search.SearchProducts.Products.Edges
+	if productEdges == nil {
+		return nil, nil
+	}
+
+	products := []ProductData{}
+	for _, productEdge := range productEdges {
+		if productEdge.Node.Variants == nil {
+			b.logger.Red(fmt.Sprintf("%s: Variants property nil. Skipping product edge...", productEdge.Node.Sku))
+			continue
+		}
+		if productEdge.Node.Variants.Edges == nil {
+			b.logger.Red(fmt.Sprintf("%s: Variants.Edges property nil. Skipping product edge...", productEdge.Node.Sku))
+			continue
+		}
+
+		products = append(products, b.getProductData(productEdge.Node))
+	}
+
+	return products, nil
+}
+
+func (b *SnsBackend) getProductData(productNode ProductNode) ProductData {
+	sku := productNode.Sku
+	productUrl := fmt.Sprintf("%s%s", b.region.ProductUrlPrefix, productNode.Path)
+
+	availableForSale := true
+	if productNode.AvailabilityV2.Status == "Unavailable" {
+		availableForSale = false
+	}
+
+	title := productNode.Name
+
+	availableSizes := []AvailableSize{}
+	// sizesMetafieldExisting := false
+
+	for _, variantEdge := range *productNode.Variants.Edges {
+		if !variantEdge.Node.Inventory.IsInStock {
+			continue
+		}
+
+		if len(variantEdge.Node.ProductOptions.Edges) != 1 {
+			continue
+		}
+		optionEdge := variantEdge.Node.ProductOptions.Edges[0]
+
+		if len(optionEdge.Node.Values.Edges) != 1 {
+			continue
+		}
+		optionValueEdge := optionEdge.Node.Values.Edges[0]
+
+		availableSize := AvailableSize{
+			Name:          optionValueEdge.Node.Label,
+			AmountInStock: variantEdge.Node.Inventory.Aggregated.AvailableToSell,
+		}
+
+		availableSizes = append(availableSizes, availableSize)
+	}
+
+	// if !sizesMetafieldExisting && len(productNode.Variants.Edges) == 1 {
+	// 	availableSize := AvailableSize{
+	// 		Name:          "One-Size",
+	// 		AmountInStock: productNode.Variants.Edges[0].Node.Inventory.Aggregated.AvailableToSell,
+	// 	}
+
+	// 	availableSizes = append(availableSizes, availableSize)
+	// }
+
+	sortAvailableSizes(availableSizes)
+
+	price := NewPrice(productNode.Prices.Price.Value, productNode.Prices.Price.CurrencyCode)
+	basePrice := NewPrice(productNode.Prices.BasePrice.Value, productNode.Prices.BasePrice.CurrencyCode)
+
+	var salePrice *Price
+	if productNode.Prices.SalePrice != nil {
+		newSalePrice := NewPrice(productNode.Prices.SalePrice.Value, productNode.Prices.SalePrice.CurrencyCode)
+		salePrice = &newSalePrice
+	}
+
+	imageUrl := productNode.DefaultImage.URL
+
+	identifyerStr := ""
+	// for _, metafieldEdge := range productNode.Metafields.Edges {
+	// 	if metafieldEdge.Node.Key == "product_copy" {
+	// 		if strings.Contains(metafieldEdge.Node.Value, "\\r\\n \\r\\n- ") {
+	// 			identifyerStr = strings.Split(metafieldEdge.Node.Value, "\\r\\n \\r\\n- ")[1]
+
+	// 			if strings.Contains(identifyerStr, "\\r") {
+	// 				identifyerStr = strings.Split(identifyerStr, "\\r")[0]
+	// 			} else {
+	// 				identifyerStr = ""
+	// 			}
+	// 		} else if strings.Contains(metafieldEdge.Node.Value, "\\r\\n\\r\\n-\u00a0") {
+	// 			identifyerStr = strings.Split(metafieldEdge.Node.Value, "\\r\\n\\r\\n-\u00a0")[1]
+
+	// 			if strings.Contains(identifyerStr, "\\r") {
+	// 				identifyerStr = strings.Split(identifyerStr, "\\r")[0]
+	// 			} else if strings.Contains(identifyerStr, "\u00a0") {
+	// 				identifyerStr = strings.Split(identifyerStr, "\u00a0")[0]
+	// 			} else {
+	// 				identifyerStr = ""
+	// 			}
+	// 		}
+
+	// 		break
+	// 	}
+	// }
+	for _, customfieldEdge := range productNode.CustomFields.Edges {
+		if customfieldEdge.Node.Name == "brand_color" {
+			identifyerStr = fmt.Sprintf("%s %s %s %s", productNode.Brand.Name, strings.ReplaceAll(title, sku, ""), identifyerStr, customfieldEdge.Node.Value)
+
+			break
+		}
+	}
+
+	identifyerStr = strings.ReplaceAll(identifyerStr, "+", " ")
+	identifyerStr = strings.ReplaceAll(identifyerStr, "-", " ")
+	identifyerStr = strings.ReplaceAll(identifyerStr, "/", " ")
+	identifyerStr = strings.ReplaceAll(identifyerStr, "|", " ")
+	identifyerStr = strings.ToLower(identifyerStr)
+	for strings.Contains(identifyerStr, "  ") {
+		identifyerStr = strings.ReplaceAll(identifyerStr, "  ", " ")
+	}
+
+	return ProductData{
+		ProductUrl:       productUrl,
+		Title:            title,
+		Sku:              sku,
+		AvailableForSale: availableForSale,
+		AvailableSizes:   availableSizes,
+		Price:            price,
+		BasePrice:        basePrice,
+		SalePrice:        salePrice,
+		ImageUrl:         imageUrl,
+		IdentifyerStr:    identifyerStr,
+	}
+}
+
+// Endpoint base urls can be overridden in the config, e.g. to point the monitor at the local mock server
+func getBaseUrl(overrideUrl string, defaultUrl string) string {
+	if overrideUrl == "" {
+		return defaultUrl
+	}
+	return strings.TrimSuffix(overrideUrl, "/")
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	STATE_PERSIST_DEBOUNCE = 500 * time.Millisecond // Changes within this delay are written together
+	STATE_BACKUP_INTERVAL  = 10 * time.Minute
+	STATE_BACKUP_COUNT     = 5
+)
+
+var statePersister *StatePersister = nil
+
+// Single goroutine that writes the product states. Changes only mark the states dirty,
+// the persister coalesces them and writes a snapshot after the debounce delay
+type StatePersister struct {
+	dirtyCh chan struct{}
+	flushCh chan chan struct{}
+	stopCh  chan struct{}
+	doneCh  chan struct{}
+	logger  *Logger
+}
+
+func NewStatePersister() *StatePersister {
+	return &StatePersister{
+		dirtyCh: make(chan struct{}, 1),
+		flushCh: make(chan chan struct{}),
+		stopCh:  make(chan struct{}),
+		doneCh:  make(chan struct{}),
+		logger:  NewLogger("PERSIST"),
+	}
+}
+
+func (p *StatePersister) Start() {
+	go p.run()
+}
+
+func (p *StatePersister) run() {
+	defer close(p.doneCh)
+
+	dirty := false
+	changedSinceBackup := false
+
+	debounce := time.NewTimer(STATE_PERSIST_DEBOUNCE)
+	debounce.Stop()
+
+	backupTicker := time.NewTicker(STATE_BACKUP_INTERVAL)
+	defer backupTicker.Stop()
+
+	write := func() {
+		if !dirty {
+			return
+		}
+		dirty = false
+
+		err := saveProductStates()
+		if err != nil {
+			p.logger.Red(fmt.Sprintf("Error writing product states: %v", err))
+			return
+		}
+		changedSinceBackup = true
+	}
+
+	for {
+		select {
+		case <-p.dirtyCh:
+			if !dirty {
+				dirty = true
+				debounce.Reset(STATE_PERSIST_DEBOUNCE)
+			}
+
+		case <-debounce.C:
+			write()
+
+		case <-backupTicker.C:
+			if changedSinceBackup {
+				changedSinceBackup = false
+				p.backup()
+			}
+
+		case flushed := <-p.flushCh:
+			debounce.Stop()
+			write()
+			close(flushed)
+
+		case <-p.stopCh:
+			debounce.Stop()
+			write()
+			if changedSinceBackup {
+				p.backup()
+			}
+			return
+		}
+	}
+}
+
+// Never blocks. A pending write already covers the change
+func (p *StatePersister) MarkDirty() {
+	select {
+	case p.dirtyCh <- struct{}{}:
+	default:
+	}
+}
+
+// Writes pending changes now and waits for the write
+func (p *StatePersister) Flush() {
+	flushed := make(chan struct{})
+
+	select {
+	case p.flushCh <- flushed:
+		<-flushed
+	case <-p.doneCh:
+	}
+}
+
+// Writes pending changes, takes a final backup and stops the goroutine
+func (p *StatePersister) Stop() {
+	select {
+	case <-p.doneCh:
+		return
+	default:
+	}
+
+	close(p.stopCh)
+	<-p.doneCh
+}
+
+func (p *StatePersister) backup() {
+	path, err := backupProductStates()
+	if err != nil {
+		p.logger.Red(fmt.Sprintf("Error backing up product states: %v", err))
+		return
+	}
+
+	p.logger.Grey(fmt.Sprintf("Backed up product states to %s", path))
+}
+
+// Marks the product states as changed. Replaces writing the states directly from every caller
+func markProductStatesDirty() {
+	if statePersister == nil || replayMode {
+		return
+	}
+
+	statePersister.MarkDirty()
+}
+
+// Takes a snapshot under the state locks and writes it without holding them
+func saveProductStates() error {
+	if productStates == nil || stateStore == nil {
+		return nil
+	}
+
+	statesNormalMu.Lock()
+	statesLoadMu.Lock()
+	bytes, err := json.Marshal(productStates)
+	statesLoadMu.Unlock()
+	statesNormalMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("error marshalling product states: %v", err)
+	}
+
+	var snapshot ProductStates
+
+	err = json.Unmarshal(bytes, &snapshot)
+	if err != nil {
+		return fmt.Errorf("error copying product states: %v", err)
+	}
+
+	return stateStore.Save(&snapshot)
+}
+
+// Writes a consistent copy of the state store to the backups folder and removes the oldest backups
+func backupProductStates() (string, error) {
+	path := filepath.Join(pathStateBackupFolder, fmt.Sprintf("states-%d.db", time.Now().UnixMilli()))
+
+	err := stateStore.Backup(path)
+	if err != nil {
+		return "", err
+	}
+
+	backups, err := listStateBackups()
+	if err != nil {
+		return path, err
+	}
+
+	for i := STATE_BACKUP_COUNT; i < len(backups); i++ {
+		err = os.Remove(backups[i])
+		if err != nil {
+			return path, fmt.Errorf("error removing old backup: %v", err)
+		}
+	}
+
+	return path, nil
+}
+
+// Returns the backup files, newest first
+func listStateBackups() ([]string, error) {
+	entries, err := os.ReadDir(pathStateBackupFolder)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading backups folder: %v", err)
+	}
+
+	backups := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, "states-") || !strings.HasSuffix(name, ".db") {
+			continue
+		}
+		backups = append(backups, filepath.Join(pathStateBackupFolder, name))
+	}
+
+	// Millisecond timestamps have the same length for the foreseeable future, so names sort by time
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+
+	return backups, nil
+}
+
+// Opens the state store and loads the product states. If the database is damaged, it is moved aside
+// and the newest backup that loads takes its place
+func openProductStates() (StateStore, *ProductStates, error) {
+	store, states, err := tryOpenProductStates()
+	if err == nil {
+		return store, states, nil
+	}
+
+	fileSystemLogger.Red(fmt.Sprintf("Error loading product states: %v", err))
+
+	backups, backupErr := listStateBackups()
+	if backupErr != nil || len(backups) == 0 {
+		return nil, nil, err
+	}
+
+	damagedPath := fmt.Sprintf("%s.damaged-%d", pathStatesDb, time.Now().UnixMilli())
+	if renameErr := os.Rename(pathStatesDb, damagedPath); renameErr != nil && !os.IsNotExist(renameErr) {
+		return nil, nil, fmt.Errorf("%v, and moving the database aside failed: %v", err, renameErr)
+	}
+
+	for _, backup := range backups {
+		copyErr := copyFileAtomic(backup, pathStatesDb)
+		if copyErr != nil {
+			fileSystemLogger.Red(fmt.Sprintf("Error restoring backup %s: %v", backup, copyErr))
+			continue
+		}
+
+		store, states, restoreErr := tryOpenProductStates()
+		if restoreErr != nil {
+			fileSystemLogger.Red(fmt.Sprintf("Backup %s is not usable: %v", backup, restoreErr))
+			continue
+		}
+
+		fileSystemLogger.Yellow(fmt.Sprintf("Restored product states from %s, the damaged database was moved to %s", backup, damagedPath))
+		return store, states, nil
+	}
+
+	return nil, nil, fmt.Errorf("%v, and no backup could be restored", err)
+}
+
+func tryOpenProductStates() (store StateStore, states *ProductStates, err error) {
+	// bbolt panics on some kinds of damaged pages instead of returning an error
+	defer func() {
+		if r := recover(); r != nil {
+			if store != nil {
+				store.Close()
+			}
+			store, states, err = nil, nil, fmt.Errorf("damaged product states database: %v", r)
+		}
+	}()
+
+	boltStore, err := OpenBoltStateStore(pathStatesDb)
+	if err != nil {
+		return nil, nil, err
+	}
+	store = boltStore
+
+	states, err = readProductStates(store)
+	if err != nil {
+		store.Close()
+		return nil, nil, err
+	}
+
+	return store, states, nil
+}
+
+// Copies via a temp file that is synced and renamed, so the destination is never half written
+func copyFileAtomic(src string, dst string) error {
+	bytes, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(dst, bytes)
+}
+
+func writeFileAtomic(path string, bytes []byte) error {
+	tmpPath := path + ".tmp"
+
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(bytes)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
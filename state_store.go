@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const STATE_STORE_SCHEMA_VERSION = "1"
+
+var (
+	stateMetaBucketName     []byte = []byte("meta")
+	stateRegionsBucketName  []byte = []byte("regions")
+	stateNormalBucketName   []byte = []byte("normal")
+	stateNotifiedBucketName []byte = []byte("notified")
+	stateKeywordsBucketName []byte = []byte("keywords")
+
+	stateSchemaVersionKey []byte = []byte("schemaVersion")
+	stateLastKnownPidKey  []byte = []byte("lastKnownPid")
+)
+
+var stateStore StateStore = nil
+
+// Persists the product states. A save is applied completely or not at all
+type StateStore interface {
+	Load() (*ProductStates, error)
+	Save(states *ProductStates) error
+	IsEmpty() (bool, error)
+	Backup(path string) error
+	Close() error
+}
+
+// Product states in a bbolt database. Every region has a bucket with one record per sku,
+// notified product and keyword query, so a save only rewrites the records that changed
+type BoltStateStore struct {
+	db *bolt.DB
+}
+
+func OpenBoltStateStore(path string) (*BoltStateStore, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: BOLT_OPEN_TIMEOUT})
+	if err != nil {
+		return nil, fmt.Errorf("error opening product states database: %v", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		metaBucket, err := tx.CreateBucketIfNotExists(stateMetaBucketName)
+		if err != nil {
+			return err
+		}
+
+		if schemaVersion := metaBucket.Get(stateSchemaVersionKey); schemaVersion != nil && string(schemaVersion) != STATE_STORE_SCHEMA_VERSION {
+			return fmt.Errorf("unsupported schema version %s", schemaVersion)
+		}
+
+		err = metaBucket.Put(stateSchemaVersionKey, []byte(STATE_STORE_SCHEMA_VERSION))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.CreateBucketIfNotExists(stateRegionsBucketName)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error initializing product states database: %v", err)
+	}
+
+	return &BoltStateStore{
+		db: db,
+	}, nil
+}
+
+func (s *BoltStateStore) IsEmpty() (bool, error) {
+	empty := true
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		key, _ := tx.Bucket(stateRegionsBucketName).Cursor().First()
+		empty = key == nil
+		return nil
+	})
+
+	return empty, err
+}
+
+func (s *BoltStateStore) Load() (*ProductStates, error) {
+	states := &ProductStates{
+		Regions: make(map[string]*RegionProductStates),
+	}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(stateRegionsBucketName).ForEachBucket(func(regionKey []byte) error {
+			regionBucket := tx.Bucket(stateRegionsBucketName).Bucket(regionKey)
+			regionStates := states.GetRegion(string(regionKey))
+
+			err := forEachStateRecord(regionBucket, stateNormalBucketName, func(key []byte, value []byte) error {
+				var state ProductStateNormal
+				if err := json.Unmarshal(value, &state); err != nil {
+					return fmt.Errorf("error unmarshalling state of %s: %v", key, err)
+				}
+
+				regionStates.Normal.ProductStates = append(regionStates.Normal.ProductStates, &state)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			err = forEachStateRecord(regionBucket, stateNotifiedBucketName, func(key []byte, value []byte) error {
+				var notified ProductStateLoad
+				if err := json.Unmarshal(value, &notified); err != nil {
+					return fmt.Errorf("error unmarshalling notified product %s: %v", key, err)
+				}
+
+				regionStates.Load.NotifiedProducts = append(regionStates.Load.NotifiedProducts, &notified)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			err = forEachStateRecord(regionBucket, stateKeywordsBucketName, func(key []byte, value []byte) error {
+				var metadata *WatchMetadata
+				if err := json.Unmarshal(value, &metadata); err != nil {
+					return fmt.Errorf("error unmarshalling keyword query %s: %v", key, err)
+				}
+
+				regionStates.Load.KeywordQueries = append(regionStates.Load.KeywordQueries, string(key))
+				if metadata != nil {
+					regionStates.LoadSetKwdMetadata(string(key), metadata)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			regionStates.Load.LastKnownPid = string(regionBucket.Get(stateLastKnownPidKey))
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading product states: %v", err)
+	}
+
+	return states, nil
+}
+
+func forEachStateRecord(regionBucket *bolt.Bucket, bucketName []byte, fn func(key []byte, value []byte) error) error {
+	bucket := regionBucket.Bucket(bucketName)
+	if bucket == nil {
+		return nil
+	}
+	return bucket.ForEach(fn)
+}
+
+// Writes all regions in one transaction. Pass a snapshot, the states must not change during the save
+func (s *BoltStateStore) Save(states *ProductStates) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		regionsBucket := tx.Bucket(stateRegionsBucketName)
+
+		// Regions that are gone from the states
+		staleRegions := [][]byte{}
+		err := regionsBucket.ForEachBucket(func(regionKey []byte) error {
+			if _, ok := states.Regions[string(regionKey)]; !ok {
+				staleRegions = append(staleRegions, regionKey)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, regionKey := range staleRegions {
+			if err := regionsBucket.DeleteBucket(regionKey); err != nil {
+				return err
+			}
+		}
+
+		for regionName, regionStates := range states.Regions {
+			if regionStates == nil {
+				continue
+			}
+
+			err := saveRegionStates(regionsBucket, regionName, regionStates)
+			if err != nil {
+				return fmt.Errorf("error saving region %s: %v", regionName, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+func saveRegionStates(regionsBucket *bolt.Bucket, regionName string, regionStates *RegionProductStates) error {
+	regionBucket, err := regionsBucket.CreateBucketIfNotExists([]byte(regionName))
+	if err != nil {
+		return err
+	}
+
+	normalRecords := make(map[string]any)
+	for _, state := range regionStates.Normal.ProductStates {
+		normalRecords[state.Sku] = state
+	}
+
+	notifiedRecords := make(map[string]any)
+	for _, notified := range regionStates.Load.NotifiedProducts {
+		notifiedRecords[notified.Sku] = notified
+	}
+
+	keywordRecords := make(map[string]any)
+	for _, kwdQuery := range regionStates.Load.KeywordQueries {
+		keywordRecords[kwdQuery] = regionStates.LoadGetKwdMetadata(kwdQuery)
+	}
+
+	err = syncStateRecords(regionBucket, stateNormalBucketName, normalRecords)
+	if err != nil {
+		return err
+	}
+	err = syncStateRecords(regionBucket, stateNotifiedBucketName, notifiedRecords)
+	if err != nil {
+		return err
+	}
+	err = syncStateRecords(regionBucket, stateKeywordsBucketName, keywordRecords)
+	if err != nil {
+		return err
+	}
+
+	return regionBucket.Put(stateLastKnownPidKey, []byte(regionStates.Load.LastKnownPid))
+}
+
+// Puts the records that changed and deletes the ones that are gone
+func syncStateRecords(regionBucket *bolt.Bucket, bucketName []byte, records map[string]any) error {
+	bucket, err := regionBucket.CreateBucketIfNotExists(bucketName)
+	if err != nil {
+		return err
+	}
+
+	staleKeys := [][]byte{}
+	err = bucket.ForEach(func(key []byte, value []byte) error {
+		if _, ok := records[string(key)]; !ok {
+			staleKeys = append(staleKeys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range staleKeys {
+		if err := bucket.Delete(key); err != nil {
+			return err
+		}
+	}
+
+	for key, record := range records {
+		if strings.TrimSpace(key) == "" {
+			continue
+		}
+
+		value, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("error marshalling %s: %v", key, err)
+		}
+
+		if bytes.Equal(bucket.Get([]byte(key)), value) {
+			continue
+		}
+
+		err = bucket.Put([]byte(key), value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Writes a consistent copy of the database to a synced temp file and renames it
+func (s *BoltStateStore) Backup(path string) error {
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("error creating backups folder: %v", err)
+	}
+
+	tmpPath := path + ".tmp"
+
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("error creating backup: %v", err)
+	}
+
+	err = s.db.View(func(tx *bolt.Tx) error {
+		_, err := tx.WriteTo(file)
+		return err
+	})
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("error writing backup: %v", err)
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+func (s *BoltStateStore) Close() error {
+	return s.db.Close()
+}
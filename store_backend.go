@@ -0,0 +1,33 @@
+package main
+
+import "fmt"
+
+// StoreBackend abstracts the shop specific requests and response formats so the task groups can
+// drive storefronts other than Sneakersnstuff. Backends only hand shop-neutral types to the task groups.
+// Requests and parsing are split, so the raw response bodies can be captured and replayed through the same parser.
+type StoreBackend interface {
+	Name() string
+	// Raw response body of one page of the newest products
+	FetchNewArrivals(task *BaseTask, page int) ([]byte, error)
+	// Newest first
+	ParseNewArrivals(body []byte) ([]NewArrival, error)
+	// Raw response body of the products with the skus
+	FetchProductsBySku(task *BaseTask, skus []string) ([]byte, error)
+	// Skus the shop does not know are left out
+	ParseProductsBySku(body []byte) ([]ProductData, error)
+}
+
+// A product of the new arrivals listing. The pid marks the listing position, the sku is checked with FetchProductsBySku
+type NewArrival struct {
+	Pid string `json:"pid"`
+	Sku string `json:"sku"`
+}
+
+func NewStoreBackend(backendName string, region RegionConfig) (StoreBackend, error) {
+	switch backendName {
+	case "", "sns":
+		return NewSnsBackend(region), nil
+	default:
+		return nil, fmt.Errorf("unknown store backend: %s", backendName)
+	}
+}
@@ -1,43 +1,121 @@
 package main
 
+import "time"
+
 // config.json
 type Config struct {
+	ConfigVersion   int              `json:"configVersion"`
 	NormalTask      NormalTaskConfig `json:"normal"`
 	LoadTask        LoadTaskConfig   `json:"load"`
+	Backend         string           `json:"backend"`
 	DiscordPresence struct {
 		AvatarUrl  string `json:"avatarUrl"`
 		EmbedColor int    `json:"embedColor"`
 		FooterText string `json:"footerText"`
 	} `json:"discordPresence"`
-	MaxTasksPerProxy    int    `json:"maxTasksPerProxy"`
-	ProxyfileName       string `json:"proxyfile"`
-	WebhookErrorTimeout int    `json:"webhookErrorTimeoutInMilliseconds"`
-	RemoveBadProxy      bool   `json:"autoRemoveBadProxy"`
-	InstanceName        string `json:"instanceName"`
-	WebsocketPort       int    `json:"websocketPort"`
-	EnableFileLogging   bool   `json:"enableFileLogging"`
+	MaxTasksPerProxy    int              `json:"maxTasksPerProxy"`
+	ProxyfileName       string           `json:"proxyfile"`
+	WebhookErrorTimeout int              `json:"webhookErrorTimeoutInMilliseconds"`
+	RemoveBadProxy      bool             `json:"autoRemoveBadProxy"`
+	InstanceName        string           `json:"instanceName"`
+	WebsocketPort       int              `json:"websocketPort"`
+	WebsocketSecret     string           `json:"websocketSecret"`
+	EnableFileLogging   bool             `json:"enableFileLogging"`
+	CaptureResponses    bool             `json:"captureResponses"`
+	Regions             []RegionConfig   `json:"regions"`
+	ControlApi          ControlApiConfig `json:"controlApi"`
+	Notifiers           NotifiersConfig  `json:"notifiers"`
+	Cooldown            CooldownConfig   `json:"cooldown"`
+	Endpoints           struct {
+		NewArrivalsBaseUrl string `json:"newArrivalsBaseUrl"`
+		ProductsBaseUrl    string `json:"productsBaseUrl"`
+	} `json:"endpoints"`
+}
+
+type ControlApiConfig struct {
+	Enabled bool `json:"enabled"`
+	Port    int  `json:"port"`
+}
+
+type NotifiersConfig struct {
+	Events   map[string][]string       `json:"events"`  // Event type to sink names. Event types not listed go to discord
+	Filters  map[string]*RestockFilter `json:"filters"` // Webhook url or sink name to restock filter
+	Telegram struct {
+		BotToken string   `json:"botToken"`
+		ChatIds  []string `json:"chatIds"`
+	} `json:"telegram"`
+	Slack struct {
+		WebhookUrls []string `json:"webhookUrls"`
+	} `json:"slack"`
+	Json struct {
+		Urls []string `json:"urls"`
+	} `json:"json"`
+	File struct {
+		Path string `json:"path"` // Empty writes to stdout
+	} `json:"file"`
+}
+
+type CooldownConfig struct {
+	WindowInSeconds           int `json:"windowInSeconds"`           // Identical notifications within the window are dropped. 0 disables
+	DigestDelayInMilliseconds int `json:"digestDelayInMilliseconds"` // Restocks following a sent restock within the delay are merged into one digest. 0 disables
+}
+
+type RegionConfig struct {
+	Name                 string   `json:"name"`
+	Instance             string   `json:"instance"`
+	CurrencyCode         string   `json:"currencyCode"`
+	ProductUrlPrefix     string   `json:"productUrlPrefix"`
+	NewArrivalsViewId    string   `json:"newArrivalsViewId"`
+	NewArrivalsDomainKey string   `json:"newArrivalsDomainKey"`
+	NormalWebhookUrls    []string `json:"normalWebhookUrls"`
+	LoadWebhookUrls      []string `json:"loadWebhookUrls"`
 }
 
 type NormalTaskConfig struct {
-	Timeout     int      `json:"timeoutInMilliseconds"`
-	BurstStart  bool     `json:"burstStart"`
-	WebhookUrls []string `json:"webhookUrls"`
-	NumTasks    int      `json:"numTasks"`
+	Timeout     int             `json:"timeoutInMilliseconds"`
+	BurstStart  bool            `json:"burstStart"`
+	WebhookUrls []string        `json:"webhookUrls"`
+	NumTasks    int             `json:"numTasks"`
+	PriceDrop   PriceDropConfig `json:"priceDrop"`
+}
+
+type PriceDropConfig struct {
+	MinAmount      float64 `json:"minAmount"`  // Minimum drop in major units. 0 disables
+	MinPercent     float64 `json:"minPercent"` // Minimum drop in percent of the old price. 0 disables
+	NotifyIncrease bool    `json:"notifyIncrease"`
 }
 
 type LoadTaskConfig struct {
-	Timeout     int      `json:"timeoutInMilliseconds"`
-	BurstStart  bool     `json:"burstStart"`
-	WebhookUrls []string `json:"webhookUrls"`
-	NumTasks    int      `json:"numTasks"`
+	Timeout     int               `json:"timeoutInMilliseconds"`
+	BurstStart  bool              `json:"burstStart"`
+	WebhookUrls []string          `json:"webhookUrls"`
+	NumTasks    int               `json:"numTasks"`
+	NewArrivals NewArrivalsConfig `json:"newArrivals"`
+}
+
+type NewArrivalsConfig struct {
+	Categories []string `json:"categories"` // Missing uses the default shoe categories of the region, empty disables the filter
+	Sort       string   `json:"sort"`
+	Rows       int      `json:"rows"`
+	MaxPages   int      `json:"maxPages"`
 }
 
 // product_states.json
 type ProductStates struct {
+	Regions map[string]*RegionProductStates `json:"regions"`
+}
+
+type RegionProductStates struct {
 	Normal ProductStatesNormal `json:"normal"`
 	Load   ProductStatesLoad   `json:"load"`
 }
 
+// Format before multi region support. Migrated into the default region on load
+type legacyProductStates struct {
+	Normal *ProductStatesNormal `json:"normal"`
+	Load   *ProductStatesLoad   `json:"load"`
+}
+
 type ProductStatesNormal struct {
 	ProductStates []*ProductStateNormal `json:"productStates"`
 }
@@ -46,13 +124,27 @@ type ProductStateNormal struct {
 	Sku              string          `json:"sku"`
 	AvailableForSale bool            `json:"availableForSale"`
 	AvailableSizes   []AvailableSize `json:"availableSizes"`
-	Price            string          `json:"price"`
+	Price            Price           `json:"price"`
+	LowestPrice      *Price          `json:"lowestPrice,omitempty"`
+	PriceHistory     []PricePoint    `json:"priceHistory,omitempty"`
+	Metadata         *WatchMetadata  `json:"metadata,omitempty"`
 }
 
 type ProductStatesLoad struct {
-	NotifiedProducts []*ProductStateLoad `json:"notifiedProducts"`
-	LastKnownPid     string              `json:"lastKnownPid"`
-	KeywordQueries   []string            `json:"keywordQueries"`
+	NotifiedProducts []*ProductStateLoad       `json:"notifiedProducts"`
+	LastKnownPid     string                    `json:"lastKnownPid"`
+	KeywordQueries   []string                  `json:"keywordQueries"`
+	KeywordMetadata  map[string]*WatchMetadata `json:"keywordMetadata,omitempty"`
+}
+
+// Attached to monitored skus and keyword queries. WebhookUrls replace the region and global webhook urls
+type WatchMetadata struct {
+	AddedBy     string         `json:"addedBy,omitempty"`
+	AddedAt     time.Time      `json:"addedAt"`
+	Note        string         `json:"note,omitempty"`
+	Tags        []string       `json:"tags,omitempty"`
+	WebhookUrls []string       `json:"webhookUrls,omitempty"`
+	Filter      *RestockFilter `json:"filter,omitempty"`
 }
 
 type ProductStateLoad struct {
@@ -61,14 +153,16 @@ type ProductStateLoad struct {
 }
 
 type ProductData struct {
-	ProductUrl       string
-	Title            string
-	Sku              string
-	AvailableForSale bool
-	AvailableSizes   []AvailableSize
-	Price            string
-	ImageUrl         string
-	IdentifyerStr    string
+	ProductUrl       string          `json:"productUrl"`
+	Title            string          `json:"title"`
+	Sku              string          `json:"sku"`
+	AvailableForSale bool            `json:"availableForSale"`
+	AvailableSizes   []AvailableSize `json:"availableSizes"`
+	Price            Price           `json:"price"`               // Price the product is sold for
+	BasePrice        Price           `json:"basePrice"`           // Original price
+	SalePrice        *Price          `json:"salePrice,omitempty"` // Set while the product is on sale
+	ImageUrl         string          `json:"imageUrl"`
+	IdentifyerStr    string          `json:"-"`
 }
 
 type AvailableSize struct {
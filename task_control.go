@@ -1,14 +1,33 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"strings"
+	"time"
+)
+
+const (
+	BULK_STATUS_ADDED             = "ADDED"
+	BULK_STATUS_REMOVED           = "REMOVED"
+	BULK_STATUS_ALREADY_MONITORED = "ALREADY_MONITORED"
+	BULK_STATUS_NOT_FOUND         = "NOT_FOUND"
+	BULK_STATUS_INVALID           = "INVALID"
 )
 
 func handleAdd(addMessage *AddMessage) error {
+	region, err := getRegion(addMessage.Region)
+	if err != nil {
+		return err
+	}
+
+	if addMessage.AddQuery == "" {
+		return errors.New("empty add query")
+	}
+
 	if map[string]bool{"PRODUCT": true, "SKU": true, "KWD_QUERY": true}[addMessage.InputType] {
 		if addMessage.InputType == "SKU" {
-			monitored := checkSkuQueryMonitored(addMessage.AddQuery)
+			monitored := checkSkuQueryMonitored(region, addMessage.AddQuery)
 			if monitored {
 				return &AlreadyMonitoredError{
 					queryType:  "SKU",
@@ -16,13 +35,13 @@ func handleAdd(addMessage *AddMessage) error {
 				}
 			}
 
-			addSkuQuery(addMessage.AddQuery)
+			addSkuQuery(region, addMessage.AddQuery, newWatchMetadata(addMessage.Metadata))
 		} else {
 			if addMessage.AddQuery[0] != '+' && addMessage.AddQuery[0] != '-' {
 				addMessage.AddQuery = fmt.Sprintf("+%s", addMessage.AddQuery)
 			}
 
-			monitored := checkKwdQueryMonitored(addMessage.AddQuery)
+			monitored := checkKwdQueryMonitored(region, addMessage.AddQuery)
 			if monitored {
 				return &AlreadyMonitoredError{
 					queryType:  "KEYWORD",
@@ -30,7 +49,7 @@ func handleAdd(addMessage *AddMessage) error {
 				}
 			}
 
-			addKwdQuery(addMessage.AddQuery)
+			addKwdQuery(region, addMessage.AddQuery, newWatchMetadata(addMessage.Metadata))
 		}
 	} else {
 		return fmt.Errorf("unexpected input type: %s", addMessage.InputType)
@@ -39,9 +58,18 @@ func handleAdd(addMessage *AddMessage) error {
 }
 
 func handleRemove(removeMessage *RemoveMessage) error {
+	region, err := getRegion(removeMessage.Region)
+	if err != nil {
+		return err
+	}
+
+	if removeMessage.RemoveQuery == "" {
+		return errors.New("empty remove query")
+	}
+
 	if map[string]bool{"SKU": true, "KWD_QUERY": true}[removeMessage.InputType] {
 		if removeMessage.InputType == "SKU" {
-			monitored := checkSkuQueryMonitored(removeMessage.RemoveQuery)
+			monitored := checkSkuQueryMonitored(region, removeMessage.RemoveQuery)
 			if !monitored {
 				return &QueryNotFoundError{
 					queryType:  "SKU",
@@ -49,13 +77,13 @@ func handleRemove(removeMessage *RemoveMessage) error {
 				}
 			}
 
-			removeSkuQuery(removeMessage.RemoveQuery)
+			removeSkuQuery(region, removeMessage.RemoveQuery)
 		} else {
 			if removeMessage.RemoveQuery[0] != '+' && removeMessage.RemoveQuery[0] != '-' {
 				removeMessage.RemoveQuery = fmt.Sprintf("+%s", removeMessage.RemoveQuery)
 			}
 
-			monitored := checkKwdQueryMonitored(removeMessage.RemoveQuery)
+			monitored := checkKwdQueryMonitored(region, removeMessage.RemoveQuery)
 			if !monitored {
 				return &QueryNotFoundError{
 					queryType:  "KEYWORD",
@@ -63,7 +91,7 @@ func handleRemove(removeMessage *RemoveMessage) error {
 				}
 			}
 
-			removeKwdQuery(removeMessage.RemoveQuery)
+			removeKwdQuery(region, removeMessage.RemoveQuery)
 		}
 	} else {
 		return fmt.Errorf("unexpected input type: %s", removeMessage.InputType)
@@ -71,55 +99,358 @@ func handleRemove(removeMessage *RemoveMessage) error {
 	return nil
 }
 
-func handleList(listMessage *ListMessage) ([]string, error) {
+func handleList(listMessage *ListMessage) ([]WatchEntry, error) {
+	region, err := getRegion(listMessage.Region)
+	if err != nil {
+		return []WatchEntry{}, err
+	}
+
 	if map[string]bool{"SKU": true, "KWD_QUERY": true}[listMessage.InputType] {
 		if listMessage.InputType == "SKU" {
 			statesNormalMu.Lock()
 			defer statesNormalMu.Unlock()
 
-			skus := []string{}
-			for _, state := range productStates.Normal.ProductStates {
-				skus = append(skus, state.Sku)
+			entries := []WatchEntry{}
+			for _, state := range region.normalTaskGroup.states.Normal.ProductStates {
+				entries = append(entries, WatchEntry{
+					Query:    state.Sku,
+					Metadata: state.Metadata,
+				})
 			}
 
-			return skus, nil
+			return entries, nil
 		} else {
 			statesLoadMu.Lock()
 			defer statesLoadMu.Unlock()
 
-			queries := make([]string, len(productStates.Load.KeywordQueries))
-			copy(queries, productStates.Load.KeywordQueries)
+			entries := []WatchEntry{}
+			for _, kwdQuery := range region.loadTaskGroup.states.Load.KeywordQueries {
+				entries = append(entries, WatchEntry{
+					Query:    kwdQuery,
+					Metadata: region.loadTaskGroup.states.LoadGetKwdMetadata(kwdQuery),
+				})
+			}
 
-			return queries, nil
+			return entries, nil
 		}
 	} else {
-		return []string{}, fmt.Errorf("unexpected input type: %s", listMessage.InputType)
+		return []WatchEntry{}, fmt.Errorf("unexpected input type: %s", listMessage.InputType)
 	}
 }
 
-func addSkuQuery(skuQuery string) {
-	normalTaskGroup.AddSkuQuery(skuQuery)
+func watchEntryQueries(entries []WatchEntry) []string {
+	queries := []string{}
+	for _, entry := range entries {
+		queries = append(queries, entry.Query)
+	}
+	return queries
 }
 
-func addKwdQuery(kwdQuery string) {
-	loadTaskGroup.AddKwdQuery(kwdQuery)
+// Copies the metadata sent with a message and stamps the time it was added
+func newWatchMetadata(metadata *WatchMetadata) *WatchMetadata {
+	newMetadata := &WatchMetadata{}
+	if metadata != nil {
+		*newMetadata = *metadata
+	}
+
+	newMetadata.AddedBy = strings.TrimSpace(newMetadata.AddedBy)
+	newMetadata.Note = strings.TrimSpace(newMetadata.Note)
+
+	if newMetadata.AddedAt.IsZero() {
+		newMetadata.AddedAt = time.Now()
+	}
+
+	return newMetadata
 }
 
-func removeSkuQuery(skuQuery string) {
-	normalTaskGroup.RemoveSkuQuery(skuQuery)
+// Applies all skus and keyword queries of the message at once and persists the product states once.
+// An invalid query rejects the whole batch
+func handleBulkAdd(bulkAddMessage *BulkAddMessage) ([]BulkItemResult, error) {
+	region, err := getRegion(bulkAddMessage.Region)
+	if err != nil {
+		return []BulkItemResult{}, err
+	}
+
+	kwdQueries := prefixKwdQueries(bulkAddMessage.KwdQueries)
+
+	err = validateBulkQueries(bulkAddMessage.Skus, kwdQueries)
+	if err != nil {
+		return []BulkItemResult{}, err
+	}
+
+	skuEntries := []WatchEntry{}
+	for _, sku := range bulkAddMessage.Skus {
+		skuEntries = append(skuEntries, WatchEntry{
+			Query:    sku,
+			Metadata: newWatchMetadata(bulkAddMessage.Metadata),
+		})
+	}
+
+	kwdEntries := []WatchEntry{}
+	for _, kwdQuery := range kwdQueries {
+		kwdEntries = append(kwdEntries, WatchEntry{
+			Query:    kwdQuery,
+			Metadata: newWatchMetadata(bulkAddMessage.Metadata),
+		})
+	}
+
+	lockRegionQueries([]*Region{region})
+	results := region.normalTaskGroup.addSkuQueries(skuEntries)
+	results = append(results, region.loadTaskGroup.addKwdQueries(kwdEntries)...)
+	unlockRegionQueries([]*Region{region})
+
+	persistBulkResults(results)
+
+	return results, nil
 }
 
-func removeKwdQuery(kwdQuery string) {
-	loadTaskGroup.RemoveKwdQuery(kwdQuery)
+func handleBulkRemove(bulkRemoveMessage *BulkRemoveMessage) ([]BulkItemResult, error) {
+	region, err := getRegion(bulkRemoveMessage.Region)
+	if err != nil {
+		return []BulkItemResult{}, err
+	}
+
+	lockRegionQueries([]*Region{region})
+	results := region.normalTaskGroup.removeSkuQueries(bulkRemoveMessage.Skus)
+	results = append(results, region.loadTaskGroup.removeKwdQueries(prefixKwdQueries(bulkRemoveMessage.KwdQueries))...)
+	unlockRegionQueries([]*Region{region})
+
+	persistBulkResults(results)
+
+	return results, nil
 }
 
-func checkSkuQueryMonitored(skuQuery string) bool {
+func handleExport(exportMessage *ExportMessage) (Watchlist, error) {
+	exportRegions := regions
+	if exportMessage.Region != "" {
+		region, err := getRegion(exportMessage.Region)
+		if err != nil {
+			return Watchlist{}, err
+		}
+
+		exportRegions = []*Region{region}
+	}
+
+	watchlist := Watchlist{
+		Regions: []RegionWatchlist{},
+	}
+
+	statesNormalMu.Lock()
+	statesLoadMu.Lock()
+	defer statesLoadMu.Unlock()
+	defer statesNormalMu.Unlock()
+
+	for _, region := range exportRegions {
+		regionWatchlist := RegionWatchlist{
+			Region:     region.name,
+			Skus:       []string{},
+			KwdQueries: []string{},
+			Metadata:   make(map[string]*WatchMetadata),
+		}
+
+		for _, state := range region.normalTaskGroup.states.Normal.ProductStates {
+			regionWatchlist.Skus = append(regionWatchlist.Skus, state.Sku)
+			if state.Metadata != nil {
+				regionWatchlist.Metadata[state.Sku] = state.Metadata
+			}
+		}
+
+		for _, kwdQuery := range region.loadTaskGroup.states.Load.KeywordQueries {
+			regionWatchlist.KwdQueries = append(regionWatchlist.KwdQueries, kwdQuery)
+			if metadata := region.loadTaskGroup.states.LoadGetKwdMetadata(kwdQuery); metadata != nil {
+				regionWatchlist.Metadata[kwdQuery] = metadata
+			}
+		}
+
+		watchlist.Regions = append(watchlist.Regions, regionWatchlist)
+	}
+
+	return watchlist, nil
+}
+
+// The whole watchlist is validated before anything is applied, so an unknown region or an invalid query
+// leaves every watchlist untouched. Tasks never see a partially applied import
+func handleImport(importMessage *ImportMessage) ([]BulkItemResult, error) {
+	importRegions := []*Region{}
+	for _, regionWatchlist := range importMessage.Watchlist.Regions {
+		region, err := getRegion(regionWatchlist.Region)
+		if err != nil {
+			return []BulkItemResult{}, err
+		}
+
+		for _, importRegion := range importRegions {
+			if importRegion == region {
+				return []BulkItemResult{}, fmt.Errorf("region %s listed more than once", region.name)
+			}
+		}
+
+		err = validateBulkQueries(regionWatchlist.Skus, prefixKwdQueries(regionWatchlist.KwdQueries))
+		if err != nil {
+			return []BulkItemResult{}, err
+		}
+
+		importRegions = append(importRegions, region)
+	}
+
+	lockRegionQueries(importRegions)
+
+	results := []BulkItemResult{}
+	for i, regionWatchlist := range importMessage.Watchlist.Regions {
+		region := importRegions[i]
+
+		kwdQueries := prefixKwdQueries(regionWatchlist.KwdQueries)
+
+		if importMessage.Replace {
+			staleSkus := missingQueries(region.normalTaskGroup.states.NormalGetAllSkus(), regionWatchlist.Skus, MakeSkuQuery)
+			staleKwdQueries := missingQueries(region.loadTaskGroup.states.Load.KeywordQueries, kwdQueries, normalizeKwdStr)
+
+			results = append(results, region.normalTaskGroup.removeSkuQueries(staleSkus)...)
+			results = append(results, region.loadTaskGroup.removeKwdQueries(staleKwdQueries)...)
+		}
+
+		skuEntries := []WatchEntry{}
+		for _, sku := range regionWatchlist.Skus {
+			skuEntries = append(skuEntries, WatchEntry{
+				Query:    sku,
+				Metadata: newWatchMetadata(regionWatchlist.Metadata[sku]),
+			})
+		}
+
+		kwdEntries := []WatchEntry{}
+		for i, kwdQuery := range kwdQueries {
+			kwdEntries = append(kwdEntries, WatchEntry{
+				Query:    kwdQuery,
+				Metadata: newWatchMetadata(regionWatchlist.Metadata[regionWatchlist.KwdQueries[i]]),
+			})
+		}
+
+		results = append(results, region.normalTaskGroup.addSkuQueries(skuEntries)...)
+		results = append(results, region.loadTaskGroup.addKwdQueries(kwdEntries)...)
+	}
+
+	unlockRegionQueries(importRegions)
+
+	persistBulkResults(results)
+
+	return results, nil
+}
+
+// Returns an error for the first empty sku or keyword query. Keyword queries must already carry their +/- prefix
+func validateBulkQueries(skus []string, kwdQueries []string) error {
+	for _, sku := range skus {
+		if MakeSkuQuery(sku) == "" {
+			return &InvalidQueryError{
+				queryType:  "SKU",
+				queryValue: sku,
+			}
+		}
+	}
+
+	for _, kwdQuery := range kwdQueries {
+		if kwdStr := normalizeKwdStr(kwdQuery); kwdStr == "" || kwdStr == "+" || kwdStr == "-" {
+			return &InvalidQueryError{
+				queryType:  "KEYWORD",
+				queryValue: kwdQuery,
+			}
+		}
+	}
+
+	return nil
+}
+
+// Takes the group locks of all regions, normal before load, then the states locks
+func lockRegionQueries(regions []*Region) {
+	for _, region := range regions {
+		region.normalTaskGroup.mu.Lock()
+		region.loadTaskGroup.mu.Lock()
+	}
+
+	statesNormalMu.Lock()
+	statesLoadMu.Lock()
+}
+
+func unlockRegionQueries(regions []*Region) {
+	statesLoadMu.Unlock()
+	statesNormalMu.Unlock()
+
+	for i := len(regions) - 1; i >= 0; i-- {
+		regions[i].loadTaskGroup.mu.Unlock()
+		regions[i].normalTaskGroup.mu.Unlock()
+	}
+}
+
+func prefixKwdQueries(kwdQueries []string) []string {
+	prefixed := []string{}
+	for _, kwdQuery := range kwdQueries {
+		kwdQuery = normalizeQueryInput(kwdQuery)
+		if kwdQuery != "" && kwdQuery[0] != '+' && kwdQuery[0] != '-' {
+			kwdQuery = fmt.Sprintf("+%s", kwdQuery)
+		}
+
+		prefixed = append(prefixed, kwdQuery)
+	}
+	return prefixed
+}
+
+// Returns the current queries that are not part of wanted, compared after normalizing both sides
+func missingQueries[T ~string](current []string, wanted []string, normalize func(string) T) []string {
+	wantedSet := make(map[T]bool)
+	for _, query := range wanted {
+		wantedSet[normalize(query)] = true
+	}
+
+	missing := []string{}
+	for _, query := range current {
+		if !wantedSet[normalize(query)] {
+			missing = append(missing, query)
+		}
+	}
+	return missing
+}
+
+// Writes the product states once if any item of the batch changed them
+func persistBulkResults(results []BulkItemResult) {
+	for _, result := range results {
+		if result.Status == BULK_STATUS_ADDED || result.Status == BULK_STATUS_REMOVED {
+			markProductStatesDirty()
+			return
+		}
+	}
+}
+
+func countBulkResults(results []BulkItemResult, status string) int {
+	count := 0
+	for _, result := range results {
+		if result.Status == status {
+			count += 1
+		}
+	}
+	return count
+}
+
+func addSkuQuery(region *Region, skuQuery string, metadata *WatchMetadata) {
+	region.normalTaskGroup.AddSkuQuery(skuQuery, metadata)
+}
+
+func addKwdQuery(region *Region, kwdQuery string, metadata *WatchMetadata) {
+	region.loadTaskGroup.AddKwdQuery(kwdQuery, metadata)
+}
+
+func removeSkuQuery(region *Region, skuQuery string) {
+	region.normalTaskGroup.RemoveSkuQuery(skuQuery)
+}
+
+func removeKwdQuery(region *Region, kwdQuery string) {
+	region.loadTaskGroup.RemoveKwdQuery(kwdQuery)
+}
+
+func checkSkuQueryMonitored(region *Region, skuQuery string) bool {
 	statesNormalMu.Lock()
 	defer statesNormalMu.Unlock()
 
 	skuQuery = strings.ToUpper(strings.TrimSpace(skuQuery))
 
-	for _, state := range productStates.Normal.ProductStates {
+	for _, state := range region.normalTaskGroup.states.Normal.ProductStates {
 		if state.Sku == skuQuery {
 			return true
 		}
@@ -128,16 +459,24 @@ func checkSkuQueryMonitored(skuQuery string) bool {
 	return false
 }
 
-func checkKwdQueryMonitored(kwdQuery string) bool {
+func checkKwdQueryMonitored(region *Region, kwdQuery string) bool {
 	statesLoadMu.Lock()
 	defer statesLoadMu.Unlock()
 
 	kwdQuery = strings.ToLower(strings.TrimSpace(kwdQuery))
 
-	for _, kwd := range productStates.Load.KeywordQueries {
+	for _, kwd := range region.loadTaskGroup.states.Load.KeywordQueries {
 		if strings.ToLower(kwd) == kwdQuery {
 			return true
 		}
 	}
 	return false
 }
+
+func normalizeQueryInput(query string) string {
+	query = strings.TrimSpace(query)
+	for strings.Contains(query, "  ") {
+		query = strings.Replace(query, "  ", " ", -1)
+	}
+	return query
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"testing"
+)
+
+func bulkStatuses(results []BulkItemResult) map[string]string {
+	statuses := make(map[string]string)
+	for _, result := range results {
+		statuses[result.Query] = result.Status
+	}
+	return statuses
+}
+
+func TestRemoveSkuQueryKeepsRoundRobinPosition(t *testing.T) {
+	region := setupTestRegion(t)
+	group := region.normalTaskGroup
+
+	for _, sku := range []string{"A", "B", "C"} {
+		group.AddSkuQuery(sku, nil)
+	}
+
+	// The next check starts at the last sku, removing it must not leave the position out of range
+	group.nextPosToCheck = 2
+	group.RemoveSkuQuery("C")
+
+	if group.nextPosToCheck != 0 {
+		t.Errorf("got position %d after removing the last sku, want 0", group.nextPosToCheck)
+	}
+	if skus := group.getNextSkus(); len(skus) != 2 || skus[0] != "A" {
+		t.Errorf("got next skus %v, want [A B]", skus)
+	}
+
+	// Removing a sku before the position keeps the next sku
+	group.AddSkuQuery("C", nil)
+	group.nextPosToCheck = 2
+	group.RemoveSkuQuery("A")
+
+	if skus := group.getNextSkus(); len(skus) != 2 || skus[0] != "C" {
+		t.Errorf("got next skus %v, want [C B]", skus)
+	}
+}
+
+func TestHandleBulkAdd(t *testing.T) {
+	region := setupTestRegion(t)
+	region.normalTaskGroup.AddSkuQuery("DV0833-104", nil)
+
+	results, err := handleBulkAdd(&BulkAddMessage{
+		Region:     "eu",
+		Skus:       []string{"dv0833-104", " fq8138-002 "},
+		KwdQueries: []string{"dunk low", "-kids"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"DV0833-104": BULK_STATUS_ALREADY_MONITORED,
+		"FQ8138-002": BULK_STATUS_ADDED,
+		"+dunk low":  BULK_STATUS_ADDED,
+		"-kids":      BULK_STATUS_ADDED,
+	}
+	statuses := bulkStatuses(results)
+	for query, status := range want {
+		if statuses[query] != status {
+			t.Errorf("%s: got status %q, want %q", query, statuses[query], status)
+		}
+	}
+
+	if !region.normalTaskGroup.isNormalSku("FQ8138-002") {
+		t.Errorf("FQ8138-002 not monitored after bulk add")
+	}
+}
+
+func TestHandleBulkAddRejectsInvalidBatch(t *testing.T) {
+	region := setupTestRegion(t)
+
+	_, err := handleBulkAdd(&BulkAddMessage{
+		Region:     "EU",
+		Skus:       []string{"FQ8138-002"},
+		KwdQueries: []string{"+dunk", "  "},
+	})
+	if _, ok := err.(*InvalidQueryError); !ok {
+		t.Fatalf("got error %v, want an InvalidQueryError", err)
+	}
+
+	if region.normalTaskGroup.isNormalSku("FQ8138-002") {
+		t.Errorf("sku of a rejected batch was added")
+	}
+	if len(region.loadTaskGroup.kwdQueries) != 0 {
+		t.Errorf("got %d keyword queries after a rejected batch, want 0", len(region.loadTaskGroup.kwdQueries))
+	}
+}
+
+func TestHandleImportReplace(t *testing.T) {
+	region := setupTestRegion(t)
+	region.normalTaskGroup.AddSkuQuery("DV0833-104", nil)
+	region.normalTaskGroup.AddSkuQuery("STALE-1", nil)
+	region.loadTaskGroup.AddKwdQuery("+stale", nil)
+
+	results, err := handleImport(&ImportMessage{
+		Replace: true,
+		Watchlist: Watchlist{Regions: []RegionWatchlist{{
+			Region:     "EU",
+			Skus:       []string{"DV0833-104", "FQ8138-002"},
+			KwdQueries: []string{"dunk"},
+		}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"STALE-1":    BULK_STATUS_REMOVED,
+		"+stale":     BULK_STATUS_REMOVED,
+		"DV0833-104": BULK_STATUS_ALREADY_MONITORED,
+		"FQ8138-002": BULK_STATUS_ADDED,
+		"+dunk":      BULK_STATUS_ADDED,
+	}
+	statuses := bulkStatuses(results)
+	for query, status := range want {
+		if statuses[query] != status {
+			t.Errorf("%s: got status %q, want %q", query, statuses[query], status)
+		}
+	}
+
+	if skus := region.normalTaskGroup.getNextSkus(); len(skus) != 2 {
+		t.Errorf("got skus %v after import, want 2", skus)
+	}
+}
+
+func TestHandleImportRejectsInvalidWatchlist(t *testing.T) {
+	region := setupTestRegion(t)
+	region.normalTaskGroup.AddSkuQuery("DV0833-104", nil)
+
+	watchlists := map[string][]RegionWatchlist{
+		"unknown region": {
+			{Region: "EU", Skus: []string{"FQ8138-002"}},
+			{Region: "XX", Skus: []string{"FQ8138-002"}},
+		},
+		"region twice": {
+			{Region: "EU", Skus: []string{"FQ8138-002"}},
+			{Region: "eu", Skus: []string{"FQ8138-003"}},
+		},
+		"empty sku": {
+			{Region: "EU", Skus: []string{"FQ8138-002", ""}},
+		},
+	}
+
+	for name, regionWatchlists := range watchlists {
+		_, err := handleImport(&ImportMessage{
+			Replace:   true,
+			Watchlist: Watchlist{Regions: regionWatchlists},
+		})
+		if err == nil {
+			t.Errorf("%s: import accepted", name)
+		}
+
+		if skus := region.normalTaskGroup.getNextSkus(); len(skus) != 1 || skus[0] != "DV0833-104" {
+			t.Errorf("%s: got skus %v after a rejected import, want [DV0833-104]", name, skus)
+		}
+	}
+}
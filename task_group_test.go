@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Collects the events posted to the json sink and counts the posts of every path
+type testWebhookSink struct {
+	mu     sync.Mutex
+	events []*Event
+	posts  map[string]int
+}
+
+func (s *testWebhookSink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var payload JsonNotificationPayload
+
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	if s.posts == nil {
+		s.posts = make(map[string]int)
+	}
+	s.posts[r.URL.Path] += 1
+	if payload.Event != nil {
+		s.events = append(s.events, payload.Event)
+	}
+	s.mu.Unlock()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *testWebhookSink) postsTo(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.posts[path]
+}
+
+func (s *testWebhookSink) eventsFor(kind string, sku string) []*Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events := []*Event{}
+	for _, event := range s.events {
+		if event.Kind == kind && event.Product.Sku == sku {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+type testRegion struct {
+	mock            *MockServer
+	sink            *testWebhookSink
+	sinkUrl         string
+	normalTaskGroup *NormalTaskGroup
+	loadTaskGroup   *LoadTaskGroup
+}
+
+// Sets up the globals for the region EU that requests the mock server and notifies a local json sink.
+// Every event kind goes to the json sink, the cooldown is off
+func setupTestRegion(t *testing.T) *testRegion {
+	t.Helper()
+
+	oldConfig, oldProductStates, oldProxyHandler, oldWebhookHandler, oldRegions := config, productStates, proxyHandler, webhookHandler, regions
+	t.Cleanup(func() {
+		config, productStates, proxyHandler, webhookHandler, regions = oldConfig, oldProductStates, oldProxyHandler, oldWebhookHandler, oldRegions
+	})
+
+	mock := &MockServer{}
+	mockServer := httptest.NewServer(mock.handler())
+	t.Cleanup(mockServer.Close)
+
+	sink := &testWebhookSink{}
+	sinkServer := httptest.NewServer(sink)
+	t.Cleanup(sinkServer.Close)
+
+	testConfig := defaultConfig
+	testConfig.LoadTask.Timeout = 0
+	testConfig.Endpoints.NewArrivalsBaseUrl = mockServer.URL
+	testConfig.Endpoints.ProductsBaseUrl = mockServer.URL
+	testConfig.Notifiers.Events = map[string][]string{
+		EVENT_RESTOCK:   {SINK_JSON},
+		EVENT_PRICE:     {SINK_JSON},
+		EVENT_AVAILABLE: {SINK_JSON},
+		EVENT_LOAD:      {SINK_JSON},
+	}
+	testConfig.Notifiers.Json.Urls = []string{sinkServer.URL}
+	testConfig.Cooldown = CooldownConfig{}
+
+	config = &testConfig
+	productStates = &ProductStates{}
+	proxyHandler = NewProxyHandler(nil)
+	webhookHandler = NewWebhookHandler()
+	webhookHandler.Start()
+
+	backend, err := NewStoreBackend(config.Backend, defaultRegionConfig)
+	if err != nil {
+		t.Fatalf("error creating store backend: %v", err)
+	}
+
+	regionName := "EU"
+	regionStates := productStates.GetRegion(regionName)
+
+	normalTaskGroup, err := NewNormalTaskGroup(regionName, regionStates, backend, proxyHandler, webhookHandler, nil)
+	if err != nil {
+		t.Fatalf("error creating normal task group: %v", err)
+	}
+	loadTaskGroup, err := NewLoadTaskGroup(regionName, regionStates, backend, proxyHandler, webhookHandler, "", nil)
+	if err != nil {
+		t.Fatalf("error creating load task group: %v", err)
+	}
+
+	normalTaskGroup.LinkToLoadTaskGroup(loadTaskGroup)
+	loadTaskGroup.LinkToNormalTaskGroup(normalTaskGroup)
+
+	regions = []*Region{{
+		name:            regionName,
+		normalTaskGroup: normalTaskGroup,
+		loadTaskGroup:   loadTaskGroup,
+	}}
+
+	return &testRegion{
+		mock:            mock,
+		sink:            sink,
+		sinkUrl:         sinkServer.URL,
+		normalTaskGroup: normalTaskGroup,
+		loadTaskGroup:   loadTaskGroup,
+	}
+}
+
+// Waits for the response handling in flight and sends all queued notifications
+func (r *testRegion) drain() {
+	tasksWg.Wait()
+	webhookHandler.Stop()
+}
+
+// One iteration of NormalTask.loopMonitor without the timeout
+func (r *testRegion) checkNormal(t *testing.T, task *NormalTask) {
+	t.Helper()
+
+	skus := r.normalTaskGroup.getNextSkus()
+
+	products, err := task.getProductsBySku(skus)
+	if err != nil {
+		t.Fatalf("error requesting products: %v", err)
+	}
+
+	r.normalTaskGroup.checkProducts(products, skus)
+}
+
+func TestNormalTaskGroupNotifiesRestock(t *testing.T) {
+	region := setupTestRegion(t)
+	region.mock.productsBySku.reset([]MockStep{
+		{File: "products_by_sku_sold_out.json"},
+		{File: "products_by_sku.json"},
+	})
+
+	region.normalTaskGroup.AddSkuQuery("DV0833-104", nil)
+	region.normalTaskGroup.AddSkuQuery("FQ8138-002", nil)
+
+	task, err := NewNormalTask("EU NORMAL: 00", region.normalTaskGroup)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Sold out, then sizes of both products come back
+	region.checkNormal(t, task)
+	region.checkNormal(t, task)
+	region.drain()
+
+	wantSizes := map[string]int{"DV0833-104": 2, "FQ8138-002": 1}
+	for sku, numSizes := range wantSizes {
+		restocks := region.sink.eventsFor(EVENT_RESTOCK, sku)
+		if len(restocks) != 1 {
+			t.Fatalf("got %d restock events for %s, want 1 (all events: %d)", len(restocks), sku, len(region.sink.events))
+		}
+		if len(restocks[0].Diff.NewSizes) != numSizes {
+			t.Errorf("%s: got new sizes %v, want %d sizes", sku, restocks[0].Diff.NewSizes, numSizes)
+		}
+		if restocks[0].Region != "EU" {
+			t.Errorf("%s: got region %q, want EU", sku, restocks[0].Region)
+		}
+	}
+}
+
+func TestLoadTaskGroupNotifiesMatchingNewArrivals(t *testing.T) {
+	region := setupTestRegion(t)
+	region.mock.newArrivals.reset([]MockStep{{File: "new_arrivals.json"}})
+	region.mock.productsBySku.reset([]MockStep{{File: "products_by_sku.json"}})
+
+	region.loadTaskGroup.AddKwdQuery("+dunk", nil)
+
+	task, err := NewLoadTask("EU LOAD: 00", region.loadTaskGroup)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newArrivals, err := task.getNewArrivalsPages()
+	if err != nil {
+		t.Fatalf("error requesting new arrivals: %v", err)
+	}
+	if len(newArrivals) != 2 {
+		t.Fatalf("got %d new arrivals, want 2", len(newArrivals))
+	}
+
+	region.loadTaskGroup.handleNewArrivals(newArrivals)
+	tasksWg.Wait()
+
+	if pid := region.loadTaskGroup.getLastKnownPid(); pid != newArrivals[0].Pid {
+		t.Errorf("got last known pid %q, want %q", pid, newArrivals[0].Pid)
+	}
+
+	// Known products are not loaded again
+	region.loadTaskGroup.handleNewArrivals(newArrivals)
+	region.drain()
+
+	loads := region.sink.eventsFor(EVENT_LOAD, "DV0833-104")
+	if len(loads) != 1 {
+		t.Fatalf("got %d load events for DV0833-104, want 1 (all events: %d)", len(loads), len(region.sink.events))
+	}
+	if len(loads[0].MatchedQueries) != 1 || loads[0].MatchedQueries[0] != "+dunk" {
+		t.Errorf("got matched queries %v, want [+dunk]", loads[0].MatchedQueries)
+	}
+
+	if events := region.sink.eventsFor(EVENT_LOAD, "FQ8138-002"); len(events) != 0 {
+		t.Errorf("got %d load events for FQ8138-002, want 0", len(events))
+	}
+}
+
+func TestLoadTaskGroupSkipsNormalSkus(t *testing.T) {
+	region := setupTestRegion(t)
+	region.mock.productsBySku.reset([]MockStep{{File: "products_by_sku.json"}})
+
+	region.loadTaskGroup.AddKwdQuery("+nike", nil)
+	region.normalTaskGroup.AddSkuQuery("DV0833-104", nil)
+
+	region.loadTaskGroup.handleNewArrivals([]NewArrival{
+		{Pid: "1000002", Sku: "DV0833-104"},
+		{Pid: "1000001", Sku: "FQ8138-002"},
+	})
+	region.drain()
+
+	if events := region.sink.eventsFor(EVENT_LOAD, "DV0833-104"); len(events) != 0 {
+		t.Errorf("got %d load events for the normal sku DV0833-104, want 0", len(events))
+	}
+	if events := region.sink.eventsFor(EVENT_LOAD, "FQ8138-002"); len(events) != 1 {
+		t.Errorf("got %d load events for FQ8138-002, want 1", len(events))
+	}
+}
+
+func TestStopInterruptsLoadCheckRetries(t *testing.T) {
+	region := setupTestRegion(t)
+	region.mock.productsBySku.reset([]MockStep{{File: "products_by_sku.json"}})
+
+	// The product is not loaded yet, every retry waits for the timeout
+	config.LoadTask.Timeout = 60000
+
+	doneCh := make(chan struct{})
+	go func() {
+		region.loadTaskGroup.loadCheckSkus([]SkuQuery{"NOT-LOADED-1"})
+		close(doneCh)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	region.loadTaskGroup.StopAllTasks()
+
+	select {
+	case <-doneCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("load check still retrying after the group was stopped")
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import "fmt"
+
+const TELEGRAM_API_URL = "https://api.telegram.org"
+
+type TelegramNotifier struct {
+	botToken string
+	chatIds  []string
+}
+
+// Take configMu RLock before calling NewTelegramNotifier!
+func NewTelegramNotifier() *TelegramNotifier {
+	return &TelegramNotifier{
+		botToken: config.Notifiers.Telegram.BotToken,
+		chatIds:  config.Notifiers.Telegram.ChatIds,
+	}
+}
+
+func (n *TelegramNotifier) Name() string {
+	return SINK_TELEGRAM
+}
+
+// Sends a plain text message through the Bot API sendMessage method to every configured chat
+func (n *TelegramNotifier) Notify(event *Event) error {
+	if n.botToken == "" {
+		return fmt.Errorf("no bot token configured")
+	}
+
+	text, ok := notificationTemplates.Render(SINK_TELEGRAM, event)
+	if !ok {
+		text = formatEventText(event, func(text string, url string) string {
+			return fmt.Sprintf("%s: %s", text, url)
+		})
+	}
+
+	deliveryErr := &DeliveryError{}
+	for _, chatId := range n.chatIds {
+		payload := map[string]any{
+			"chat_id": chatId,
+			"text":    text,
+		}
+
+		err := postJson(fmt.Sprintf("%s/bot%s/sendMessage", TELEGRAM_API_URL, n.botToken), payload)
+		if err != nil {
+			deliveryErr.add(chatId, err)
+		}
+	}
+
+	return deliveryErr.orNil()
+}
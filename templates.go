@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Notification templates live in ./templates/<sink>/<event>.tmpl, e.g. templates/discord/restock.tmpl.
+// templates/<sink>/default.tmpl is used for event types without their own template.
+// Templates are executed with the *Event as data. Discord templates must render an embed as json,
+// json and file templates the raw payload, telegram and slack templates the message text
+var notificationTemplates *TemplateStore = NewTemplateStore()
+
+const TEMPLATE_DEFAULT_NAME = "default"
+
+var templateFuncs template.FuncMap = template.FuncMap{
+	"json": func(value any) (string, error) {
+		bytes, err := json.Marshal(value)
+		return string(bytes), err
+	},
+	"join":  strings.Join,
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"trim":  strings.TrimSpace,
+}
+
+type TemplateStore struct {
+	mu        sync.RWMutex
+	templates map[string]*template.Template // Keyed by <sink>/<event>
+	modTimes  map[string]time.Time
+	logger    *Logger
+}
+
+func NewTemplateStore() *TemplateStore {
+	return &TemplateStore{
+		mu:        sync.RWMutex{},
+		templates: make(map[string]*template.Template),
+		modTimes:  make(map[string]time.Time),
+		logger:    NewLogger("TEMPLATES"),
+	}
+}
+
+// Parses new and changed template files and drops deleted ones. A template that fails to parse keeps its last good version
+func (s *TemplateStore) Reload() {
+	found := make(map[string]bool)
+
+	sinkEntries, err := os.ReadDir(pathTemplatesFolder)
+	if err != nil && !os.IsNotExist(err) {
+		s.logger.Red(fmt.Sprintf("Error reading templates folder: %v", err))
+		return
+	}
+
+	for _, sinkEntry := range sinkEntries {
+		if !sinkEntry.IsDir() {
+			continue
+		}
+
+		sinkFolder := filepath.Join(pathTemplatesFolder, sinkEntry.Name())
+
+		templateEntries, err := os.ReadDir(sinkFolder)
+		if err != nil {
+			s.logger.Red(fmt.Sprintf("Error reading \"%s\": %v", sinkFolder, err))
+			continue
+		}
+
+		for _, templateEntry := range templateEntries {
+			if templateEntry.IsDir() || filepath.Ext(templateEntry.Name()) != ".tmpl" {
+				continue
+			}
+
+			key := templateKey(sinkEntry.Name(), strings.TrimSuffix(templateEntry.Name(), ".tmpl"))
+			found[key] = true
+
+			info, err := templateEntry.Info()
+			if err != nil {
+				continue
+			}
+
+			s.mu.RLock()
+			modTime, known := s.modTimes[key]
+			s.mu.RUnlock()
+
+			if known && modTime.Equal(info.ModTime()) {
+				continue
+			}
+
+			s.parse(key, filepath.Join(sinkFolder, templateEntry.Name()), info.ModTime())
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key := range s.modTimes {
+		if !found[key] {
+			delete(s.templates, key)
+			delete(s.modTimes, key)
+
+			s.logger.Yellow(fmt.Sprintf("Removed template %s", key))
+		}
+	}
+}
+
+func (s *TemplateStore) parse(key string, path string, modTime time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Remember the mod time even on failure, so a broken file is only reported once per change
+	s.modTimes[key] = modTime
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		s.logger.Red(fmt.Sprintf("Error reading template %s: %v", key, err))
+		return
+	}
+
+	tmpl, err := template.New(key).Funcs(templateFuncs).Option("missingkey=error").Parse(string(content))
+	if err != nil {
+		s.logger.Red(fmt.Sprintf("Error parsing template %s: %v", key, err))
+		return
+	}
+
+	s.templates[key] = tmpl
+
+	s.logger.White(fmt.Sprintf("Loaded template %s", key))
+}
+
+// Renders the template of the sink for the event kind. Returns false if the sink has no template for it
+// or the template fails, in which case the built-in formatter of the sink is used
+func (s *TemplateStore) Render(sinkName string, event *Event) (string, bool) {
+	s.mu.RLock()
+	tmpl, ok := s.templates[templateKey(sinkName, event.Kind)]
+	if !ok {
+		tmpl, ok = s.templates[templateKey(sinkName, TEMPLATE_DEFAULT_NAME)]
+	}
+	s.mu.RUnlock()
+
+	if !ok {
+		return "", false
+	}
+
+	var out bytes.Buffer
+
+	err := tmpl.Execute(&out, event)
+	if err != nil {
+		s.logger.Red(fmt.Sprintf("Error executing template %s: %v", tmpl.Name(), err))
+		return "", false
+	}
+
+	return out.String(), true
+}
+
+func templateKey(sinkName string, name string) string {
+	return fmt.Sprintf("%s/%s", strings.ToLower(sinkName), strings.ToLower(name))
+}
@@ -0,0 +1,6 @@
+//go:build !windows
+
+package main
+
+// Other terminals handle the escape sequences without setup
+func enableVirtualTerminalProcessing() {}
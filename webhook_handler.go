@@ -1,587 +1,292 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
-	"strconv"
-	"strings"
 	"sync"
 	"time"
-
-	discordwebhook "github.com/bensch777/discord-webhook-golang"
 )
 
-const (
-	DEFAULT_ICON_URL    = "https://cdn.discordapp.com/attachments/1008077694409379962/1169271584805097543/0a4de578debc18ad1448c3bb14197df1.png?ex=66db0805&is=66d9b685&hm=10bcd145748d5ead3fe4ff8876b5ec7578830915862176d50374c43ea65e695f&"
-	DEFAULT_COLOR       = 10104109
-	DEFAULT_FOOTER_TEXT = "Monitor by linus"
-)
+const WEBHOOK_QUEUE_SIZE = 1000
 
+// Queues events and hands them to the notifiers configured for their kind.
+// One queue and worker per sink, so a slow sink only delays its own notifications
 type WebhookHandler struct {
-	wg     sync.WaitGroup
-	reqCh  chan *webhookRequest
-	logger *Logger
+	mu       sync.Mutex
+	started  bool
+	stopping bool // No new events once set
+	closed   bool // No new jobs once set
+	queues   map[string]*sinkQueue
+	cooldown *NotificationCooldown
+	logger   *Logger
+}
+
+type sinkQueue struct {
+	sinkName string
+	ch       chan *notifierJob
+	doneCh   chan struct{}
 }
 
 func NewWebhookHandler() *WebhookHandler {
-	return &WebhookHandler{
-		wg:     sync.WaitGroup{},
-		reqCh:  make(chan *webhookRequest),
+	webhookHandler := &WebhookHandler{
+		queues: make(map[string]*sinkQueue),
 		logger: NewLogger("WEBHOOK"),
 	}
+	webhookHandler.cooldown = NewNotificationCooldown(webhookHandler.dispatch)
+
+	return webhookHandler
 }
 
-type webhookRequest struct {
-	productData ProductData
-	webhookUrl  string
-	fields      []discordwebhook.Field
-	time        time.Time
+type notifierJob struct {
+	notifier  Notifier
+	event     *Event
+	outboxKey string
 }
 
+// Starts the workers. Jobs queued before are kept until then
 func (w *WebhookHandler) Start() {
-	go func() {
-		for req := range w.reqCh {
-			embed, err := w.createEmbed(req)
-			if err != nil {
-				w.logger.Red(fmt.Sprintf("Create embed: %v", err))
-				continue
-			}
+	w.mu.Lock()
+	defer w.mu.Unlock()
 
-			w.sendEmbed(req.webhookUrl, embed)
-		}
-	}()
+	w.startWorkers()
 }
 
-func (w *WebhookHandler) Stop() {
-	w.wg.Wait()
-	close(w.reqCh)
-}
+// Take w.mu Lock before calling startWorkers!
+func (w *WebhookHandler) startWorkers() {
+	if w.started {
+		return
+	}
+	w.started = true
 
-func (w *WebhookHandler) enqueueReq(req *webhookRequest) {
-	w.wg.Add(1)
-	go func() {
-		w.reqCh <- req
-		w.wg.Done()
-	}()
+	for _, queue := range w.queues {
+		go w.run(queue)
+	}
 }
 
-func (w *WebhookHandler) NotifyRestock(productData ProductData) {
-	configMu.RLock()
-	defer configMu.RUnlock()
-
-	for _, webhookUrl := range config.NormalTask.WebhookUrls {
-		sizesValues := []string{}
-		sizesValuesCount := 0
-		if len(productData.AvailableSizes) > 25 {
-			count := 0
-			for _, availableSize := range productData.AvailableSizes {
-				strVal := fmt.Sprintf("%s [%d]", availableSize.Name, availableSize.AmountInStock)
-
-				if count == 25 {
-					count = 0
-					sizesValuesCount += 1
-				}
-
-				sizesValues[count] = fmt.Sprintf("%s\n%s", sizesValues[count], strVal)
-
-				count += 1
-			}
-		} else {
-			finalStr := ""
-			for _, availableSize := range productData.AvailableSizes {
-				strVal := fmt.Sprintf("%s [%d]", availableSize.Name, availableSize.AmountInStock)
-
-				finalStr = fmt.Sprintf("%s\n%s", finalStr, strVal)
-			}
-
-			sizesValues = append(sizesValues, finalStr)
-		}
-
-		fields := []discordwebhook.Field{
-			{
-				Name:   "SKU/PID",
-				Value:  productData.Sku,
-				Inline: true,
-			},
-			{
-				Name:   "PRICE",
-				Value:  productData.Price,
-				Inline: true,
-			},
-			{
-				Name:   "TYPE",
-				Value:  "RESTOCK",
-				Inline: true,
-			},
-		}
-
-		if !productData.AvailableForSale {
-			availableField := discordwebhook.Field{
-				Name:   "AVAILABLE",
-				Value:  "false",
-				Inline: true,
-			}
-			fields = append(fields, availableField)
-		}
-
-		if len(sizesValues) == 1 {
-			if sizesValues[0] == "" {
-				sizesField := discordwebhook.Field{
-					Name:   "Sizes",
-					Value:  "*none*",
-					Inline: false,
-				}
-				fields = append(fields, sizesField)
-			} else {
-				sizesField := discordwebhook.Field{
-					Name:   "Sizes",
-					Value:  sizesValues[0],
-					Inline: false,
-				}
-				fields = append(fields, sizesField)
-			}
-		} else {
-			for i, sizesValue := range sizesValues {
-				sizesField := discordwebhook.Field{
-					Name:   fmt.Sprintf("Sizes %d", i+1),
-					Value:  sizesValue,
-					Inline: !(map[int]bool{0: true}[i]),
-				}
-				fields = append(fields, sizesField)
-			}
-		}
+func (w *WebhookHandler) run(queue *sinkQueue) {
+	defer close(queue.doneCh)
 
-		extraField := discordwebhook.Field{
-			Name:   "Extra",
-			Value:  fmt.Sprintf("[**StockX**](https://stockx.com/search?s=%s) | [App Link](https://applinks.sneakersnstuff.com/product/%s)", productData.Sku, productData.Sku),
-			Inline: false,
+	for job := range queue.ch {
+		err := job.notifier.Notify(job.event)
+		if err != nil {
+			w.deadLetterJob(job, err)
 		}
-		fields = append(fields, extraField)
 
-		wreq := webhookRequest{
-			productData: productData,
-			time:        time.Now(),
-			webhookUrl:  webhookUrl,
-			fields:      fields,
-		}
-
-		w.enqueueReq(&wreq)
+		notificationOutbox.Done(job.outboxKey)
 	}
 }
 
-func (w *WebhookHandler) NotifyPrice(productData ProductData, oldPrice string) {
-	configMu.RLock()
-	defer configMu.RUnlock()
-
-	for _, webhookUrl := range config.NormalTask.WebhookUrls {
-		sizesValues := []string{}
-		sizesValuesCount := 0
-		if len(productData.AvailableSizes) > 25 {
-			count := 0
-			for _, availableSize := range productData.AvailableSizes {
-				strVal := fmt.Sprintf("%s [%d]", availableSize.Name, availableSize.AmountInStock)
+// Writes one dead letter per failed target, so replaying them does not repeat the delivered targets
+func (w *WebhookHandler) deadLetterJob(job *notifierJob, err error) {
+	eventBytes, _ := json.Marshal(job.event)
 
-				if count == 25 {
-					count = 0
-					sizesValuesCount += 1
-				}
-
-				sizesValues[count] = fmt.Sprintf("%s\n%s", sizesValues[count], strVal)
-
-				count += 1
-			}
-		} else {
-			finalStr := ""
-			for _, availableSize := range productData.AvailableSizes {
-				strVal := fmt.Sprintf("%s [%d]", availableSize.Name, availableSize.AmountInStock)
+	var deliveryErr *DeliveryError
+	if !errors.As(err, &deliveryErr) {
+		w.logger.Red(fmt.Sprintf("%s: %s notification for %s failed: %v", job.notifier.Name(), job.event.Kind, job.event.Product.Sku, err))
 
-				finalStr = fmt.Sprintf("%s\n%s", finalStr, strVal)
-			}
-
-			sizesValues = append(sizesValues, finalStr)
-		}
-
-		fields := []discordwebhook.Field{
-			{
-				Name:   "SKU/PID",
-				Value:  productData.Sku,
-				Inline: true,
-			},
-			{
-				Name:   "PRICE",
-				Value:  fmt.Sprintf("%s -> %s", oldPrice, productData.Price),
-				Inline: true,
-			},
-			{
-				Name:   "TYPE",
-				Value:  "PRICE CHANGE",
-				Inline: true,
-			},
-		}
+		writeDeadLetter(job.notifier.Name(), "", job.event.Kind, job.event.Product.Sku, err.Error(), eventBytes)
+		return
+	}
 
-		if !productData.AvailableForSale {
-			availableField := discordwebhook.Field{
-				Name:   "AVAILABLE",
-				Value:  "false",
-				Inline: true,
-			}
-			fields = append(fields, availableField)
-		}
+	for _, failure := range deliveryErr.failures {
+		w.logger.Red(fmt.Sprintf("%s: %s notification for %s to %s failed: %v", job.notifier.Name(), job.event.Kind, job.event.Product.Sku, redactUrl(failure.target), failure.err))
 
-		if len(sizesValues) == 1 {
-			if sizesValues[0] == "" {
-				sizesField := discordwebhook.Field{
-					Name:   "Sizes",
-					Value:  "*none*",
-					Inline: false,
-				}
-				fields = append(fields, sizesField)
-			} else {
-				sizesField := discordwebhook.Field{
-					Name:   "Sizes",
-					Value:  sizesValues[0],
-					Inline: false,
-				}
-				fields = append(fields, sizesField)
-			}
-		} else {
-			for i, sizesValue := range sizesValues {
-				sizesField := discordwebhook.Field{
-					Name:   fmt.Sprintf("Sizes %d", i+1),
-					Value:  sizesValue,
-					Inline: !(map[int]bool{0: true}[i]),
-				}
-				fields = append(fields, sizesField)
-			}
-		}
+		writeDeadLetter(job.notifier.Name(), failure.target, job.event.Kind, job.event.Product.Sku, failure.err.Error(), eventBytes)
+	}
+}
 
-		extraField := discordwebhook.Field{
-			Name:   "Extra",
-			Value:  fmt.Sprintf("[**StockX**](https://stockx.com/search?s=%s) | [App Link](https://applinks.sneakersnstuff.com/product/%s)", productData.Sku, productData.Sku),
-			Inline: false,
+// Take w.mu Lock before calling getQueue! Creates the queue of the sink on first use
+func (w *WebhookHandler) getQueue(sinkName string) *sinkQueue {
+	queue, ok := w.queues[sinkName]
+	if !ok {
+		queue = &sinkQueue{
+			sinkName: sinkName,
+			ch:       make(chan *notifierJob, WEBHOOK_QUEUE_SIZE),
+			doneCh:   make(chan struct{}),
 		}
-		fields = append(fields, extraField)
+		w.queues[sinkName] = queue
 
-		wreq := webhookRequest{
-			productData: productData,
-			time:        time.Now(),
-			webhookUrl:  webhookUrl,
-			fields:      fields,
+		if w.started {
+			go w.run(queue)
 		}
-
-		w.enqueueReq(&wreq)
 	}
-}
 
-func (w *WebhookHandler) NotifyAvailable(productData ProductData) {
-	configMu.RLock()
-	defer configMu.RUnlock()
-
-	for _, webhookUrl := range config.NormalTask.WebhookUrls {
-		sizesValues := []string{}
-		sizesValuesCount := 0
-		if len(productData.AvailableSizes) > 25 {
-			count := 0
-			for _, availableSize := range productData.AvailableSizes {
-				strVal := fmt.Sprintf("%s [%d]", availableSize.Name, availableSize.AmountInStock)
-
-				if count == 25 {
-					count = 0
-					sizesValuesCount += 1
-				}
+	return queue
+}
 
-				sizesValues[count] = fmt.Sprintf("%s\n%s", sizesValues[count], strVal)
+// Waits until every queued event was handed to its notifiers and the discord queues are drained
+func (w *WebhookHandler) Stop() {
+	w.mu.Lock()
+	w.stopping = true
+	w.mu.Unlock()
 
-				count += 1
-			}
-		} else {
-			finalStr := ""
-			for _, availableSize := range productData.AvailableSizes {
-				strVal := fmt.Sprintf("%s [%d]", availableSize.Name, availableSize.AmountInStock)
+	w.cooldown.Flush()
 
-				finalStr = fmt.Sprintf("%s\n%s", finalStr, strVal)
-			}
+	w.mu.Lock()
+	w.closed = true
+	w.startWorkers()
 
-			sizesValues = append(sizesValues, finalStr)
-		}
+	queues := []*sinkQueue{}
+	for _, queue := range w.queues {
+		close(queue.ch)
+		queues = append(queues, queue)
+	}
+	w.mu.Unlock()
 
-		fields := []discordwebhook.Field{
-			{
-				Name:   "SKU/PID",
-				Value:  productData.Sku,
-				Inline: true,
-			},
-			{
-				Name:   "PRICE",
-				Value:  productData.Price,
-				Inline: true,
-			},
-			{
-				Name:   "TYPE",
-				Value:  "AVAILABILITY CHANGE",
-				Inline: true,
-			},
-		}
+	for _, queue := range queues {
+		<-queue.doneCh
+	}
+	discordDispatcher.Wait()
+}
 
-		if len(sizesValues) == 1 {
-			if sizesValues[0] == "" {
-				sizesField := discordwebhook.Field{
-					Name:   "Sizes",
-					Value:  "*none*",
-					Inline: false,
-				}
-				fields = append(fields, sizesField)
-			} else {
-				sizesField := discordwebhook.Field{
-					Name:   "Sizes",
-					Value:  sizesValues[0],
-					Inline: false,
-				}
-				fields = append(fields, sizesField)
-			}
-		} else {
-			for i, sizesValue := range sizesValues {
-				sizesField := discordwebhook.Field{
-					Name:   fmt.Sprintf("Sizes %d", i+1),
-					Value:  sizesValue,
-					Inline: !(map[int]bool{0: true}[i]),
-				}
-				fields = append(fields, sizesField)
-			}
-		}
+func (w *WebhookHandler) Emit(event *Event) {
+	w.mu.Lock()
+	stopping := w.stopping
+	w.mu.Unlock()
 
-		extraField := discordwebhook.Field{
-			Name:   "Extra",
-			Value:  fmt.Sprintf("[**StockX**](https://stockx.com/search?s=%s) | [App Link](https://applinks.sneakersnstuff.com/product/%s)", productData.Sku, productData.Sku),
-			Inline: false,
-		}
-		fields = append(fields, extraField)
+	if stopping {
+		w.logger.Yellow(fmt.Sprintf("Dropping %s event for %s, shutting down", event.Kind, event.Product.Sku))
+		return
+	}
 
-		wreq := webhookRequest{
-			productData: productData,
-			time:        time.Now(),
-			webhookUrl:  webhookUrl,
-			fields:      fields,
-		}
+	configMu.RLock()
+	window := config.Cooldown.getWindow()
+	digestDelay := config.Cooldown.getDigestDelay()
+	configMu.RUnlock()
 
-		w.enqueueReq(&wreq)
-	}
+	w.cooldown.Submit(event, window, digestDelay)
 }
 
-func (w *WebhookHandler) NotifyLoad(productData ProductData, matchingKwdQueries []string) {
+func (w *WebhookHandler) dispatch(event *Event) {
 	configMu.RLock()
 	defer configMu.RUnlock()
 
-	for _, webhookUrl := range config.LoadTask.WebhookUrls {
-		sizesValues := []string{}
-		sizesValuesCount := 0
-		if len(productData.AvailableSizes) > 25 {
-			count := 0
-			for _, availableSize := range productData.AvailableSizes {
-				strVal := fmt.Sprintf("%s [%d]", availableSize.Name, availableSize.AmountInStock)
-
-				if count == 25 {
-					count = 0
-					sizesValuesCount += 1
-				}
-
-				sizesValues[count] = fmt.Sprintf("%s\n%s", sizesValues[count], strVal)
-
-				count += 1
-			}
+	if event.WebhookUrls == nil {
+		if event.Kind == EVENT_LOAD {
+			event.WebhookUrls = loadWebhookUrls(event.Region)
 		} else {
-			finalStr := ""
-			for _, availableSize := range productData.AvailableSizes {
-				strVal := fmt.Sprintf("%s [%d]", availableSize.Name, availableSize.AmountInStock)
-
-				finalStr = fmt.Sprintf("%s\n%s", finalStr, strVal)
-			}
-
-			sizesValues = append(sizesValues, finalStr)
+			event.WebhookUrls = normalWebhookUrls(event.Region)
 		}
+	}
 
-		fields := []discordwebhook.Field{
-			{
-				Name:   "SKU/PID",
-				Value:  productData.Sku,
-				Inline: true,
-			},
-			{
-				Name:   "TYPE",
-				Value:  "LOAD",
-				Inline: true,
-			},
-			{
-				Name:   "AVAILABLE",
-				Value:  strconv.FormatBool(productData.AvailableForSale),
-				Inline: true,
-			},
-			{
-				Name:   "PRICE",
-				Value:  productData.Price,
-				Inline: true,
-			},
-		}
+	notifiers, err := getNotifiers(event.Kind)
+	if err != nil {
+		w.logger.Red(fmt.Sprintf("%s event: %v", event.Kind, err))
+	}
 
-		if len(sizesValues) == 1 {
-			if sizesValues[0] == "" {
-				sizesField := discordwebhook.Field{
-					Name:   "Sizes",
-					Value:  "*none*",
-					Inline: false,
-				}
-				fields = append(fields, sizesField)
-			} else {
-				sizesField := discordwebhook.Field{
-					Name:   "Sizes",
-					Value:  sizesValues[0],
-					Inline: false,
-				}
-				fields = append(fields, sizesField)
-			}
-		} else {
-			for i, sizesValue := range sizesValues {
-				sizesField := discordwebhook.Field{
-					Name:   fmt.Sprintf("Sizes %d", i+1),
-					Value:  sizesValue,
-					Inline: !(map[int]bool{0: true}[i]),
-				}
-				fields = append(fields, sizesField)
-			}
+	for _, notifier := range notifiers {
+		sinkEvents := applySinkFilters(notifier.Name(), event)
+		if len(sinkEvents) == 0 {
+			w.logger.Grey(fmt.Sprintf("%s: %s for %s filtered out", notifier.Name(), event.Kind, event.Product.Sku))
+			continue
 		}
 
-		if len(matchingKwdQueries) > 0 {
-			overshoot := 0
-			if len(matchingKwdQueries) >= 25 { // 25 lines is max allowed lines per field
-				overshoot = 25 - len(matchingKwdQueries)
-				matchingKwdQueries = matchingKwdQueries[:24]
-			}
-			strVal := strings.Join(matchingKwdQueries, "\n")
-			if overshoot > 0 {
-				strVal += fmt.Sprintf("*[+ %d more]*", overshoot)
+		for _, sinkEvent := range sinkEvents {
+			if replayMode {
+				w.logger.Pink(fmt.Sprintf("Replay: %s notification for %s to %s", sinkEvent.Kind, sinkEvent.Product.Sku, notifier.Name()))
+				continue
 			}
 
-			kwdQueryField := discordwebhook.Field{
-				Name:   "Keyword Query Hits",
-				Value:  strVal,
-				Inline: false,
+			job := &notifierJob{
+				notifier:  notifier,
+				event:     sinkEvent,
+				outboxKey: outboxKey(notifier.Name(), "", sinkEvent),
 			}
-			fields = append(fields, kwdQueryField)
-		}
 
-		extraField := discordwebhook.Field{
-			Name:   "Extra",
-			Value:  fmt.Sprintf("[**StockX**](https://stockx.com/search?s=%s) | [App Link](https://applinks.sneakersnstuff.com/product/%s)", productData.Sku, productData.Sku),
-			Inline: false,
-		}
-		fields = append(fields, extraField)
+			// Discord additionally tracks the delivery to each webhook url once the payload is rendered
+			added := notificationOutbox.Add(&OutboxRecord{
+				Key:     job.outboxKey,
+				Sink:    notifier.Name(),
+				Event:   sinkEvent,
+				Targets: sinkEvent.WebhookUrls,
+			})
+			if !added {
+				w.logger.Grey(fmt.Sprintf("%s: Skipping duplicate %s notification for %s", notifier.Name(), sinkEvent.Kind, sinkEvent.Product.Sku))
+				continue
+			}
 
-		wreq := webhookRequest{
-			productData: productData,
-			time:        time.Now(),
-			webhookUrl:  webhookUrl,
-			fields:      fields,
+			w.enqueueJob(job)
 		}
-
-		w.enqueueReq(&wreq)
 	}
 }
 
-func (w *WebhookHandler) createEmbed(req *webhookRequest) (*discordwebhook.Embed, error) {
-	configMu.RLock()
+// Jobs that are not queued keep their outbox record and are replayed on the next start
+func (w *WebhookHandler) enqueueJob(job *notifierJob) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 
-	avatarUrl := DEFAULT_ICON_URL
-	if config.DiscordPresence.AvatarUrl != "" {
-		avatarUrl = config.DiscordPresence.AvatarUrl
-	}
-
-	color := DEFAULT_COLOR
-	if config.DiscordPresence.EmbedColor != 0 {
-		color = config.DiscordPresence.EmbedColor
+	if w.closed {
+		w.logger.Yellow(fmt.Sprintf("%s: %s notification for %s left in the outbox, shutting down", job.notifier.Name(), job.event.Kind, job.event.Product.Sku))
+		return
 	}
 
-	footerText := DEFAULT_FOOTER_TEXT
-	if config.DiscordPresence.FooterText != "" {
-		footerText = config.DiscordPresence.FooterText
+	select {
+	case w.getQueue(job.notifier.Name()).ch <- job:
+	default:
+		w.logger.Red(fmt.Sprintf("%s: Queue full, %s notification for %s left in the outbox", job.notifier.Name(), job.event.Kind, job.event.Product.Sku))
 	}
-
-	configMu.RUnlock()
-
-	embed := discordwebhook.Embed{
-		Title:     req.productData.Title,
-		Color:     color,
-		Url:       req.productData.ProductUrl,
-		Timestamp: req.time,
-		Thumbnail: discordwebhook.Thumbnail{
-			Url: req.productData.ImageUrl,
-		},
-		Fields: req.fields,
-		Footer: discordwebhook.Footer{
-			Text:     fmt.Sprintf("%s • Sneakersnstuff", footerText),
-			Icon_url: avatarUrl,
-		},
-	}
-
-	return &embed, nil
 }
 
-func (w *WebhookHandler) sendEmbed(link string, embed *discordwebhook.Embed) {
-	configMu.RLock()
-
-	avatarUrl := DEFAULT_ICON_URL
-	if config.DiscordPresence.AvatarUrl != "" {
-		avatarUrl = config.DiscordPresence.AvatarUrl
+// Sends the deliveries that were still pending in the outbox when the monitor stopped
+func (w *WebhookHandler) ReplayOutbox() {
+	pending := notificationOutbox.Pending()
+	if len(pending) == 0 {
+		return
 	}
 
-	configMu.RUnlock()
-
-	hook := discordwebhook.Hook{
-		Username:   "Sneakersnstuff",
-		Avatar_url: avatarUrl,
-		Embeds:     []discordwebhook.Embed{*embed},
-	}
+	w.logger.Yellow(fmt.Sprintf("Replaying %d pending notifications from the outbox", len(pending)))
 
-	payload, err := json.Marshal(hook)
-	if err != nil {
-		w.logger.Red(fmt.Sprintf("Send webhook: Error marshalling payload: %v", err))
-		return
-	}
+	configMu.RLock()
+	fallbackDelay := time.Millisecond * time.Duration(config.WebhookErrorTimeout)
+
+	jobs := []*notifierJob{}
+	digests := []*OutboxRecord{}
+	for _, record := range pending {
+		if record.Sink == SINK_DIGEST {
+			digests = append(digests, record)
+			continue
+		}
 
-	req, err := http.NewRequest("POST", link, bytes.NewBuffer(payload))
-	if err != nil {
-		w.logger.Red(fmt.Sprintf("Send webhook: Error creating request: %v", err))
-	}
-	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		// Discord records with a target are rendered deliveries to one webhook url
+		if record.Sink == SINK_DISCORD && record.Target != "" {
+			discordDispatcher.Enqueue(&discordDelivery{
+				outboxKey:     record.Key,
+				url:           record.Target,
+				payload:       record.Payload,
+				kind:          record.Event.Kind,
+				sku:           record.Event.Product.Sku,
+				fallbackDelay: fallbackDelay,
+			})
+			continue
+		}
 
-	client := &http.Client{}
+		notifier, err := newNotifier(record.Sink)
+		if err != nil {
+			w.logger.Red(fmt.Sprintf("Outbox: %v", err))
+			notificationOutbox.Done(record.Key)
+			continue
+		}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		w.logger.Red(fmt.Sprintf("Send webhook: Error sending request: %v", err))
-	}
-	defer resp.Body.Close()
+		record.Event.WebhookUrls = record.Targets
 
-	bodyText, err := io.ReadAll(resp.Body)
-	if err != nil {
-		w.logger.Red(fmt.Sprintf("Send webhook: Error reading response body: %v", err))
+		jobs = append(jobs, &notifierJob{
+			notifier:  notifier,
+			event:     record.Event,
+			outboxKey: record.Key,
+		})
 	}
+	configMu.RUnlock()
 
-	if resp.StatusCode != 200 && resp.StatusCode != 204 {
-		w.logger.Red(fmt.Sprintf("Send webhook: Unexpected response (%d): %s", resp.StatusCode, bodyText))
+	for _, job := range jobs {
+		w.enqueueJob(job)
 	}
-	if resp.StatusCode == 429 {
-		configMu.RLock()
-
-		w.logger.Red(fmt.Sprintf("Send webhook: Rate limit reached. Trying again in %d milliseconds", config.WebhookErrorTimeout))
 
-		time.Sleep(time.Millisecond * time.Duration(config.WebhookErrorTimeout))
+	// Held digests were not dispatched yet, their delay has passed by now
+	for _, record := range digests {
+		record.Event.WebhookUrls = record.Targets
 
-		configMu.RUnlock()
-
-		w.sendEmbed(link, embed)
+		w.dispatch(record.Event)
+		notificationOutbox.Done(record.Key)
 	}
-
 }
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func useTestOutbox(t *testing.T) *Outbox {
+	t.Helper()
+
+	oldOutbox := notificationOutbox
+	t.Cleanup(func() { notificationOutbox = oldOutbox })
+
+	notificationOutbox = openTestOutbox(t, filepath.Join(t.TempDir(), "outbox.jsonl"))
+
+	return notificationOutbox
+}
+
+func testRestockEvent(sku string) *Event {
+	return &Event{
+		Kind:   EVENT_RESTOCK,
+		Region: "EU",
+		Time:   time.Now(),
+		Product: ProductData{
+			Sku:            sku,
+			Title:          sku,
+			AvailableSizes: []AvailableSize{{Name: "EU 42", AmountInStock: 1}},
+		},
+		Diff: EventDiff{
+			NewSizes: []AvailableSize{{Name: "EU 42", AmountInStock: 1}},
+		},
+	}
+}
+
+func TestDispatchRecordsEverySinkBeforeQueueing(t *testing.T) {
+	region := setupTestRegion(t)
+	outbox := useTestOutbox(t)
+
+	discordPath := "/webhooks/1/token"
+	config.Notifiers.Events[EVENT_RESTOCK] = []string{SINK_DISCORD, SINK_JSON}
+	config.NormalTask.WebhookUrls = []string{region.sinkUrl + discordPath}
+
+	// Not started yet, the jobs stay queued
+	handler := NewWebhookHandler()
+	handler.dispatch(testRestockEvent("DV0833-104"))
+
+	pending := outbox.Pending()
+	sinks := make(map[string]*OutboxRecord)
+	for _, record := range pending {
+		sinks[record.Sink] = record
+	}
+	if len(pending) != 2 || sinks[SINK_DISCORD] == nil || sinks[SINK_JSON] == nil {
+		t.Fatalf("got %d pending records, want one for discord and one for json", len(pending))
+	}
+	if targets := sinks[SINK_DISCORD].Targets; len(targets) != 1 || targets[0] != config.NormalTask.WebhookUrls[0] {
+		t.Errorf("got discord targets %v, want the region webhook url", targets)
+	}
+
+	handler.Start()
+	handler.Stop()
+
+	if pending := outbox.Pending(); len(pending) != 0 {
+		t.Errorf("got %d pending records after the handler stopped, want 0", len(pending))
+	}
+	if posts := region.sink.postsTo(discordPath); posts != 1 {
+		t.Errorf("got %d discord posts, want 1", posts)
+	}
+	if events := region.sink.eventsFor(EVENT_RESTOCK, "DV0833-104"); len(events) != 1 {
+		t.Errorf("got %d json events, want 1", len(events))
+	}
+}
+
+func TestReplayOutboxSendsPendingDiscordRecords(t *testing.T) {
+	region := setupTestRegion(t)
+	outbox := useTestOutbox(t)
+
+	discordPath := "/webhooks/2/token"
+	event := testRestockEvent("FQ8138-002")
+
+	// Left over from a run that stopped before the discord notifier rendered the payload
+	outbox.Add(&OutboxRecord{
+		Key:     outboxKey(SINK_DISCORD, "", event),
+		Sink:    SINK_DISCORD,
+		Event:   event,
+		Targets: []string{region.sinkUrl + discordPath},
+	})
+
+	webhookHandler.ReplayOutbox()
+	webhookHandler.Stop()
+
+	if posts := region.sink.postsTo(discordPath); posts != 1 {
+		t.Errorf("got %d discord posts, want 1", posts)
+	}
+	if pending := outbox.Pending(); len(pending) != 0 {
+		t.Errorf("got %d pending records after the replay, want 0", len(pending))
+	}
+}
+
+func TestSlowSinkDoesNotDelayOtherSinks(t *testing.T) {
+	setupTestRegion(t)
+	useTestOutbox(t)
+
+	release := make(chan struct{})
+	slowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(slowServer.Close)
+
+	filePath := filepath.Join(t.TempDir(), "notifications.jsonl")
+
+	config.Notifiers.Events[EVENT_RESTOCK] = []string{SINK_JSON, SINK_FILE}
+	config.Notifiers.Json.Urls = []string{slowServer.URL}
+	config.Notifiers.File.Path = filePath
+
+	webhookHandler.Emit(testRestockEvent("DV0833-104"))
+	webhookHandler.Emit(testRestockEvent("FQ8138-002"))
+
+	// Both events reach the file sink while the json sink hangs on the first one
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		fileBytes, _ := os.ReadFile(filePath)
+		if bytes.Count(fileBytes, []byte("\n")) == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("file sink got %d notifications while the json sink was blocked, want 2", bytes.Count(fileBytes, []byte("\n")))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	close(release)
+	webhookHandler.Stop()
+}
+
+func TestFailedTargetsAreDeadLetteredSeparately(t *testing.T) {
+	region := setupTestRegion(t)
+	useTestOutbox(t)
+
+	workingDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Chdir(workingDir)
+	})
+	err = os.Mkdir(pathLogfileFolder, os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failingServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(failingServer.Close)
+
+	config.Notifiers.Json.Urls = []string{region.sinkUrl, failingServer.URL}
+
+	webhookHandler.dispatch(testRestockEvent("DV0833-104"))
+	region.drain()
+
+	if events := region.sink.eventsFor(EVENT_RESTOCK, "DV0833-104"); len(events) != 1 {
+		t.Errorf("got %d events at the working url, want 1", len(events))
+	}
+
+	deadLetterBytes, err := os.ReadFile(pathDeadLetterLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var deadLetter DeadLetter
+
+	lines := strings.Split(strings.TrimSpace(string(deadLetterBytes)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("got %d dead letters, want 1", len(lines))
+	}
+	err = json.Unmarshal([]byte(lines[0]), &deadLetter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deadLetter.Sink != SINK_JSON || deadLetter.Target != failingServer.URL {
+		t.Errorf("got dead letter for %s %q, want only the failing url", deadLetter.Sink, deadLetter.Target)
+	}
+}
+
+func TestRedactUrlError(t *testing.T) {
+	botUrl := TELEGRAM_API_URL + "/bot123456:SECRET-TOKEN/sendMessage"
+
+	err := redactUrlError(&url.Error{Op: "Post", URL: botUrl, Err: errors.New("dial tcp: i/o timeout")})
+	if strings.Contains(err.Error(), "SECRET-TOKEN") {
+		t.Errorf("bot token in error: %v", err)
+	}
+	if !strings.Contains(err.Error(), "i/o timeout") {
+		t.Errorf("cause missing in error: %v", err)
+	}
+
+	webhookUrl := "https://discord.com/api/webhooks/1/SECRET-TOKEN"
+	if redacted := redactUrl(webhookUrl); strings.Contains(redacted, "SECRET-TOKEN") {
+		t.Errorf("webhook token in %s", redacted)
+	}
+}
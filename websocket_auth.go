@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Maximum age of a signed message. Older or future-dated messages are rejected
+const WEBSOCKET_AUTH_MAX_SKEW = 60 * time.Second
+
+// Signatures seen inside the freshness window, so a captured message can not be sent twice
+var seenSignatures map[string]time.Time = make(map[string]time.Time)
+var seenSignaturesMu sync.Mutex = sync.Mutex{}
+
+// Take configMu RLock before calling getWebsocketSecret!
+func getWebsocketSecret() string {
+	return config.WebsocketSecret
+}
+
+// Hex encoded HMAC-SHA256 of payload keyed with the shared secret
+func signPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Wraps the message JSON into a SignedMessage. The signature covers the exact bytes of the payload string
+func sealMessage(secret string, message []byte) ([]byte, error) {
+	signedMsg := SignedMessage{
+		Payload:   string(message),
+		Signature: signPayload(secret, message),
+	}
+
+	signedBytes, err := json.Marshal(signedMsg)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling signed message: %v", err)
+	}
+
+	return signedBytes, nil
+}
+
+// Checks the signature and timestamp of an incoming control message and returns the message JSON inside of it.
+// Returns the message unchanged if no secret is configured
+func openControlMessage(message []byte) ([]byte, error) {
+	configMu.RLock()
+	secret := getWebsocketSecret()
+	configMu.RUnlock()
+
+	if secret == "" {
+		return message, nil
+	}
+
+	var signedMsg SignedMessage
+
+	err := json.Unmarshal(message, &signedMsg)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling signed message: %v", err)
+	}
+	if signedMsg.Payload == "" || signedMsg.Signature == "" {
+		return nil, errors.New("message is not signed")
+	}
+
+	payload := []byte(signedMsg.Payload)
+
+	expected := signPayload(secret, payload)
+	if !hmac.Equal([]byte(signedMsg.Signature), []byte(expected)) {
+		return nil, errors.New("invalid signature")
+	}
+
+	var signed struct {
+		Timestamp int64 `json:"timestamp"`
+	}
+
+	err = json.Unmarshal(payload, &signed)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling timestamp: %v", err)
+	}
+
+	sentAt := time.Unix(signed.Timestamp, 0)
+
+	skew := time.Since(sentAt)
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > WEBSOCKET_AUTH_MAX_SKEW {
+		return nil, fmt.Errorf("timestamp outside of allowed window (%s)", skew.Round(time.Second))
+	}
+
+	seenSignaturesMu.Lock()
+	defer seenSignaturesMu.Unlock()
+
+	now := time.Now()
+	for seenSignature, seenAt := range seenSignatures {
+		if now.Sub(seenAt) > 2*WEBSOCKET_AUTH_MAX_SKEW {
+			delete(seenSignatures, seenSignature)
+		}
+	}
+
+	if _, ok := seenSignatures[signedMsg.Signature]; ok {
+		return nil, errors.New("message was already received")
+	}
+	seenSignatures[signedMsg.Signature] = now
+
+	return payload, nil
+}
+
+// Task id of a rejected message, only used to address the error response
+func unverifiedTaskId(message []byte) string {
+	var signedMsg SignedMessage
+
+	err := json.Unmarshal(message, &signedMsg)
+	if err == nil && signedMsg.Payload != "" {
+		message = []byte(signedMsg.Payload)
+	}
+
+	var messageType MessageType
+
+	json.Unmarshal(message, &messageType)
+
+	return messageType.TaskId
+}
+
+// Marshals the client hello. Sent as a SignedMessage with timestamp and nonce if a secret is configured
+func marshalClientHello(clientHelloMsg ClientHelloMessage) ([]byte, error) {
+	configMu.RLock()
+	secret := getWebsocketSecret()
+	configMu.RUnlock()
+
+	if secret == "" {
+		return json.Marshal(clientHelloMsg)
+	}
+
+	nonce := make([]byte, 16)
+
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, fmt.Errorf("error generating nonce: %v", err)
+	}
+
+	clientHelloMsg.Timestamp = time.Now().Unix()
+	clientHelloMsg.Nonce = hex.EncodeToString(nonce)
+
+	helloBytes, err := json.Marshal(clientHelloMsg)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling client hello: %v", err)
+	}
+
+	return sealMessage(secret, helloBytes)
+}
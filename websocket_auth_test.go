@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+const testWebsocketSecret = "test-secret"
+
+func useTestWebsocketSecret(t *testing.T, secret string) {
+	t.Helper()
+
+	oldConfig := config
+	t.Cleanup(func() {
+		config = oldConfig
+	})
+
+	testConfig := defaultConfig
+	testConfig.WebsocketSecret = secret
+	config = &testConfig
+}
+
+// Signed ADD message as the server sends it. The payload keeps the field order and spacing of the sender
+func testSignedAdd(t *testing.T, taskId string, sentAt time.Time) []byte {
+	t.Helper()
+
+	payload := fmt.Sprintf(`{"typeName": "ADD", "taskId": "%s", "inputType": "SKU", "addQuery": "DV0833-104", "region": "EU", "timestamp": %d}`, taskId, sentAt.Unix())
+
+	message, err := sealMessage(testWebsocketSecret, []byte(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return message
+}
+
+func TestOpenControlMessage(t *testing.T) {
+	useTestWebsocketSecret(t, testWebsocketSecret)
+
+	message := testSignedAdd(t, "open-1", time.Now())
+
+	payload, err := openControlMessage(message)
+	if err != nil {
+		t.Fatalf("signed message rejected: %v", err)
+	}
+
+	var addMessage AddMessage
+
+	err = json.Unmarshal(payload, &addMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addMessage.TaskId != "open-1" || addMessage.AddQuery != "DV0833-104" {
+		t.Errorf("got %+v from the payload", addMessage)
+	}
+
+	_, err = openControlMessage(message)
+	if err == nil {
+		t.Error("replayed message accepted")
+	}
+}
+
+func TestOpenControlMessageRejects(t *testing.T) {
+	useTestWebsocketSecret(t, testWebsocketSecret)
+
+	var signedMsg SignedMessage
+
+	err := json.Unmarshal(testSignedAdd(t, "tampered", time.Now()), &signedMsg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only whitespace changes, the signature has to cover the exact bytes
+	signedMsg.Payload += " "
+	tampered, _ := json.Marshal(signedMsg)
+
+	tests := map[string][]byte{
+		"unsigned":  []byte(`{"typeName": "ADD", "taskId": "unsigned", "addQuery": "DV0833-104"}`),
+		"tampered":  tampered,
+		"stale":     testSignedAdd(t, "stale", time.Now().Add(-2*WEBSOCKET_AUTH_MAX_SKEW)),
+		"future":    testSignedAdd(t, "future", time.Now().Add(2*WEBSOCKET_AUTH_MAX_SKEW)),
+		"malformed": []byte(`not json`),
+	}
+
+	for name, message := range tests {
+		_, err := openControlMessage(message)
+		if err == nil {
+			t.Errorf("%s message accepted", name)
+		}
+	}
+
+	if taskId := unverifiedTaskId(tampered); taskId != "tampered" {
+		t.Errorf("got task id %q of the tampered message, want tampered", taskId)
+	}
+}
+
+func TestOpenControlMessageWithoutSecret(t *testing.T) {
+	useTestWebsocketSecret(t, "")
+
+	message := []byte(`{"typeName": "LIST", "taskId": "plain"}`)
+
+	payload, err := openControlMessage(message)
+	if err != nil {
+		t.Fatalf("unsigned message rejected without secret: %v", err)
+	}
+	if string(payload) != string(message) {
+		t.Errorf("got payload %s, want the message unchanged", payload)
+	}
+}
+
+func TestMarshalClientHello(t *testing.T) {
+	useTestWebsocketSecret(t, testWebsocketSecret)
+
+	helloBytes, err := marshalClientHello(ClientHelloMessage{TypeName: "CLIENT_HELLO", MonitorType: "SNS"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload, err := openControlMessage(helloBytes)
+	if err != nil {
+		t.Fatalf("client hello rejected: %v", err)
+	}
+
+	var clientHelloMsg ClientHelloMessage
+
+	err = json.Unmarshal(payload, &clientHelloMsg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clientHelloMsg.TypeName != "CLIENT_HELLO" || clientHelloMsg.Nonce == "" || clientHelloMsg.Timestamp == 0 {
+		t.Errorf("got client hello %+v", clientHelloMsg)
+	}
+}
@@ -3,18 +3,99 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"log"
+	"math/rand"
 	"net/url"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+const (
+	WEBSOCKET_BACKOFF_MIN   = time.Second
+	WEBSOCKET_BACKOFF_MAX   = time.Minute
+	WEBSOCKET_PING_INTERVAL = 30 * time.Second
+	WEBSOCKET_PONG_WAIT     = 60 * time.Second
+	WEBSOCKET_WRITE_WAIT    = 10 * time.Second
+)
+
+// gorilla/websocket supports only one concurrent writer per connection
+var websocketWriteMu sync.Mutex = sync.Mutex{}
+
+// Closed by closeWebsocketConnection, ends the reconnect loop
+var websocketStopCh chan struct{} = make(chan struct{})
+var websocketDoneCh chan struct{} = make(chan struct{})
+
+// The established connection, nil while reconnecting
+var websocketConnMu sync.Mutex = sync.Mutex{}
+var websocketConn *websocket.Conn = nil
+
 func handleWebsocketClientConnection() {
-	defer tasksWg.Done()
+	defer close(websocketDoneCh)
+
+	backoff := WEBSOCKET_BACKOFF_MIN
+	attempt := 0
+
+	for {
+		attempt += 1
+
+		connected := runWebsocketConnection(attempt)
+		if connected {
+			backoff = WEBSOCKET_BACKOFF_MIN
+			attempt = 0
+		}
 
-	configMu.Lock()
+		select {
+		case <-websocketStopCh:
+			return
+		default:
+		}
+
+		// Exponential backoff with jitter, so several monitors do not reconnect in lockstep
+		delay := backoff + time.Duration(rand.Int63n(int64(backoff/2)+1))
+
+		websocketLogger.Yellow(fmt.Sprintf("Reconnecting to websocket server in %s", delay.Round(time.Millisecond)))
+
+		select {
+		case <-time.After(delay):
+		case <-websocketStopCh:
+			return
+		}
+
+		backoff = min(backoff*2, WEBSOCKET_BACKOFF_MAX)
+	}
+}
+
+// Sends a close frame and waits until the server answered it or the timeout passed
+func closeWebsocketConnection(timeout time.Duration) {
+	websocketConnMu.Lock()
+	close(websocketStopCh)
+	conn := websocketConn
+	websocketConnMu.Unlock()
+
+	if conn != nil {
+		closeMessage := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "shutdown")
+
+		err := conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(WEBSOCKET_WRITE_WAIT))
+		if err != nil {
+			websocketLogger.Red(fmt.Sprintf("Error sending close message: %v", err))
+		}
+	}
+
+	select {
+	case <-websocketDoneCh:
+	case <-time.After(timeout):
+		websocketLogger.Red("Websocket server did not answer the close message")
+
+		if conn != nil {
+			conn.Close()
+		}
+	}
+}
+
+// Connects and reads messages until the connection is lost. Returns if the connection had been established
+func runWebsocketConnection(attempt int) bool {
+	configMu.RLock()
 
 	// WebSocket server URL
 	serverURL := url.URL{
@@ -22,18 +103,63 @@ func handleWebsocketClientConnection() {
 		Host:   fmt.Sprintf("localhost:%d", config.WebsocketPort),
 	}
 
-	configMu.Unlock()
+	configMu.RUnlock()
 
-	websocketLogger.White("Connnecting to websocket server...")
+	websocketLogger.White(fmt.Sprintf("Connecting to websocket server (attempt %d)...", attempt))
 
 	// Establish WebSocket connection
 	conn, _, err := websocket.DefaultDialer.Dial(serverURL.String(), nil)
 	if err != nil {
-		log.Fatalf("Failed to connect to WebSocket server: %v", err)
+		websocketLogger.Red(fmt.Sprintf("Failed to connect to websocket server: %v", err))
+		return false
 	}
 	defer conn.Close()
 
-	// Send a message once connected
+	websocketConnMu.Lock()
+	select {
+	case <-websocketStopCh:
+		websocketConnMu.Unlock()
+		return false
+	default:
+	}
+	websocketConn = conn
+	websocketConnMu.Unlock()
+
+	defer func() {
+		websocketConnMu.Lock()
+		websocketConn = nil
+		websocketConnMu.Unlock()
+	}()
+
+	websocketLogger.Green(fmt.Sprintf("Connected to websocket server %s", serverURL.String()))
+
+	// Keepalive: the read deadline is extended whenever the server answers a ping
+	conn.SetReadDeadline(time.Now().Add(WEBSOCKET_PONG_WAIT))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(WEBSOCKET_PONG_WAIT))
+	})
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		ticker := time.NewTicker(WEBSOCKET_PING_INTERVAL)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(WEBSOCKET_WRITE_WAIT))
+				if err != nil {
+					websocketLogger.Red(fmt.Sprintf("Error sending ping: %v", err))
+				}
+			}
+		}
+	}()
+
+	// Send a message once connected. Sent again after every reconnect
 	go func() {
 		time.Sleep(time.Second) // Short delay to ensure connection is established
 
@@ -43,13 +169,28 @@ func handleWebsocketClientConnection() {
 	// Listen for incoming messages from the server
 	for {
 		_, message, err := conn.ReadMessage()
+		if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+			websocketLogger.White("Websocket connection closed")
+			break
+		}
 		if err != nil {
-			websocketLogger.Red(fmt.Sprintf("Error reading message: %v", err))
+			websocketLogger.Red(fmt.Sprintf("Disconnected from websocket server: %v", err))
 			break
 		}
 
 		onMessage(conn, message)
 	}
+
+	return true
+}
+
+func writeWebsocketMessage(conn *websocket.Conn, bytes []byte) error {
+	websocketWriteMu.Lock()
+	defer websocketWriteMu.Unlock()
+
+	conn.SetWriteDeadline(time.Now().Add(WEBSOCKET_WRITE_WAIT))
+
+	return conn.WriteMessage(websocket.TextMessage, bytes)
 }
 
 func sendClientHello(conn *websocket.Conn) {
@@ -57,18 +198,31 @@ func sendClientHello(conn *websocket.Conn) {
 		MonitorType: "SNS",
 		TypeName:    "CLIENT_HELLO",
 	}
-	bytes, err := json.Marshal(clientHelloMsg)
+
+	bytes, err := marshalClientHello(clientHelloMsg)
 	if err != nil {
 		websocketLogger.Red(fmt.Sprintf("Error sending client hello message: %v", err))
+		return
 	}
 
-	conn.WriteMessage(websocket.TextMessage, bytes)
+	err = writeWebsocketMessage(conn, bytes)
+	if err != nil {
+		websocketLogger.Red(fmt.Sprintf("Error writing message: %v", err))
+	}
 }
 
-var onMessage = func(conn *websocket.Conn, message []byte) {
+var onMessage = func(conn *websocket.Conn, rawMessage []byte) {
 	var messageType MessageType
 
-	err := json.Unmarshal(message, &messageType)
+	message, err := openControlMessage(rawMessage)
+	if err != nil {
+		websocketLogger.Red(fmt.Sprintf("Rejected unauthenticated message: %v", err))
+
+		sendError(conn, unverifiedTaskId(rawMessage), "Fehler: Nicht autorisiert.")
+		return
+	}
+
+	err = json.Unmarshal(message, &messageType)
 	if err != nil {
 		websocketLogger.Red(fmt.Sprintf("Error unmarshalling message type: %s", err))
 		return
@@ -84,10 +238,7 @@ var onMessage = func(conn *websocket.Conn, message []byte) {
 			return
 		}
 
-		addMessage.AddQuery = strings.TrimSpace(addMessage.AddQuery)
-		for strings.Contains(addMessage.AddQuery, "  ") {
-			addMessage.AddQuery = strings.Replace(addMessage.AddQuery, "  ", " ", -1)
-		}
+		addMessage.AddQuery = normalizeQueryInput(addMessage.AddQuery)
 
 		err = handleAdd(&addMessage)
 		if err != nil {
@@ -97,6 +248,12 @@ var onMessage = func(conn *websocket.Conn, message []byte) {
 				errText := fmt.Sprintf("Fehler: %s \"%s\" ist bereits im Monitor.", addMessage.InputType, addMessage.AddQuery)
 				sendError(conn, addMessage.TaskId, errText)
 				return
+			} else if rerr, ok := err.(*RegionNotFoundError); ok {
+				websocketLogger.Red(rerr)
+
+				errText := fmt.Sprintf("Fehler: Region \"%s\" existiert nicht.", rerr.regionName)
+				sendError(conn, addMessage.TaskId, errText)
+				return
 			} else {
 				websocketLogger.Red(fmt.Sprintf("error adding query: %v", err))
 
@@ -118,10 +275,7 @@ var onMessage = func(conn *websocket.Conn, message []byte) {
 			return
 		}
 
-		removeMessage.RemoveQuery = strings.TrimSpace(removeMessage.RemoveQuery)
-		for strings.Contains(removeMessage.RemoveQuery, "  ") {
-			removeMessage.RemoveQuery = strings.Replace(removeMessage.RemoveQuery, "  ", " ", -1)
-		}
+		removeMessage.RemoveQuery = normalizeQueryInput(removeMessage.RemoveQuery)
 
 		err := handleRemove(&removeMessage)
 		if err != nil {
@@ -131,6 +285,12 @@ var onMessage = func(conn *websocket.Conn, message []byte) {
 				errText := fmt.Sprintf("Fehler: %s \"%s\" wurde nicht gefunden.", removeMessage.InputType, removeMessage.RemoveQuery)
 				sendError(conn, removeMessage.TaskId, errText)
 				return
+			} else if rerr, ok := err.(*RegionNotFoundError); ok {
+				websocketLogger.Red(rerr)
+
+				errText := fmt.Sprintf("Fehler: Region \"%s\" existiert nicht.", rerr.regionName)
+				sendError(conn, removeMessage.TaskId, errText)
+				return
 			} else {
 				websocketLogger.Red(fmt.Sprintf("error removing query: %v", err))
 
@@ -152,8 +312,16 @@ var onMessage = func(conn *websocket.Conn, message []byte) {
 			return
 		}
 
-		list, err := handleList(&listMessage)
+		entries, err := handleList(&listMessage)
 		if err != nil {
+			if rerr, ok := err.(*RegionNotFoundError); ok {
+				websocketLogger.Red(rerr)
+
+				errText := fmt.Sprintf("Fehler: Region \"%s\" existiert nicht.", rerr.regionName)
+				sendError(conn, listMessage.TaskId, errText)
+				return
+			}
+
 			websocketLogger.Red(fmt.Sprintf("error listing query: %v", err))
 
 			sendError(conn, listMessage.TaskId, "Interner Fehler.")
@@ -161,7 +329,87 @@ var onMessage = func(conn *websocket.Conn, message []byte) {
 		}
 
 		successText := fmt.Sprintf("%s Liste:", listMessage.InputType)
-		sendSuccessList(conn, listMessage.TaskId, successText, list)
+		sendSuccessList(conn, listMessage.TaskId, successText, entries)
+	case "BULK_ADD":
+		var bulkAddMessage BulkAddMessage
+
+		err = json.Unmarshal(message, &bulkAddMessage)
+		if err != nil {
+			websocketLogger.Red(fmt.Sprintf("Error unmarshalling bulk add message: %s", err))
+			return
+		}
+
+		results, err := handleBulkAdd(&bulkAddMessage)
+		if err != nil {
+			sendBulkError(conn, bulkAddMessage.TaskId, err)
+			return
+		}
+
+		added := countBulkResults(results, BULK_STATUS_ADDED)
+
+		websocketLogger.Cyan(fmt.Sprintf("Bulk added %d of %d queries", added, len(results)))
+
+		successText := fmt.Sprintf("%d von %d Einträgen wurden hinzugefügt.", added, len(results))
+		sendBulkResults(conn, bulkAddMessage.TaskId, successText, results)
+	case "BULK_REMOVE":
+		var bulkRemoveMessage BulkRemoveMessage
+
+		err = json.Unmarshal(message, &bulkRemoveMessage)
+		if err != nil {
+			websocketLogger.Red(fmt.Sprintf("Error unmarshalling bulk remove message: %s", err))
+			return
+		}
+
+		results, err := handleBulkRemove(&bulkRemoveMessage)
+		if err != nil {
+			sendBulkError(conn, bulkRemoveMessage.TaskId, err)
+			return
+		}
+
+		removed := countBulkResults(results, BULK_STATUS_REMOVED)
+
+		websocketLogger.Cyan(fmt.Sprintf("Bulk removed %d of %d queries", removed, len(results)))
+
+		successText := fmt.Sprintf("%d von %d Einträgen wurden gelöscht.", removed, len(results))
+		sendBulkResults(conn, bulkRemoveMessage.TaskId, successText, results)
+	case "EXPORT":
+		var exportMessage ExportMessage
+
+		err = json.Unmarshal(message, &exportMessage)
+		if err != nil {
+			websocketLogger.Red(fmt.Sprintf("Error unmarshalling export message: %s", err))
+			return
+		}
+
+		watchlist, err := handleExport(&exportMessage)
+		if err != nil {
+			sendBulkError(conn, exportMessage.TaskId, err)
+			return
+		}
+
+		sendExport(conn, exportMessage.TaskId, "Export:", watchlist)
+	case "IMPORT":
+		var importMessage ImportMessage
+
+		err = json.Unmarshal(message, &importMessage)
+		if err != nil {
+			websocketLogger.Red(fmt.Sprintf("Error unmarshalling import message: %s", err))
+			return
+		}
+
+		results, err := handleImport(&importMessage)
+		if err != nil {
+			sendBulkError(conn, importMessage.TaskId, err)
+			return
+		}
+
+		added := countBulkResults(results, BULK_STATUS_ADDED)
+		removed := countBulkResults(results, BULK_STATUS_REMOVED)
+
+		websocketLogger.Cyan(fmt.Sprintf("Imported watchlist: %d added, %d removed", added, removed))
+
+		successText := fmt.Sprintf("Import abgeschlossen: %d hinzugefügt, %d gelöscht.", added, removed)
+		sendBulkResults(conn, importMessage.TaskId, successText, results)
 	default:
 		websocketLogger.Red(fmt.Sprintf("Unexpected message typename: %s", messageType.TypeName))
 		return
@@ -179,22 +427,29 @@ func sendSuccess(conn *websocket.Conn, taskId string, successText string) {
 		websocketLogger.Red(fmt.Sprintf("Error sending success message: %v", err))
 	}
 
-	conn.WriteMessage(websocket.TextMessage, bytes)
+	err = writeWebsocketMessage(conn, bytes)
+	if err != nil {
+		websocketLogger.Red(fmt.Sprintf("Error writing message: %v", err))
+	}
 }
 
-func sendSuccessList(conn *websocket.Conn, taskId string, successText string, list []string) {
+func sendSuccessList(conn *websocket.Conn, taskId string, successText string, entries []WatchEntry) {
 	successMsg := SuccessListResponse{
 		TypeName:    "SUCCESS",
 		TaskId:      taskId,
 		SuccessText: successText,
-		List:        list,
+		List:        watchEntryQueries(entries),
+		Entries:     entries,
 	}
 	bytes, err := json.Marshal(successMsg)
 	if err != nil {
 		websocketLogger.Red(fmt.Sprintf("Error sending success list message: %v", err))
 	}
 
-	conn.WriteMessage(websocket.TextMessage, bytes)
+	err = writeWebsocketMessage(conn, bytes)
+	if err != nil {
+		websocketLogger.Red(fmt.Sprintf("Error writing message: %v", err))
+	}
 }
 
 func sendError(conn *websocket.Conn, taskId string, errorText string) {
@@ -208,5 +463,66 @@ func sendError(conn *websocket.Conn, taskId string, errorText string) {
 		websocketLogger.Red(fmt.Sprintf("Error sending error message: %v", err))
 	}
 
-	conn.WriteMessage(websocket.TextMessage, bytes)
+	err = writeWebsocketMessage(conn, bytes)
+	if err != nil {
+		websocketLogger.Red(fmt.Sprintf("Error writing message: %v", err))
+	}
+}
+
+func sendBulkResults(conn *websocket.Conn, taskId string, successText string, results []BulkItemResult) {
+	bulkMsg := BulkResponse{
+		TypeName:    "SUCCESS",
+		TaskId:      taskId,
+		SuccessText: successText,
+		Results:     results,
+	}
+	bytes, err := json.Marshal(bulkMsg)
+	if err != nil {
+		websocketLogger.Red(fmt.Sprintf("Error sending bulk results message: %v", err))
+	}
+
+	err = writeWebsocketMessage(conn, bytes)
+	if err != nil {
+		websocketLogger.Red(fmt.Sprintf("Error writing message: %v", err))
+	}
+}
+
+func sendExport(conn *websocket.Conn, taskId string, successText string, watchlist Watchlist) {
+	exportMsg := ExportResponse{
+		TypeName:    "SUCCESS",
+		TaskId:      taskId,
+		SuccessText: successText,
+		Watchlist:   watchlist,
+	}
+	bytes, err := json.Marshal(exportMsg)
+	if err != nil {
+		websocketLogger.Red(fmt.Sprintf("Error sending export message: %v", err))
+	}
+
+	err = writeWebsocketMessage(conn, bytes)
+	if err != nil {
+		websocketLogger.Red(fmt.Sprintf("Error writing message: %v", err))
+	}
+}
+
+// Bulk operations only fail as a whole if a region can not be resolved
+func sendBulkError(conn *websocket.Conn, taskId string, err error) {
+	if rerr, ok := err.(*RegionNotFoundError); ok {
+		websocketLogger.Red(rerr)
+
+		errText := fmt.Sprintf("Fehler: Region \"%s\" existiert nicht.", rerr.regionName)
+		sendError(conn, taskId, errText)
+		return
+	}
+	if qerr, ok := err.(*InvalidQueryError); ok {
+		websocketLogger.Red(qerr)
+
+		errText := fmt.Sprintf("Fehler: %s \"%s\" ist ungültig.", qerr.queryType, qerr.queryValue)
+		sendError(conn, taskId, errText)
+		return
+	}
+
+	websocketLogger.Red(fmt.Sprintf("error handling bulk message: %v", err))
+
+	sendError(conn, taskId, "Interner Fehler.")
 }
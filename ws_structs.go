@@ -1,15 +1,26 @@
 package main
 
 // Websocket receive structs
+
+// Envelope of the client hello and of every control message if a websocketSecret is set.
+// Payload is the JSON of the actual message and Signature the hex HMAC-SHA256 of exactly these bytes
+type SignedMessage struct {
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
 type MessageType struct {
 	TypeName string `json:"typeName"`
+	TaskId   string `json:"taskId"`
 }
 
 type AddMessage struct {
-	TypeName  string `json:"typeName"`
-	TaskId    string `json:"taskId"`
-	InputType string `json:"inputType"`
-	AddQuery  string `json:"addQuery"`
+	TypeName  string         `json:"typeName"`
+	TaskId    string         `json:"taskId"`
+	InputType string         `json:"inputType"`
+	AddQuery  string         `json:"addQuery"`
+	Region    string         `json:"region"`
+	Metadata  *WatchMetadata `json:"metadata"`
 }
 
 type RemoveMessage struct {
@@ -17,12 +28,44 @@ type RemoveMessage struct {
 	TaskId      string `json:"taskId"`
 	InputType   string `json:"inputType"`
 	RemoveQuery string `json:"removeQuery"`
+	Region      string `json:"region"`
 }
 
 type ListMessage struct {
 	TypeName  string `json:"typeName"`
 	TaskId    string `json:"taskId"`
 	InputType string `json:"inputType"`
+	Region    string `json:"region"`
+}
+
+type BulkAddMessage struct {
+	TypeName   string         `json:"typeName"`
+	TaskId     string         `json:"taskId"`
+	Region     string         `json:"region"`
+	Skus       []string       `json:"skus"`
+	KwdQueries []string       `json:"kwdQueries"`
+	Metadata   *WatchMetadata `json:"metadata"` // Applied to every added query
+}
+
+type BulkRemoveMessage struct {
+	TypeName   string   `json:"typeName"`
+	TaskId     string   `json:"taskId"`
+	Region     string   `json:"region"`
+	Skus       []string `json:"skus"`
+	KwdQueries []string `json:"kwdQueries"`
+}
+
+type ExportMessage struct {
+	TypeName string `json:"typeName"`
+	TaskId   string `json:"taskId"`
+	Region   string `json:"region"` // Empty exports all regions
+}
+
+type ImportMessage struct {
+	TypeName  string    `json:"typeName"`
+	TaskId    string    `json:"taskId"`
+	Watchlist Watchlist `json:"watchlist"`
+	Replace   bool      `json:"replace"` // Removes queries missing in the watchlist of a region
 }
 
 // Websocket send structs
@@ -34,10 +77,11 @@ type SuccessResponse struct {
 }
 
 type SuccessListResponse struct {
-	TypeName    string   `json:"typeName"`
-	TaskId      string   `json:"taskId"`
-	SuccessText string   `json:"successText"`
-	List        []string `json:"list"`
+	TypeName    string       `json:"typeName"`
+	TaskId      string       `json:"taskId"`
+	SuccessText string       `json:"successText"`
+	List        []string     `json:"list"`
+	Entries     []WatchEntry `json:"entries"`
 }
 
 type ErrorResponse struct {
@@ -49,4 +93,44 @@ type ErrorResponse struct {
 type ClientHelloMessage struct {
 	TypeName    string `json:"typeName"`
 	MonitorType string `json:"monitorType"`
+	Timestamp   int64  `json:"timestamp,omitempty"`
+	Nonce       string `json:"nonce,omitempty"`
+}
+
+type BulkResponse struct {
+	TypeName    string           `json:"typeName"`
+	TaskId      string           `json:"taskId"`
+	SuccessText string           `json:"successText"`
+	Results     []BulkItemResult `json:"results"`
+}
+
+type ExportResponse struct {
+	TypeName    string    `json:"typeName"`
+	TaskId      string    `json:"taskId"`
+	SuccessText string    `json:"successText"`
+	Watchlist   Watchlist `json:"watchlist"`
+}
+
+type BulkItemResult struct {
+	Region    string `json:"region"`
+	InputType string `json:"inputType"`
+	Query     string `json:"query"`
+	Status    string `json:"status"`
+}
+
+// Shared by EXPORT and IMPORT
+type Watchlist struct {
+	Regions []RegionWatchlist `json:"regions"`
+}
+
+type RegionWatchlist struct {
+	Region     string                    `json:"region"`
+	Skus       []string                  `json:"skus"`
+	KwdQueries []string                  `json:"kwdQueries"`
+	Metadata   map[string]*WatchMetadata `json:"metadata,omitempty"` // Keyed by sku or keyword query
+}
+
+type WatchEntry struct {
+	Query    string         `json:"query"`
+	Metadata *WatchMetadata `json:"metadata,omitempty"`
 }